@@ -10,11 +10,23 @@ import (
 
 	"calc_service/internal/agent"
 	"calc_service/internal/auth"
+	"calc_service/internal/certtest"
 	"calc_service/internal/orchestrator"
 	"calc_service/internal/storage"
 )
 
 func setupTestEnvironment(t *testing.T) (func(), string) {
+	// The agent channel runs over mutual TLS; the agent is identified by its
+	// client certificate and has no token.
+	certs := certtest.Generate(t, "integration-agent")
+	t.Setenv("GRPC_TLS_CERT_FILE", certs.ServerCert)
+	t.Setenv("GRPC_TLS_KEY_FILE", certs.ServerKey)
+	t.Setenv("GRPC_TLS_CLIENT_CA_FILE", certs.CA)
+	t.Setenv("ORCHESTRATOR_CA_FILE", certs.CA)
+	t.Setenv("AGENT_CERT_FILE", certs.ClientCert)
+	t.Setenv("AGENT_KEY_FILE", certs.ClientKey)
+	t.Setenv("BCRYPT_COST", "4")
+
 	dbPath := "test_integration.db"
 	_ = os.Remove(dbPath)
 
@@ -31,13 +43,17 @@ func setupTestEnvironment(t *testing.T) (func(), string) {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
 
-	token, err := auth.GenerateJWT(userID)
+	orch := orchestrator.NewOrchestrator()
+	orch.Storage = stor
+
+	session, err := stor.CreateSession(userID, "integration-refresh", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	token, err := orch.Tokens.Generate(userID, auth.RoleUser, session.ID)
 	if err != nil {
 		t.Fatalf("Failed to generate token: %v", err)
 	}
-
-	orch := orchestrator.NewOrchestrator()
-	orch.Storage = stor
 	go func() {
 		if err := orch.RunServer(); err != nil && err != http.ErrServerClosed {
 			t.Logf("Orchestrator failed: %v", err)
@@ -27,7 +27,16 @@ function App() {
           <button 
             className="logout"
             onClick={() => {
+              const refreshToken = localStorage.getItem('refresh_token')
+              if (refreshToken) {
+                fetch('/api/v1/logout', {
+                  method: 'POST',
+                  headers: { 'Content-Type': 'application/json' },
+                  body: JSON.stringify({ refresh_token: refreshToken })
+                })
+              }
               localStorage.removeItem('token')
+              localStorage.removeItem('refresh_token')
               setToken('')
             }}
           >
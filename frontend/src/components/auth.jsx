@@ -20,10 +20,13 @@ export default function Auth({ setToken }) {
     if (response.ok) {
       const data = await response.json()
       localStorage.setItem('token', data.token)
+      if (data.refresh_token) {
+        localStorage.setItem('refresh_token', data.refresh_token)
+      }
       setToken(data.token)
     } else {
       const errorData = await response.json()
-      setError(errorData.error || 'Authentication failed')
+      setError(errorData.message || 'Authentication failed')
     }
   }
 
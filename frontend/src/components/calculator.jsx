@@ -23,7 +23,7 @@ export default function Calculator({ token }) {
 
       if (!response.ok) {
         const errorData = await response.json()
-        throw new Error(errorData.error || 'Calculation failed')
+        throw new Error(errorData.message || 'Calculation failed')
       }
 
       const data = await response.json()
@@ -37,45 +37,52 @@ export default function Calculator({ token }) {
   }
 
   const pollResult = async (id) => {
-    let attempts = 0
-    const maxAttempts = 30
+    try {
+      const response = await fetch(`/api/v1/expressions/${id}/events`, {
+        headers: {
+          'Authorization': `Bearer ${token}`
+        }
+      })
 
-    while (attempts < maxAttempts) {
-      try {
-        const response = await fetch(`/api/v1/expressions/${id}`, {
-          headers: {
-            'Authorization': `Bearer ${token}`
-          }
-        })
+      if (!response.ok) {
+        throw new Error('Failed to get expression status')
+      }
 
-        if (!response.ok) {
-          throw new Error('Failed to get expression status')
-        }
+      const reader = response.body.pipeThrough(new TextDecoderStream()).getReader()
+      let buffer = ''
 
-        const data = await response.json()
-        const expr = data.expression
+      while (true) {
+        const { value, done } = await reader.read()
+        if (done) break
 
-        if (expr.status === 'completed') {
-          setResult(expr.result)
-          setStatus('Completed')
-          return
-        } else if (expr.status === 'error') {
-          setStatus('Error')
-          setError('Calculation error')
+        buffer += value
+        const messages = buffer.split('\n\n')
+        buffer = messages.pop()
+
+        for (const message of messages) {
+          const event = message.match(/^event: (.*)$/m)?.[1]
+          const data = message.match(/^data: (.*)$/m)?.[1]
+          if (event !== 'expression_finished' || !data) continue
+
+          const expr = JSON.parse(data)
+          if (expr.status === 'completed') {
+            setResult(expr.result)
+            setStatus('Completed')
+          } else {
+            setStatus('Error')
+            setError(expr.status === 'expired' ? 'Deadline expired' : 'Calculation error')
+          }
+          reader.cancel()
           return
         }
-
-        attempts++
-        await new Promise(resolve => setTimeout(resolve, 1000))
-      } catch (err) {
-        setError(err.message)
-        setStatus('Error')
-        return
       }
-    }
 
-    setError('Calculation timeout')
-    setStatus('Error')
+      setError('Connection closed')
+      setStatus('Error')
+    } catch (err) {
+      setError(err.message)
+      setStatus('Error')
+    }
   }
 
   return (
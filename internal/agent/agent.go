@@ -2,6 +2,8 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log"
@@ -13,6 +15,7 @@ import (
 	"calc_service/internal/proto"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -22,13 +25,45 @@ var (
 )
 
 type Agent struct {
+	ID              string
 	ComputingPower  int
 	OrchestratorURL string
 	Conn            *grpc.ClientConn
 	Client          proto.CalculatorClient
 }
 
-func NewAgent() *Agent {
+// Config describes how an agent reaches the orchestrator. With CAFile set
+// the connection uses TLS; CertFile and KeyFile add a client certificate
+// for mutual TLS, in which case the orchestrator takes the agent identity
+// from the certificate and Token may be empty.
+type Config struct {
+	ID              string
+	Token           string
+	ComputingPower  int
+	OrchestratorURL string
+	CAFile          string
+	CertFile        string
+	KeyFile         string
+	ServerName      string
+}
+
+// agentCredentials sends the agent ID and token with every call. Once TLS
+// is configured, gRPC refuses to send them over a plaintext connection.
+type agentCredentials struct {
+	id     string
+	token  string
+	secure bool
+}
+
+func (c agentCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"x-agent-id": c.id, "x-agent-token": c.token}, nil
+}
+
+func (c agentCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+func Configuration() Config {
 	cp, err := strconv.Atoi(os.Getenv("COMPUTING_POWER"))
 	if err != nil || cp < 1 {
 		cp = 1
@@ -39,25 +74,97 @@ func NewAgent() *Agent {
 		orchestratorURL = "localhost:50051"
 	}
 
+	id := os.Getenv("AGENT_ID")
+	if id == "" {
+		hostname, _ := os.Hostname()
+		id = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	return Config{
+		ID:              id,
+		Token:           os.Getenv("AGENT_TOKEN"),
+		ComputingPower:  cp,
+		OrchestratorURL: orchestratorURL,
+		CAFile:          os.Getenv("ORCHESTRATOR_CA_FILE"),
+		CertFile:        os.Getenv("AGENT_CERT_FILE"),
+		KeyFile:         os.Getenv("AGENT_KEY_FILE"),
+		ServerName:      os.Getenv("ORCHESTRATOR_SERVER_NAME"),
+	}
+}
+
+func NewAgent() *Agent {
+	a, err := New(Configuration())
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+		return nil
+	}
+	return a
+}
+
+func New(cfg Config) (*Agent, error) {
+	creds, err := cfg.transportCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Token == "" && cfg.CertFile == "" {
+		log.Println("Neither AGENT_TOKEN nor AGENT_CERT_FILE is set, the orchestrator will reject this agent")
+	}
+	if cfg.CAFile == "" {
+		log.Println("ORCHESTRATOR_CA_FILE is not set, the agent token is sent in plaintext; use this only for local development")
+	}
+
 	conn, err := grpc.Dial(
-		orchestratorURL,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		cfg.OrchestratorURL,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(agentCredentials{id: cfg.ID, token: cfg.Token, secure: cfg.CAFile != ""}),
 		grpc.WithBlock(),
 		grpc.WithTimeout(5*time.Second),
 	)
 	if err != nil {
-		log.Fatalf("did not connect: %v", err)
-		return nil
+		return nil, err
 	}
 
 	client := proto.NewCalculatorClient(conn)
 
 	return &Agent{
-		ComputingPower:  cp,
-		OrchestratorURL: orchestratorURL,
+		ID:              cfg.ID,
+		ComputingPower:  cfg.ComputingPower,
+		OrchestratorURL: cfg.OrchestratorURL,
 		Conn:            conn,
 		Client:          client,
+	}, nil
+}
+
+func (cfg Config) transportCredentials() (credentials.TransportCredentials, error) {
+	if cfg.CAFile == "" {
+		if cfg.CertFile != "" {
+			return nil, errors.New("AGENT_CERT_FILE requires ORCHESTRATOR_CA_FILE")
+		}
+		return insecure.NewCredentials(), nil
+	}
+
+	pem, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", cfg.CAFile)
+	}
+	tlsConfig := &tls.Config{
+		RootCAs:    pool,
+		ServerName: cfg.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
+	return credentials.NewTLS(tlsConfig), nil
 }
 
 func (a *Agent) Start() {
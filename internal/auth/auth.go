@@ -2,16 +2,28 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	RoleUser          = "user"
+	RoleAdmin         = "admin"
+	RoleAgentOperator = "agent-operator"
+)
+
+var Roles = []string{RoleUser, RoleAdmin, RoleAgentOperator}
+
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
-	secretKey             = []byte("your-secret-key")
+	ErrUnknownKey         = errors.New("unknown signing key")
 )
 
 type User struct {
@@ -20,6 +32,97 @@ type User struct {
 	Password string
 }
 
+// Key is a signing key identified by the kid header of the tokens it signs.
+// HS256 keys carry a shared Secret; RS256 and EdDSA keys carry a PEM encoded
+// PrivateKey, or only a PublicKey when the key is kept to verify tokens it
+// signed earlier.
+type Key struct {
+	ID         string `json:"kid"`
+	Algorithm  string `json:"alg"`
+	Secret     string `json:"secret,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+	PublicKey  string `json:"public_key,omitempty"`
+}
+
+// Config describes how access tokens are signed and validated. Tokens are
+// signed with ActiveKey; every key in Keys is accepted when validating, so a
+// retired key can stay in the list until the tokens it signed have expired.
+type Config struct {
+	Keys      []Key
+	ActiveKey string
+	Issuer    string
+	Audience  string
+	TTL       time.Duration
+	Leeway    time.Duration
+}
+
+type Claims struct {
+	UserID    int    `json:"user_id"`
+	Role      string `json:"role,omitempty"`
+	SessionID int    `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type Tokens struct {
+	cfg    Config
+	keys   map[string]*signingKey
+	order  []*signingKey
+	parser *jwt.Parser
+}
+
+func NewTokens(cfg Config) (*Tokens, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("no signing keys configured")
+	}
+	if cfg.TTL <= 0 {
+		return nil, errors.New("token lifetime must be positive")
+	}
+
+	keys := make(map[string]*signingKey, len(cfg.Keys))
+	order := make([]*signingKey, 0, len(cfg.Keys))
+	methods := make(map[string]bool)
+	for _, k := range cfg.Keys {
+		sk, err := parseKey(k)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := keys[sk.id]; ok {
+			return nil, fmt.Errorf("duplicate key %q", sk.id)
+		}
+		keys[sk.id] = sk
+		order = append(order, sk)
+		methods[sk.method.Alg()] = true
+	}
+	if cfg.ActiveKey == "" && len(cfg.Keys) == 1 {
+		cfg.ActiveKey = cfg.Keys[0].ID
+	}
+	active, ok := keys[cfg.ActiveKey]
+	if !ok {
+		return nil, fmt.Errorf("active key %q is not configured", cfg.ActiveKey)
+	}
+	if active.sign == nil {
+		return nil, fmt.Errorf("active key %q has no private key", cfg.ActiveKey)
+	}
+
+	valid := make([]string, 0, len(methods))
+	for alg := range methods {
+		valid = append(valid, alg)
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(valid),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	return &Tokens{cfg: cfg, keys: keys, order: order, parser: jwt.NewParser(opts...)}, nil
+}
+
 func GetUserIDFromContext(ctx context.Context) (int, error) {
 	userID, ok := ctx.Value("userID").(int)
 	if !ok {
@@ -28,35 +131,110 @@ func GetUserIDFromContext(ctx context.Context) (int, error) {
 	return userID, nil
 }
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
-}
+// Generate issues an access token. Tokens that belong to a login session
+// carry its id so they stop working once the session is revoked.
+func (t *Tokens) Generate(userID int, role string, sessionID int) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        randomToken(16),
+			Issuer:    t.cfg.Issuer,
+			Subject:   fmt.Sprint(userID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(t.cfg.TTL)),
+		},
+	}
+	if t.cfg.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{t.cfg.Audience}
+	}
 
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	key := t.keys[t.cfg.ActiveKey]
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.sign)
 }
 
-func GenerateJWT(userID int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	})
-	return token.SignedString(secretKey)
+func (t *Tokens) TTL() time.Duration {
+	return t.cfg.TTL
 }
 
-func ParseJWT(tokenString string) (int, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return secretKey, nil
+func (t *Tokens) Parse(tokenString string) (*Claims, error) {
+	var claims Claims
+	_, err := t.parser.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := t.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("key %q does not sign %s tokens", kid, token.Method.Alg())
+		}
+		return key.verify, nil
 	})
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if claims.UserID == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	if claims.Role == "" {
+		claims.Role = RoleUser
+	}
+	return &claims, nil
+}
+
+// JWKS returns the public keys other services can use to verify tokens.
+// Shared HS256 secrets are never published.
+func (t *Tokens) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range t.order {
+		if jwk, ok := k.jwk(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
 	}
+	return set
+}
+
+// NewRefreshToken returns an opaque refresh token and the hash it is stored
+// under.
+func NewRefreshToken() (string, string) {
+	token := randomToken(32)
+	return token, HashToken(token)
+}
+
+// HashToken is how refresh tokens, API keys and share links are stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+const (
+	APIKeyPrefix    = "calc_"
+	apiKeyPrefixLen = len(APIKeyPrefix) + 8
+)
+
+// NewAPIKey returns a new API key, the prefix shown to the user to identify
+// it and the hash it is stored under.
+func NewAPIKey() (key, prefix, hash string) {
+	key = APIKeyPrefix + randomToken(32)
+	return key, key[:apiKeyPrefixLen], HashToken(key)
+}
+
+// NewShareToken returns the token of a public expression link and the hash
+// it is stored under.
+func NewShareToken() (string, string) {
+	token := randomToken(32)
+	return token, HashToken(token)
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userID := int(claims["user_id"].(float64))
-		return userID, nil
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
 	}
-	return 0, ErrInvalidCredentials
+	return base64.RawURLEncoding.EncodeToString(b)
 }
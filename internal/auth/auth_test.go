@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	oldSecret = "0123456789abcdef0123456789abcdef-old"
+	newSecret = "0123456789abcdef0123456789abcdef-new"
+)
+
+func testConfig() Config {
+	return Config{
+		Keys:      []Key{{ID: "k1", Secret: oldSecret}},
+		ActiveKey: "k1",
+		Issuer:    "calc_service",
+		Audience:  "calc_service",
+		TTL:       time.Hour,
+	}
+}
+
+func newTestTokens(t *testing.T, cfg Config) *Tokens {
+	t.Helper()
+	tokens, err := NewTokens(cfg)
+	if err != nil {
+		t.Fatalf("NewTokens failed: %v", err)
+	}
+	return tokens
+}
+
+func sign(t *testing.T, kid, secret string, claims Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return s
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	tokens := newTestTokens(t, testConfig())
+
+	token, err := tokens.Generate(42, RoleAdmin, 3)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	claims, err := tokens.Parse(token)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if claims.UserID != 42 || claims.Role != RoleAdmin || claims.SessionID != 3 {
+		t.Errorf("Expected admin 42 in session 3, got %+v", claims)
+	}
+	if claims.ID == "" {
+		t.Error("Expected a token id")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	before := newTestTokens(t, testConfig())
+	oldToken, _ := before.Generate(1, RoleUser, 0)
+
+	cfg := testConfig()
+	cfg.Keys = append(cfg.Keys, Key{ID: "k2", Secret: newSecret})
+	cfg.ActiveKey = "k2"
+	during := newTestTokens(t, cfg)
+
+	if _, err := during.Parse(oldToken); err != nil {
+		t.Errorf("Token signed with the retiring key was rejected: %v", err)
+	}
+	newToken, _ := during.Generate(1, RoleUser, 0)
+	if _, err := before.Parse(newToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey for a key the verifier does not know, got %v", err)
+	}
+
+	cfg.Keys = cfg.Keys[1:]
+	after := newTestTokens(t, cfg)
+	if _, err := after.Parse(oldToken); err == nil {
+		t.Error("Token signed with a removed key was accepted")
+	}
+	if _, err := after.Parse(newToken); err != nil {
+		t.Errorf("Token signed with the active key was rejected: %v", err)
+	}
+}
+
+func TestTokenValidation(t *testing.T) {
+	tokens := newTestTokens(t, testConfig())
+	now := time.Now()
+
+	valid := func() Claims {
+		return Claims{
+			UserID: 7,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "calc_service",
+				Audience:  jwt.ClaimStrings{"calc_service"},
+				NotBefore: jwt.NewNumericDate(now.Add(-time.Minute)),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+		}
+	}
+
+	if _, err := tokens.Parse(sign(t, "k1", oldSecret, valid())); err != nil {
+		t.Fatalf("Valid token rejected: %v", err)
+	}
+
+	cases := map[string]func(*Claims){
+		"expired":        func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) },
+		"no expiry":      func(c *Claims) { c.ExpiresAt = nil },
+		"not yet valid":  func(c *Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour)) },
+		"wrong issuer":   func(c *Claims) { c.Issuer = "someone-else" },
+		"wrong audience": func(c *Claims) { c.Audience = jwt.ClaimStrings{"other-service"} },
+		"no user":        func(c *Claims) { c.UserID = 0 },
+	}
+	for name, mutate := range cases {
+		claims := valid()
+		mutate(&claims)
+		if _, err := tokens.Parse(sign(t, "k1", oldSecret, claims)); err == nil {
+			t.Errorf("%s: token was accepted", name)
+		}
+	}
+
+	if _, err := tokens.Parse(sign(t, "k1", "your-secret-key", valid())); err == nil {
+		t.Error("Token signed with the wrong secret was accepted")
+	}
+	if _, err := tokens.Parse(sign(t, "", oldSecret, valid())); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey for a token without kid, got %v", err)
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, valid())
+	none.Header["kid"] = "k1"
+	unsigned, _ := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if _, err := tokens.Parse(unsigned); err == nil {
+		t.Error("Unsigned token was accepted")
+	}
+}
+
+func TestNewTokensRejectsBadConfig(t *testing.T) {
+	cases := map[string]func(*Config){
+		"no keys":        func(c *Config) { c.Keys = nil },
+		"short secret":   func(c *Config) { c.Keys = []Key{{ID: "k1", Secret: "your-secret-key"}} },
+		"unknown active": func(c *Config) { c.ActiveKey = "k9" },
+		"duplicate kid":  func(c *Config) { c.Keys = append(c.Keys, Key{ID: "k1", Secret: newSecret}) },
+		"zero lifetime":  func(c *Config) { c.TTL = 0 },
+	}
+	for name, mutate := range cases {
+		cfg := testConfig()
+		mutate(&cfg)
+		if _, err := NewTokens(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAsymmetricTokens(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GenerateKey("asym", alg)
+			if err != nil {
+				t.Fatalf("GenerateKey failed: %v", err)
+			}
+			cfg := testConfig()
+			cfg.Keys = append(cfg.Keys, key)
+			cfg.ActiveKey = "asym"
+			tokens := newTestTokens(t, cfg)
+
+			token, err := tokens.Generate(5, RoleUser, 0)
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			parsed, _, _ := jwt.NewParser().ParseUnverified(token, &Claims{})
+			if parsed.Method.Alg() != alg || parsed.Header["kid"] != "asym" {
+				t.Fatalf("Expected %s token with kid asym, got %v", alg, parsed.Header)
+			}
+			if claims, err := tokens.Parse(token); err != nil || claims.UserID != 5 {
+				t.Fatalf("Parse returned %+v, %v", claims, err)
+			}
+
+			set := tokens.JWKS()
+			if len(set.Keys) != 1 || set.Keys[0].KeyID != "asym" || set.Keys[0].Algorithm != alg {
+				t.Fatalf("Expected only the asymmetric key in JWKS, got %+v", set.Keys)
+			}
+
+			retired := testConfig()
+			retired.Keys = append(retired.Keys, Key{ID: "asym", Algorithm: alg, PublicKey: publicPEM(t, tokens.keys["asym"].public)})
+			verifier := newTestTokens(t, retired)
+			if _, err := verifier.Parse(token); err != nil {
+				t.Errorf("Public key failed to verify token: %v", err)
+			}
+
+			retired.ActiveKey = "asym"
+			if _, err := NewTokens(retired); err == nil {
+				t.Error("Expected a key without private half to be rejected as active key")
+			}
+
+			hmacToken := sign(t, "asym", publicPEM(t, tokens.keys["asym"].public), Claims{
+				UserID: 5,
+				RegisteredClaims: jwt.RegisteredClaims{
+					Issuer:    cfg.Issuer,
+					Audience:  jwt.ClaimStrings{cfg.Audience},
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+			})
+			if _, err := tokens.Parse(hmacToken); err == nil {
+				t.Error("HS256 token signed with the public key was accepted")
+			}
+		})
+	}
+}
+
+func publicPEM(t *testing.T, pub crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// Argon2Params are the argon2id parameters. Memory is in KiB.
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultArgon2 follows the second recommended option of RFC 9106.
+var DefaultArgon2 = Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4, SaltLen: 16, KeyLen: 32}
+
+// Hasher hashes new passwords with Algorithm. Hashes made with another
+// algorithm or other parameters still verify, and NeedsRehash reports them
+// so they can be upgraded the next time the password is known.
+type Hasher struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+// DefaultHasher is bcrypt with cost 14.
+var DefaultHasher = Hasher{Algorithm: HashBcrypt, BcryptCost: 14, Argon2: DefaultArgon2}
+
+// Validate reports parameters Hash cannot work with.
+func (h Hasher) Validate() error {
+	switch h.Algorithm {
+	case HashBcrypt:
+		if h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case HashArgon2id:
+		p := h.Argon2
+		if p.Time < 1 || p.Threads < 1 || p.Memory < 8*uint32(p.Threads) {
+			return errors.New("argon2id needs at least one pass, one thread and 8 KiB of memory per thread")
+		}
+		if p.SaltLen < 8 || p.KeyLen < 16 {
+			return errors.New("argon2id needs a salt of at least 8 bytes and a key of at least 16")
+		}
+	default:
+		return fmt.Errorf("unknown password hash %q", h.Algorithm)
+	}
+	return nil
+}
+
+func (h Hasher) Hash(password string) (string, error) {
+	if h.Algorithm == HashArgon2id {
+		return hashArgon2id(password, h.Argon2)
+	}
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+	return string(bytes), err
+}
+
+// NeedsRehash reports whether hash was made with another algorithm or other
+// parameters than Hash would use now.
+func (h Hasher) NeedsRehash(hash string) bool {
+	if h.Algorithm == HashArgon2id {
+		p, _, _, err := decodeArgon2id(hash)
+		return err != nil || p != h.Argon2
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.BcryptCost
+}
+
+// CheckPasswordHash verifies a password against a bcrypt or argon2id hash.
+func CheckPasswordHash(password, hash string) bool {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	p, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+// hashArgon2id encodes the hash in the PHC string format used by the
+// reference implementation: $argon2id$v=19$m=...,t=...,p=...$salt$key.
+func hashArgon2id(password string, p Argon2Params) (string, error) {
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func decodeArgon2id(hash string) (p Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return p, nil, nil, errors.New("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, fmt.Errorf("argon2id parameters: %w", err)
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, fmt.Errorf("argon2id salt: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, nil, nil, fmt.Errorf("argon2id key: %w", err)
+	}
+	p.SaltLen, p.KeyLen = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
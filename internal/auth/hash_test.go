@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2 = Argon2Params{Time: 1, Memory: 64, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+func TestHasher(t *testing.T) {
+	bcryptHasher := Hasher{Algorithm: HashBcrypt, BcryptCost: bcrypt.MinCost}
+	argonHasher := Hasher{Algorithm: HashArgon2id, Argon2: testArgon2}
+
+	for _, h := range []Hasher{bcryptHasher, argonHasher} {
+		if err := h.Validate(); err != nil {
+			t.Fatalf("Validate(%s) failed: %v", h.Algorithm, err)
+		}
+		hash, err := h.Hash("correct-horse")
+		if err != nil {
+			t.Fatalf("Hash(%s) failed: %v", h.Algorithm, err)
+		}
+		if !CheckPasswordHash("correct-horse", hash) {
+			t.Errorf("Expected the %s hash to verify", h.Algorithm)
+		}
+		if CheckPasswordHash("wrong-horse", hash) {
+			t.Errorf("Expected a wrong password to fail against the %s hash", h.Algorithm)
+		}
+		if h.NeedsRehash(hash) {
+			t.Errorf("Expected a fresh %s hash to be current", h.Algorithm)
+		}
+	}
+
+	argonHash, _ := argonHasher.Hash("correct-horse")
+	if !strings.HasPrefix(argonHash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Unexpected argon2id encoding %q", argonHash)
+	}
+	bcryptHash, _ := bcryptHasher.Hash("correct-horse")
+
+	stronger := argonHasher
+	stronger.Argon2.Time = 2
+	tests := []struct {
+		name   string
+		hasher Hasher
+		hash   string
+		want   bool
+	}{
+		{"bcrypt to argon2id", argonHasher, bcryptHash, true},
+		{"argon2id to bcrypt", bcryptHasher, argonHash, true},
+		{"higher bcrypt cost", Hasher{Algorithm: HashBcrypt, BcryptCost: bcrypt.MinCost + 1}, bcryptHash, true},
+		{"more argon2id passes", stronger, argonHash, true},
+		{"garbage", bcryptHasher, "not-a-hash", true},
+	}
+	for _, tt := range tests {
+		if got := tt.hasher.NeedsRehash(tt.hash); got != tt.want {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if CheckPasswordHash("correct-horse", "$argon2id$v=19$m=64,t=1,p=1$!!$!!") {
+		t.Error("Expected a malformed argon2id hash to fail")
+	}
+	for _, h := range []Hasher{{Algorithm: "md5"}, {Algorithm: HashBcrypt, BcryptCost: 99}, {Algorithm: HashArgon2id}} {
+		if err := h.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", h)
+		}
+	}
+}
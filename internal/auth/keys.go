@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	minSecretLength = 32
+	minRSABits      = 2048
+)
+
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	sign   interface{}
+	verify interface{}
+	public crypto.PublicKey
+}
+
+func parseKey(k Key) (*signingKey, error) {
+	if k.ID == "" {
+		return nil, errors.New("signing key without kid")
+	}
+	if k.Algorithm == "" {
+		k.Algorithm = AlgHS256
+	}
+
+	sk := &signingKey{id: k.ID}
+	switch k.Algorithm {
+	case AlgHS256:
+		if len(k.Secret) < minSecretLength {
+			return nil, fmt.Errorf("secret of key %q is shorter than %d bytes", k.ID, minSecretLength)
+		}
+		sk.method = jwt.SigningMethodHS256
+		sk.sign = []byte(k.Secret)
+		sk.verify = []byte(k.Secret)
+
+	case AlgRS256:
+		sk.method = jwt.SigningMethodRS256
+		var pub *rsa.PublicKey
+		if k.PrivateKey != "" {
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(k.PrivateKey))
+			if err != nil {
+				return nil, fmt.Errorf("private key %q: %w", k.ID, err)
+			}
+			sk.sign = priv
+			pub = &priv.PublicKey
+		} else if k.PublicKey != "" {
+			var err error
+			if pub, err = jwt.ParseRSAPublicKeyFromPEM([]byte(k.PublicKey)); err != nil {
+				return nil, fmt.Errorf("public key %q: %w", k.ID, err)
+			}
+		} else {
+			return nil, fmt.Errorf("key %q has neither a private nor a public key", k.ID)
+		}
+		if pub.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key %q is shorter than %d bits", k.ID, minRSABits)
+		}
+		sk.verify = pub
+		sk.public = pub
+
+	case AlgEdDSA:
+		sk.method = jwt.SigningMethodEdDSA
+		var pub ed25519.PublicKey
+		if k.PrivateKey != "" {
+			priv, err := jwt.ParseEdPrivateKeyFromPEM([]byte(k.PrivateKey))
+			if err != nil {
+				return nil, fmt.Errorf("private key %q: %w", k.ID, err)
+			}
+			sk.sign = priv
+			pub = priv.(ed25519.PrivateKey).Public().(ed25519.PublicKey)
+		} else if k.PublicKey != "" {
+			key, err := jwt.ParseEdPublicKeyFromPEM([]byte(k.PublicKey))
+			if err != nil {
+				return nil, fmt.Errorf("public key %q: %w", k.ID, err)
+			}
+			pub = key.(ed25519.PublicKey)
+		} else {
+			return nil, fmt.Errorf("key %q has neither a private nor a public key", k.ID)
+		}
+		sk.verify = pub
+		sk.public = pub
+
+	default:
+		return nil, fmt.Errorf("key %q uses unsupported algorithm %q", k.ID, k.Algorithm)
+	}
+	return sk, nil
+}
+
+// GenerateKey creates a random key for the algorithm, with the private half
+// PEM encoded as Key expects it.
+func GenerateKey(id, alg string) (Key, error) {
+	key := Key{ID: id, Algorithm: alg}
+
+	var priv interface{}
+	switch alg {
+	case AlgHS256:
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return key, err
+		}
+		key.Secret = hex.EncodeToString(b)
+		return key, nil
+	case AlgRS256:
+		k, err := rsa.GenerateKey(rand.Reader, minRSABits)
+		if err != nil {
+			return key, err
+		}
+		priv = k
+	case AlgEdDSA:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return key, err
+		}
+		priv = k
+	default:
+		return key, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return key, err
+	}
+	key.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	return key, nil
+}
+
+// JWK is the public half of a signing key in RFC 7517 form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKey decodes an RSA, P-256 or Ed25519 key published by another
+// issuer.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	dec := base64.RawURLEncoding
+	switch {
+	case k.KeyType == "RSA":
+		n, err := dec.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: modulus: %w", k.KeyID, err)
+		}
+		e, err := dec.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("key %q: invalid exponent", k.KeyID)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case k.KeyType == "EC" && k.Curve == "P-256":
+		x, errX := dec.DecodeString(k.X)
+		y, errY := dec.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("key %q: invalid coordinates", k.KeyID)
+		}
+		// ecdh rejects points that are not on the curve.
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.KeyID, err)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case k.KeyType == "OKP" && k.Curve == "Ed25519":
+		x, err := dec.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("key %q: invalid Ed25519 key", k.KeyID)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("key %q has unsupported type %q", k.KeyID, k.KeyType)
+}
+
+func (k *signingKey) jwk() (JWK, bool) {
+	enc := base64.RawURLEncoding
+	jwk := JWK{KeyID: k.id, Use: "sig", Algorithm: k.method.Alg()}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = enc.EncodeToString(pub.N.Bytes())
+		jwk.E = enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = enc.EncodeToString(pub)
+	default:
+		return jwk, false
+	}
+	return jwk, true
+}
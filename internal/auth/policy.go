@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrPasswordBreached = errors.New("password appears in a list of breached passwords")
+	ErrPasswordIsLogin  = errors.New("password must not equal the login")
+)
+
+// PasswordPolicy decides which passwords users may choose. MinLength counts
+// characters; MaxLength counts bytes, since bcrypt ignores everything past
+// the 72nd.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	breached  map[string]struct{}
+}
+
+// LoadBreached reads a breached-password list, one entry per line. An entry
+// of 40 hex digits, optionally followed by ":count" as in the Have I Been
+// Pwned downloads, is taken as the SHA-1 of a password; anything else is
+// the password itself.
+func (p *PasswordPolicy) LoadBreached(r io.Reader) error {
+	if p.breached == nil {
+		p.breached = make(map[string]struct{})
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1(hash) {
+			p.breached[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		p.breached[passwordSHA1(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// Breached returns the number of entries in the breached-password list.
+func (p *PasswordPolicy) Breached() int {
+	return len(p.breached)
+}
+
+// Check returns an error describing why the password is not acceptable.
+func (p *PasswordPolicy) Check(login, password string) error {
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return fmt.Errorf("password must be at most %d bytes", p.MaxLength)
+	}
+	if strings.EqualFold(password, login) {
+		return ErrPasswordIsLogin
+	}
+	if _, ok := p.breached[passwordSHA1(password)]; ok {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+func isSHA1(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func passwordSHA1(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 72}
+	// SHA-1 of "password1" in the HIBP format, and a plain entry.
+	list := "E38AD214943DAAD1D64C102FAEC29DE4AFE9DA3D:2401761\r\nletmein123\n\n"
+	if err := policy.LoadBreached(strings.NewReader(list)); err != nil {
+		t.Fatalf("LoadBreached failed: %v", err)
+	}
+	if policy.Breached() != 2 {
+		t.Errorf("Expected 2 breached entries, got %d", policy.Breached())
+	}
+
+	tests := []struct {
+		login, password string
+		ok              bool
+	}{
+		{"alice", "correct-horse", true},
+		{"alice", "short", false},
+		{"alice", "пароль12", true},
+		{"alice", strings.Repeat("x", 73), false},
+		{"alice-smith", "ALICE-SMITH", false},
+		{"alice", "password1", false},
+		{"alice", "letmein123", false},
+	}
+	for _, tt := range tests {
+		if err := policy.Check(tt.login, tt.password); (err == nil) != tt.ok {
+			t.Errorf("Check(%q, %q) = %v, want ok=%v", tt.login, tt.password, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package certtest generates throwaway certificates for TLS tests.
+package certtest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Files are the paths of the generated PEM files. The server certificate is
+// valid for localhost, 127.0.0.1 and ::1; the client certificate carries the
+// requested common name.
+type Files struct {
+	CA         string
+	ServerCert string
+	ServerKey  string
+	ClientCert string
+	ClientKey  string
+}
+
+type issuer struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// Generate creates a CA with a server and a client certificate in a
+// temporary directory that is removed when the test ends.
+func Generate(t testing.TB, clientName string) Files {
+	t.Helper()
+	dir := t.TempDir()
+
+	ca := newCertificate(t, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "certtest CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, filepath.Join(dir, "ca.pem"), "")
+
+	files := Files{
+		CA:         filepath.Join(dir, "ca.pem"),
+		ServerCert: filepath.Join(dir, "server.pem"),
+		ServerKey:  filepath.Join(dir, "server-key.pem"),
+		ClientCert: filepath.Join(dir, "client.pem"),
+		ClientKey:  filepath.Join(dir, "client-key.pem"),
+	}
+	newCertificate(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, files.ServerCert, files.ServerKey)
+	newCertificate(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: clientName},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, files.ClientCert, files.ClientKey)
+	return files
+}
+
+// newCertificate signs template with parent, or self-signs it when parent is
+// nil, and writes the certificate and, if keyPath is set, its key.
+func newCertificate(t testing.TB, parent *issuer, template *x509.Certificate, certPath, keyPath string) *issuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		t.Fatalf("Failed to generate serial: %v", err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(24 * time.Hour)
+
+	signer := &issuer{cert: template, key: key}
+	if parent != nil {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, key.Public(), signer.key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	writePEM(t, certPath, "CERTIFICATE", der)
+	if keyPath != "" {
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("Failed to marshal key: %v", err)
+		}
+		writePEM(t, keyPath, "PRIVATE KEY", pkcs8)
+	}
+	return &issuer{cert: cert, key: key}
+}
+
+func writePEM(t testing.TB, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
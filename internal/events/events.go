@@ -0,0 +1,103 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	TaskDispatched     = "task_dispatched"
+	TaskCompleted      = "task_completed"
+	ExpressionFinished = "expression_finished"
+)
+
+const (
+	subscriberBuffer    = 32
+	allSubscriberBuffer = 1024
+)
+
+type Event struct {
+	Type         string    `json:"type"`
+	ExpressionID int       `json:"expression_id"`
+	UserID       int       `json:"-"`
+	TaskID       string    `json:"task_id,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	Result       *float64  `json:"result,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+type subscribers map[int]map[chan Event]struct{}
+
+type Broker struct {
+	mu       sync.Mutex
+	subs     subscribers
+	userSubs subscribers
+	allSubs  subscribers
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subs:     make(subscribers),
+		userSubs: make(subscribers),
+		allSubs:  make(subscribers),
+	}
+}
+
+func (b *Broker) Subscribe(exprID int) (<-chan Event, func()) {
+	return b.subscribe(b.subs, exprID, subscriberBuffer)
+}
+
+func (b *Broker) SubscribeUser(userID int) (<-chan Event, func()) {
+	return b.subscribe(b.userSubs, userID, subscriberBuffer)
+}
+
+func (b *Broker) SubscribeAll() (<-chan Event, func()) {
+	return b.subscribe(b.allSubs, 0, allSubscriberBuffer)
+}
+
+func (b *Broker) subscribe(subs subscribers, key, buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	b.mu.Lock()
+	if subs[key] == nil {
+		subs[key] = make(map[chan Event]struct{})
+	}
+	subs[key][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(subs[key], ch)
+			if len(subs[key]) == 0 {
+				delete(subs, key)
+			}
+			b.mu.Unlock()
+		})
+	}
+}
+
+func (b *Broker) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	send(b.subs[e.ExpressionID], e)
+	if e.UserID != 0 {
+		send(b.userSubs[e.UserID], e)
+	}
+	send(b.allSubs[0], e)
+}
+
+func send(chans map[chan Event]struct{}, e Event) {
+	for ch := range chans {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"calc_service/internal/oidc"
+	"calc_service/internal/oidctest"
+)
+
+// authorize runs the browser part of the flow and returns the code.
+func authorize(t *testing.T, p *oidc.Provider, nonce, verifier string) string {
+	t.Helper()
+	target, err := p.AuthCodeURL(context.Background(), "state", nonce, oidc.Challenge(verifier))
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatalf("Authorization request failed: %v", err)
+	}
+	resp.Body.Close()
+	back, _ := url.Parse(resp.Header.Get("Location"))
+	return back.Query().Get("code")
+}
+
+func TestExchange(t *testing.T) {
+	mock := oidctest.NewProvider(t)
+	p := oidc.New(mock.Config("http://localhost/callback"))
+
+	code := authorize(t, p, "n-1", "verifier-1")
+	id, err := p.Exchange(context.Background(), code, "verifier-1", "n-1")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if id.Issuer != mock.Issuer() || id.Subject != "1001" || id.PreferredUsername != "alice" || !id.EmailVerified {
+		t.Errorf("Unexpected identity %+v", id)
+	}
+
+	code = authorize(t, p, "n-2", "verifier-2")
+	if _, err := p.Exchange(context.Background(), code, "other-verifier", "n-2"); err == nil {
+		t.Error("Expected a wrong PKCE verifier to be rejected")
+	}
+	code = authorize(t, p, "n-3", "verifier-3")
+	if _, err := p.Exchange(context.Background(), code, "verifier-3", "other-nonce"); !errors.Is(err, oidc.ErrNonceMismatch) {
+		t.Errorf("Expected ErrNonceMismatch, got %v", err)
+	}
+
+	tests := map[string]func(jwt.MapClaims){
+		"wrong audience": func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+		"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no expiry":      func(c jwt.MapClaims) { delete(c, "exp") },
+		"no subject":     func(c jwt.MapClaims) { delete(c, "sub") },
+	}
+	for name, tamper := range tests {
+		mock.Tamper(tamper)
+		code := authorize(t, p, "n", "verifier")
+		if _, err := p.Exchange(context.Background(), code, "verifier", "n"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Errorf("%s: expected ErrInvalidIDToken, got %v", name, err)
+		}
+	}
+}
+
+func TestVerifyRejectsForeignSignatures(t *testing.T) {
+	mock := oidctest.NewProvider(t)
+	p := oidc.New(mock.Config("http://localhost/callback"))
+
+	claims := jwt.MapClaims{"iss": mock.Issuer(), "aud": oidctest.ClientID, "sub": "1001", "exp": time.Now().Add(time.Minute).Unix()}
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmac.Header["kid"] = "mock-key"
+	symmetric, _ := hmac.SignedString([]byte(oidctest.ClientSecret))
+
+	for name, raw := range map[string]string{"none": unsigned, "HS256": symmetric} {
+		if _, err := p.Verify(context.Background(), raw, ""); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Errorf("%s: expected ErrInvalidIDToken, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/storage"
+)
+
+// requiresSession reports whether the route changes credentials or the
+// account itself; API keys are not accepted there.
+func requiresSession(r *http.Request) bool {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/v1/api-keys"):
+		return true
+	case r.URL.Path == "/api/v1/me/password":
+		return true
+	case r.URL.Path == "/api/v1/me" && r.Method == http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (o *Orchestrator) meHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	user, err := o.Storage.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "User not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userResponse(user))
+}
+
+func (o *Orchestrator) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	var req struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+	if req.OldPassword == "" || req.NewPassword == "" {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "old_password and new_password are required")
+		return
+	}
+
+	user, err := o.Storage.GetUserByID(userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get user")
+		return
+	}
+	if !auth.CheckPasswordHash(req.OldPassword, user.Password) {
+		writeError(w, http.StatusForbidden, "invalid_credentials", "Old password is incorrect")
+		return
+	}
+	if err := o.Config.PasswordPolicy.Check(user.Login, req.NewPassword); err != nil {
+		o.audit(r, auditPasswordRejected, user.ID, user.Login, err.Error())
+		writeError(w, http.StatusBadRequest, "weak_password", err.Error())
+		return
+	}
+
+	hash, err := o.Config.Hasher.Hash(req.NewPassword)
+	if err != nil {
+		log.Printf("Failed to hash password: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	if err := o.Storage.ChangePassword(userID, hash); err != nil {
+		log.Printf("Failed to change password: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to change password")
+		return
+	}
+
+	// Every session, including the caller's, was revoked; hand out a fresh
+	// one so the client that changed the password stays signed in.
+	pair, err := o.startSession(userID)
+	if err != nil {
+		log.Printf("Failed to start session: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pair)
+}
+
+func (o *Orchestrator) deleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	if err := o.Storage.DeleteUser(userID); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "User not found")
+			return
+		}
+		log.Printf("Failed to delete user %d: %v", userID, err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to delete account")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,118 @@
+package orchestrator
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/storage"
+)
+
+func TestChangePassword(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	hash, _ := o.Config.Hasher.Hash("old-password")
+	userID, _ := o.Storage.CreateUser("alice", hash)
+	laptop, _ := o.startSession(userID)
+	phone, _ := o.startSession(userID)
+
+	rec, resp := doJSON(t, h, http.MethodGet, "/api/v1/me", laptop.Token, "")
+	if rec.Code != http.StatusOK || resp["login"] != "alice" || resp["role"] != auth.RoleUser {
+		t.Fatalf("Expected alice's profile, got %d: %v", rec.Code, resp)
+	}
+
+	rec, resp = doJSON(t, h, http.MethodPost, "/api/v1/api-keys", laptop.Token, `{"name":"ci"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	key, _ := resp["key"].(string)
+
+	if rec, _ := doJSON(t, h, http.MethodPut, "/api/v1/me/password", laptop.Token, `{"old_password":"wrong","new_password":"new-password"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a wrong old password, got %d", rec.Code)
+	}
+
+	rec, resp = doJSON(t, h, http.MethodPut, "/api/v1/me/password", laptop.Token, `{"old_password":"old-password","new_password":"new-password"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	fresh, _ := resp["token"].(string)
+
+	for name, token := range map[string]string{"laptop": laptop.Token, "phone": phone.Token} {
+		if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/me", token, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the %s session to be revoked, got %d", name, rec.Code)
+		}
+	}
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/refresh", "", fmt.Sprintf(`{"refresh_token":%q}`, phone.RefreshToken)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected old refresh tokens to be rejected, got %d", rec.Code)
+	}
+	if rec := doAPIKey(t, h, http.MethodGet, "/api/v1/expressions", "X-API-Key", key, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected API keys to be revoked, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/me", fresh, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected the returned token to work, got %d", rec.Code)
+	}
+
+	user, _ := o.Storage.GetUserByID(userID)
+	if !auth.CheckPasswordHash("new-password", user.Password) {
+		t.Error("Expected the new password to be stored")
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("leaver", "hash")
+	session, _ := o.startSession(userID)
+	otherID, _ := o.Storage.CreateUser("stayer", "hash")
+	other, _ := o.startSession(otherID)
+
+	_, resp := doJSON(t, h, http.MethodPost, "/api/v1/api-keys", session.Token, `{"name":"ci"}`)
+	key, _ := resp["key"].(string)
+	if rec := doAPIKey(t, h, http.MethodGet, "/api/v1/me", "X-API-Key", key, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected API keys to read the profile, got %d", rec.Code)
+	}
+	if rec := doAPIKey(t, h, http.MethodDelete, "/api/v1/me", "X-API-Key", key, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected API keys to be unable to delete the account, got %d", rec.Code)
+	}
+	if rec := doAPIKey(t, h, http.MethodPut, "/api/v1/me/password", "X-API-Key", key, `{"old_password":"a","new_password":"b"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected API keys to be unable to change the password, got %d", rec.Code)
+	}
+
+	doJSON(t, h, http.MethodPost, "/api/v1/webhooks", session.Token, `{"url":"http://example.com/hook"}`)
+	_, resp = doJSON(t, h, http.MethodPost, "/api/v1/calculate", session.Token, `{"expression":"2+2*2"}`)
+	exprID, _ := resp["id"].(string)
+	_, resp = doJSON(t, h, http.MethodPost, "/api/v1/calculate", other.Token, `{"expression":"1+1"}`)
+	otherExprID, _ := resp["id"].(string)
+
+	if rec, _ := doJSON(t, h, http.MethodDelete, "/api/v1/me", session.Token, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if _, err := o.Storage.GetUserByID(userID); err != storage.ErrNotFound {
+		t.Errorf("Expected the user to be gone, got %v", err)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/me", session.Token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the session to end with the account, got %d", rec.Code)
+	}
+	if rec := doAPIKey(t, h, http.MethodGet, "/api/v1/me", "X-API-Key", key, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the API key to be deleted, got %d", rec.Code)
+	}
+
+	var n int
+	o.Storage.GetDB().QueryRow("SELECT COUNT(*) FROM tasks WHERE expression_id = ?", exprID).Scan(&n)
+	if n != 0 {
+		t.Errorf("Expected the user's tasks to be deleted, %d left", n)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/expressions/"+otherExprID, other.Token, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected other users' expressions to survive, got %d", rec.Code)
+	}
+}
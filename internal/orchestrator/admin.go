@@ -0,0 +1,221 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/storage"
+)
+
+const adminTaskListLimit = 500
+
+func userResponse(u *storage.User) map[string]interface{} {
+	item := map[string]interface{}{
+		"id":       u.ID,
+		"login":    u.Login,
+		"role":     u.Role,
+		"disabled": u.DisabledAt != nil,
+	}
+	if u.DisabledAt != nil {
+		item["disabled_at"] = u.DisabledAt.UTC().Format(time.RFC3339)
+	}
+	if u.LockedUntil != nil && time.Now().Before(*u.LockedUntil) {
+		item["locked_until"] = u.LockedUntil.UTC().Format(time.RFC3339)
+	}
+	return item
+}
+
+func validRole(role string) bool {
+	for _, r := range auth.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Orchestrator) adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := o.Storage.ListUsers()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get users")
+		return
+	}
+
+	response := make([]map[string]interface{}, len(users))
+	for i, u := range users {
+		response[i] = userResponse(u)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"users": response})
+}
+
+func (o *Orchestrator) adminUpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid user ID")
+		return
+	}
+
+	var req struct {
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid_body", "Invalid Body")
+		return
+	}
+	if req.Role != nil && !validRole(*req.Role) {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Unknown role")
+		return
+	}
+
+	self, _ := r.Context().Value("userID").(int)
+	if id == self && ((req.Role != nil && *req.Role != auth.RoleAdmin) || (req.Disabled != nil && *req.Disabled)) {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Administrators cannot demote or disable themselves")
+		return
+	}
+
+	if req.Role != nil {
+		err = o.Storage.SetUserRole(id, *req.Role)
+	}
+	if err == nil && req.Disabled != nil {
+		err = o.Storage.SetUserDisabled(id, *req.Disabled)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "User not found")
+			return
+		}
+		log.Printf("Failed to update user: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to update user")
+		return
+	}
+
+	user, err := o.Storage.GetUserByID(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get user")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userResponse(user))
+}
+
+func (o *Orchestrator) adminExpressionsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := storage.ExpressionFilter{
+		Statuses: q["status"],
+		Search:   q.Get("search"),
+		Sort:     q.Get("sort"),
+		Cursor:   q.Get("cursor"),
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid limit")
+			return
+		}
+		filter.Limit = limit
+	}
+	for name, dst := range map[string]**time.Time{"created_after": &filter.CreatedAfter, "created_before": &filter.CreatedBefore} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid "+name)
+				return
+			}
+			*dst = &t
+		}
+	}
+
+	var exprs []*storage.Expression
+	var next string
+	var err error
+	if v := q.Get("user_id"); v != "" {
+		userID, perr := strconv.Atoi(v)
+		if perr != nil {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid user_id")
+			return
+		}
+		exprs, next, err = o.Storage.ListExpressions(userID, filter)
+	} else {
+		exprs, next, err = o.Storage.ListAllExpressions(filter)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) || errors.Is(err, storage.ErrInvalidSort) {
+			writeError(w, http.StatusBadRequest, "invalid_argument", err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get expressions")
+		return
+	}
+
+	response := make([]map[string]interface{}, len(exprs))
+	for i, expr := range exprs {
+		item := expressionResponse(expr)
+		item["user_id"] = expr.UserID
+		response[i] = item
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expressions": response,
+		"next_cursor": next,
+	})
+}
+
+func (o *Orchestrator) adminTasksHandler(w http.ResponseWriter, r *http.Request) {
+	tasks, err := o.Storage.ListOpenTasks(adminTaskListLimit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get tasks")
+		return
+	}
+
+	response := make([]map[string]interface{}, len(tasks))
+	for i, t := range tasks {
+		item := map[string]interface{}{
+			"id":             t.ID,
+			"expression_id":  strconv.Itoa(t.ExprID),
+			"arg1":           t.Arg1,
+			"arg2":           t.Arg2,
+			"operation":      t.Operation,
+			"operation_time": t.OperationTime,
+		}
+		if t.StartedAt.Valid {
+			item["started_at"] = t.StartedAt.Time.UTC().Format(time.RFC3339)
+		}
+		if t.AgentID.Valid {
+			item["agent_id"] = t.AgentID.String
+		}
+		if t.LeaseExpires.Valid {
+			item["lease_expires_at"] = t.LeaseExpires.Time.UTC().Format(time.RFC3339)
+		}
+		response[i] = item
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tasks": response})
+}
+
+func (o *Orchestrator) adminTaskActionHandler(action func(string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := action(r.PathValue("id")); err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				writeError(w, http.StatusNotFound, "not_found", "Task not found")
+			case errors.Is(err, storage.ErrNotPending):
+				writeError(w, http.StatusConflict, "failed_precondition", "Task is not pending")
+			default:
+				log.Printf("Failed to update task: %v", err)
+				writeError(w, http.StatusInternalServerError, "internal_error", "Failed to update task")
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
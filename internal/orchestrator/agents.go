@@ -0,0 +1,117 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	calculatorServicePrefix = "/calc_service.Calculator/"
+	agentIDHeader           = "X-Agent-ID"
+	agentTokenHeader        = "X-Agent-Token"
+	agentIDMetadata         = "x-agent-id"
+	agentTokenMetadata      = "x-agent-token"
+)
+
+var errInvalidAgent = errors.New("invalid agent credentials")
+
+// AgentConfig holds the credentials agents authenticate with. Token is
+// shared by every agent; Keys gives individual agents their own key, which
+// then is the only one accepted for that agent ID.
+type AgentConfig struct {
+	Token    string
+	Keys     map[string]string
+	LeaseTTL time.Duration
+}
+
+// agentConfiguration reads AGENT_TOKEN and AGENT_KEYS_FILE, a JSON object
+// mapping agent IDs to keys.
+func agentConfiguration() AgentConfig {
+	cfg := AgentConfig{
+		Token:    os.Getenv("AGENT_TOKEN"),
+		LeaseTTL: time.Duration(envInt("TASK_LEASE_SECONDS", 60)) * time.Second,
+	}
+	if path := os.Getenv("AGENT_KEYS_FILE"); path != "" {
+		if err := json.Unmarshal(readFile("AGENT_KEYS_FILE", path), &cfg.Keys); err != nil {
+			log.Fatalf("failed to parse AGENT_KEYS_FILE: %v", err)
+		}
+	}
+	if cfg.Token == "" && len(cfg.Keys) == 0 {
+		log.Println("Neither AGENT_TOKEN nor AGENT_KEYS_FILE is set, agents will be rejected")
+	}
+	return cfg
+}
+
+// authenticateAgent checks an agent's credentials and returns its ID.
+func (o *Orchestrator) authenticateAgent(id, token string) (string, error) {
+	if id == "" || token == "" {
+		return "", errNoCredentials
+	}
+	want, ok := o.Config.Agents.Keys[id]
+	if !ok {
+		want = o.Config.Agents.Token
+	}
+	if want == "" || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		return "", errInvalidAgent
+	}
+	return id, nil
+}
+
+func (o *Orchestrator) authenticateAgentContext(ctx context.Context) (context.Context, error) {
+	if id := certAgentID(ctx); id != "" {
+		return context.WithValue(ctx, "agentID", id), nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var id, token string
+	if values := md.Get(agentIDMetadata); len(values) > 0 {
+		id = values[0]
+	}
+	if values := md.Get(agentTokenMetadata); len(values) > 0 {
+		token = values[0]
+	}
+
+	agentID, err := o.authenticateAgent(id, token)
+	if errors.Is(err, errNoCredentials) {
+		return nil, status.Error(codes.Unauthenticated, "agent credentials are required")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid agent credentials")
+	}
+	return context.WithValue(ctx, "agentID", agentID), nil
+}
+
+func agentIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value("agentID").(string)
+	return id
+}
+
+// agentMiddleware guards the routes agents poll over HTTP.
+func (o *Orchestrator) agentMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentID, err := o.authenticateAgent(
+			strings.TrimSpace(r.Header.Get(agentIDHeader)),
+			strings.TrimSpace(r.Header.Get(agentTokenHeader)),
+		)
+		if errors.Is(err, errNoCredentials) {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Agent credentials are required")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid agent credentials")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "agentID", agentID)))
+	})
+}
@@ -0,0 +1,157 @@
+package orchestrator
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"calc_service/internal/proto"
+	"calc_service/internal/storage"
+)
+
+func newAgentTestOrchestrator(t *testing.T) *Orchestrator {
+	o := newTestOrchestrator(t)
+	o.Config.Agents = AgentConfig{
+		Token:    "shared-agent-token",
+		Keys:     map[string]string{"agent-b": "agent-b-key"},
+		LeaseTTL: time.Minute,
+	}
+	return o
+}
+
+func newCalculatorClient(t *testing.T, o *Orchestrator) proto.CalculatorClient {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(o.authUnaryInterceptor),
+		grpc.ChainStreamInterceptor(o.authStreamInterceptor),
+	)
+	proto.RegisterCalculatorServer(srv, &server{o: o})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewCalculatorClient(conn)
+}
+
+func agentContext(id, token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), agentIDMetadata, id, agentTokenMetadata, token)
+}
+
+func TestCalculatorRequiresAgentCredentials(t *testing.T) {
+	o := newAgentTestOrchestrator(t)
+	client := newCalculatorClient(t, o)
+
+	userID, _ := o.Storage.CreateUser("agentuser", "hash")
+	expr, _ := o.Storage.CreateExpression(userID, "2+2")
+	o.Storage.CreateTask(&storage.Task{ID: "1", ExprID: expr.ID, Arg1: 2, Arg2: 2, Operation: "+", OperationTime: 100})
+
+	cases := map[string]context.Context{
+		"no credentials":         context.Background(),
+		"wrong token":            agentContext("agent-a", "guess"),
+		"no agent id":            agentContext("", "shared-agent-token"),
+		"shared token for keyed": agentContext("agent-b", "shared-agent-token"),
+		"user token instead":     metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+mustToken(t, o, userID)),
+	}
+	for name, ctx := range cases {
+		if _, err := client.GetTask(ctx, &proto.TaskRequest{}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s: expected Unauthenticated, got %v", name, err)
+		}
+	}
+
+	task, err := client.GetTask(agentContext("agent-a", "shared-agent-token"), &proto.TaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask failed: %v", err)
+	}
+
+	_, err = client.SubmitResult(agentContext("agent-b", "agent-b-key"), &proto.ResultRequest{Id: task.Id, Result: 5})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition for a result from another agent, got %v", err)
+	}
+	if _, err := client.SubmitResult(agentContext("agent-a", "shared-agent-token"), &proto.ResultRequest{Id: task.Id, Result: 4}); err != nil {
+		t.Fatalf("SubmitResult failed: %v", err)
+	}
+
+	got, _ := o.Storage.GetExpressionByID(expr.ID, userID)
+	if got.Status != "completed" || got.Result == nil || *got.Result != 4 {
+		t.Errorf("Expected the lease holder's result, got %+v", got)
+	}
+}
+
+func TestInternalTaskRoutes(t *testing.T) {
+	o := newAgentTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("agentuser", "hash")
+	token := mustToken(t, o, userID)
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/calculate", token, `{"expression":"2+2"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", rec.Code)
+	}
+
+	if rec, _ := doJSON(t, h, http.MethodGet, "/internal/task", token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected user tokens to be rejected on the agent router, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/internal/task", token, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the old route to be gone, got %d", rec.Code)
+	}
+
+	doAgent := func(method, id, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/internal/task", strings.NewReader(body))
+		req.Header.Set(agentIDHeader, id)
+		req.Header.Set(agentTokenHeader, key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := doAgent(http.MethodGet, "agent-b", "wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong agent key, got %d", rec.Code)
+	}
+	rec := doAgent(http.MethodGet, "agent-b", "agent-b-key", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	task, _ := o.Storage.ListOpenTasks(1)
+	if len(task) != 1 || task[0].AgentID.String != "agent-b" {
+		t.Fatalf("Expected the task to be leased to agent-b, got %+v", task)
+	}
+
+	body := `{"id":"` + task[0].ID + `","result":4}`
+	if rec := doAgent(http.MethodPost, "agent-a", "shared-agent-token", body); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a result from another agent, got %d", rec.Code)
+	}
+	if rec := doAgent(http.MethodPost, "agent-b", "agent-b-key", body); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 from the lease holder, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func mustToken(t *testing.T, o *Orchestrator, userID int) string {
+	t.Helper()
+	session, err := o.startSession(userID)
+	if err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+	return session.Token
+}
@@ -0,0 +1,220 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/storage"
+)
+
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+
+	apiKeyHeader        = "X-API-Key"
+	apiKeyMetadata      = "x-api-key"
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	apiKeyScopes = []string{scopeRead, scopeWrite}
+
+	errNoCredentials = errors.New("no credentials")
+	errAPIKeyExpired = errors.New("api key expired")
+)
+
+func (p *principal) allows(scope string) bool {
+	if p.APIKeyID == 0 {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// requestScope is the scope an API key needs for the request: reads need
+// "read", everything else "write".
+func requestScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return scopeRead
+	}
+	return scopeWrite
+}
+
+// authenticateCredentials accepts an API key in its own header or as
+// "Authorization: ApiKey ...", and a session token as "Bearer ...".
+func (o *Orchestrator) authenticateCredentials(authorization, apiKey string) (*principal, error) {
+	if apiKey == "" && strings.HasPrefix(authorization, "ApiKey ") {
+		apiKey = strings.TrimPrefix(authorization, "ApiKey ")
+	}
+	if apiKey != "" {
+		return o.authenticateAPIKey(apiKey)
+	}
+
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == "" {
+		return nil, errNoCredentials
+	}
+	return o.authenticate(token)
+}
+
+func (o *Orchestrator) authenticateAPIKey(key string) (*principal, error) {
+	k, err := o.Storage.GetAPIKeyByHash(auth.HashToken(key))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(now) {
+		return nil, errAPIKeyExpired
+	}
+	user, err := o.Storage.GetUserByID(k.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt != nil {
+		return nil, errAccountDisabled
+	}
+	if err := o.Storage.TouchAPIKey(k.ID, now, apiKeyTouchInterval); err != nil {
+		log.Printf("Failed to record API key use: %v", err)
+	}
+	return &principal{UserID: k.UserID, Role: user.Role, APIKeyID: k.ID, Scopes: k.Scopes}, nil
+}
+
+func apiKeyResponse(k *storage.APIKey) map[string]interface{} {
+	item := map[string]interface{}{
+		"id":         strconv.Itoa(k.ID),
+		"name":       k.Name,
+		"prefix":     k.Prefix,
+		"scopes":     k.Scopes,
+		"created_at": k.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if k.ExpiresAt != nil {
+		item["expires_at"] = k.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if k.LastUsedAt != nil {
+		item["last_used_at"] = k.LastUsedAt.UTC().Format(time.RFC3339)
+	}
+	return item
+}
+
+func validScopes(scopes []string) bool {
+	for _, s := range scopes {
+		known := false
+		for _, allowed := range apiKeyScopes {
+			known = known || s == allowed
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *Orchestrator) apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := o.Storage.GetAPIKeys(userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get API keys")
+			return
+		}
+
+		response := make([]map[string]interface{}, len(keys))
+		for i, k := range keys {
+			response[i] = apiKeyResponse(k)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"api_keys": response})
+
+	case http.MethodPost:
+		var req struct {
+			Name      string     `json:"name"`
+			Scopes    []string   `json:"scopes"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "invalid_body", "Invalid Body")
+			return
+		}
+		if req.Name == "" {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "Name is required")
+			return
+		}
+		if len(req.Scopes) == 0 {
+			req.Scopes = apiKeyScopes
+		}
+		if !validScopes(req.Scopes) {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "Unknown scope, expected read or write")
+			return
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "expires_at must be in the future")
+			return
+		}
+
+		key, prefix, hash := auth.NewAPIKey()
+		k, err := o.Storage.CreateAPIKey(userID, req.Name, prefix, hash, req.Scopes, req.ExpiresAt)
+		if err != nil {
+			log.Printf("Failed to create API key: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal_error", "Failed to create API key")
+			return
+		}
+
+		response := apiKeyResponse(k)
+		response["key"] = key
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	}
+}
+
+func (o *Orchestrator) apiKeyIDHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api-keys/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid API key ID")
+		return
+	}
+
+	if err := o.Storage.DeleteAPIKey(id, userID); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "API key not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to revoke API key")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,136 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/proto"
+)
+
+func doAPIKey(t *testing.T, h http.Handler, method, path, header, value, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(header, value)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIKeys(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("ciuser", "hash")
+	token := mustToken(t, o, userID)
+
+	rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/api-keys", token, `{"name":"ci"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	key, _ := resp["key"].(string)
+	if !strings.HasPrefix(key, auth.APIKeyPrefix) || !strings.HasPrefix(key, resp["prefix"].(string)) {
+		t.Fatalf("Unexpected key %q with prefix %v", key, resp["prefix"])
+	}
+	fullID, _ := resp["id"].(string)
+
+	_, resp = doJSON(t, h, http.MethodPost, "/api/v1/api-keys", token, `{"name":"dashboard","scopes":["read"]}`)
+	readKey, _ := resp["key"].(string)
+
+	if rec := doAPIKey(t, h, http.MethodPost, "/api/v1/calculate", "X-API-Key", key, `{"expression":"1+1"}`); rec.Code != http.StatusCreated {
+		t.Errorf("Expected 201 with X-API-Key, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doAPIKey(t, h, http.MethodGet, "/api/v1/expressions", "Authorization", "ApiKey "+readKey, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 with Authorization: ApiKey, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doAPIKey(t, h, http.MethodPost, "/api/v1/calculate", "X-API-Key", readKey, `{"expression":"1+1"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a read-only key, got %d", rec.Code)
+	}
+	if rec := doAPIKey(t, h, http.MethodGet, "/api/v1/api-keys", "X-API-Key", key, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected API keys to be unable to manage keys, got %d", rec.Code)
+	}
+	if rec := doAPIKey(t, h, http.MethodGet, "/api/v1/expressions", "X-API-Key", key+"x", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unknown key, got %d", rec.Code)
+	}
+
+	_, resp = doJSON(t, h, http.MethodGet, "/api/v1/api-keys", token, "")
+	keys, _ := resp["api_keys"].([]interface{})
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %v", resp)
+	}
+	first := keys[0].(map[string]interface{})
+	if first["last_used_at"] == nil || first["key"] != nil {
+		t.Errorf("Expected last_used_at and no secret in listing, got %v", first)
+	}
+
+	client := newExpressionClient(t, o)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", readKey)
+	if _, err := client.List(ctx, &proto.ListExpressionsRequest{}); err != nil {
+		t.Errorf("Expected gRPC List with a read key to succeed, got %v", err)
+	}
+	if _, err := client.Submit(ctx, &proto.SubmitRequest{Expression: "1+1"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied for gRPC Submit with a read key, got %v", err)
+	}
+
+	if rec, _ := doJSON(t, h, http.MethodDelete, "/api/v1/api-keys/"+fullID, token, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 on revoke, got %d", rec.Code)
+	}
+	if rec := doAPIKey(t, h, http.MethodGet, "/api/v1/expressions", "X-API-Key", key, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a revoked key, got %d", rec.Code)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	oldKey, prefix, hash := auth.NewAPIKey()
+	o.Storage.CreateAPIKey(userID, "old", prefix, hash, apiKeyScopes, &expired)
+	if rec := doAPIKey(t, h, http.MethodGet, "/api/v1/expressions", "X-API-Key", oldKey, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an expired key, got %d", rec.Code)
+	}
+}
+
+func TestAPIKeysRejectedOnAdminEndpoints(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	adminID, _ := o.Storage.CreateUser("root", "hash")
+	o.Storage.SetUserRole(adminID, auth.RoleAdmin)
+	admin, _ := o.startSession(adminID)
+	userID, _ := o.Storage.CreateUser("alice", "hash")
+
+	_, resp := doJSON(t, h, http.MethodPost, "/api/v1/api-keys", admin.Token, `{"name":"dashboard","scopes":["read"]}`)
+	readKey, _ := resp["key"].(string)
+	_, resp = doJSON(t, h, http.MethodPost, "/api/v1/api-keys", admin.Token, `{"name":"ci"}`)
+	key, _ := resp["key"].(string)
+
+	path := fmt.Sprintf("/api/v1/admin/users/%d", userID)
+	if rec := doAPIKey(t, h, http.MethodPatch, path, "X-API-Key", readKey, `{"role":"admin"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for an admin's read key, got %d", rec.Code)
+	}
+	if rec := doAPIKey(t, h, http.MethodPatch, path, "X-API-Key", key, `{"role":"admin"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for an admin's read/write key, got %d", rec.Code)
+	}
+	if rec := doAPIKey(t, h, http.MethodGet, "/api/v1/admin/users", "X-API-Key", readKey, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 listing users with an API key, got %d", rec.Code)
+	}
+	if user, _ := o.Storage.GetUserByID(userID); user.Role != auth.RoleUser {
+		t.Errorf("Expected the role to stay %q, got %q", auth.RoleUser, user.Role)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/admin/users", admin.Token, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected the admin's session token to still work, got %d", rec.Code)
+	}
+}
@@ -15,13 +15,43 @@ type ASTNode struct {
 	TaskScheduled bool
 }
 
+// ParseError reports a syntax error at a position of the expression with
+// whitespace removed.
+type ParseError struct {
+	Message  string
+	Position int
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Position)
+}
+
+// maxParseNesting bounds how deeply parentheses may nest, so that the
+// recursive descent cannot run out of stack whatever the depth quota is.
+const maxParseNesting = 10000
+
+// NestingError reports parentheses nested deeper than the parser allows.
+type NestingError struct {
+	Limit int
+}
+
+func (e *NestingError) Error() string {
+	return fmt.Sprintf("expression is nested deeper than %d", e.Limit)
+}
+
 func ParseAST(expression string) (*ASTNode, error) {
+	return parseAST(expression, maxParseNesting)
+}
+
+// parseAST parses the expression, failing with a NestingError as soon as
+// parentheses nest deeper than maxNesting.
+func parseAST(expression string, maxNesting int) (*ASTNode, error) {
 	expr := strings.ReplaceAll(expression, " ", "")
 	if expr == "" {
 		return nil, fmt.Errorf("empty expression")
 	}
 
-	p := &parser{input: expr, pos: 0}
+	p := &parser{input: expr, pos: 0, maxNesting: min(maxNesting, maxParseNesting)}
 	node, err := p.parseExpression()
 
 	if err != nil {
@@ -29,14 +59,16 @@ func ParseAST(expression string) (*ASTNode, error) {
 	}
 
 	if p.pos < len(p.input) {
-		return nil, fmt.Errorf("unexpected token at position %d", p.pos)
+		return nil, &ParseError{Message: "unexpected token", Position: p.pos}
 	}
 	return node, nil
 }
 
 type parser struct {
-	input string
-	pos   int
+	input      string
+	pos        int
+	nesting    int
+	maxNesting int
 }
 
 func (p *parser) peek() rune {
@@ -113,12 +145,16 @@ func (p *parser) parseFactor() (*ASTNode, error) {
 	ch := p.peek()
 	if ch == '(' {
 		p.get()
+		if p.nesting++; p.nesting > p.maxNesting {
+			return nil, &NestingError{Limit: p.maxNesting}
+		}
 		node, err := p.parseExpression()
+		p.nesting--
 		if err != nil {
 			return nil, err
 		}
 		if p.peek() != ')' {
-			return nil, fmt.Errorf("missing closing parenthesis")
+			return nil, &ParseError{Message: "missing closing parenthesis", Position: p.pos}
 		}
 		p.get()
 		return node, nil
@@ -140,15 +176,36 @@ func (p *parser) parseFactor() (*ASTNode, error) {
 
 	token := p.input[start:p.pos]
 	if token == "" {
-		return nil, fmt.Errorf("expected number at position %d", start)
+		return nil, &ParseError{Message: "expected number", Position: start}
 	}
 
 	value, err := strconv.ParseFloat(token, 64)
 	if err != nil {
-		return nil, fmt.Errorf("invalid number %s", token)
+		return nil, &ParseError{Message: "invalid number " + token, Position: start}
 	}
 	return &ASTNode{
 		IsLeaf: true,
 		Value:  value,
 	}, nil
 }
+
+func (n *ASTNode) Size() int {
+	if n == nil {
+		return 0
+	}
+	return 1 + n.Left.Size() + n.Right.Size()
+}
+
+func (n *ASTNode) Depth() int {
+	if n == nil {
+		return 0
+	}
+	return 1 + max(n.Left.Depth(), n.Right.Depth())
+}
+
+func (n *ASTNode) OperationCount() int {
+	if n == nil || n.IsLeaf {
+		return 0
+	}
+	return 1 + n.Left.OperationCount() + n.Right.OperationCount()
+}
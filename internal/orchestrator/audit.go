@@ -0,0 +1,78 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"calc_service/internal/storage"
+)
+
+const (
+	auditLoginSucceeded   = "login_succeeded"
+	auditLoginFailed      = "login_failed"
+	auditLoginThrottled   = "login_throttled"
+	auditAccountLocked    = "account_locked"
+	auditPasswordRejected = "password_rejected"
+)
+
+const (
+	defaultAuditListLimit = 100
+	maxAuditListLimit     = 1000
+)
+
+// audit records a security event. A failure to record is logged but does
+// not fail the request.
+func (o *Orchestrator) audit(r *http.Request, eventType string, userID int, login, detail string) {
+	err := o.Storage.RecordAuditEvent(&storage.AuditEvent{
+		Type:   eventType,
+		UserID: userID,
+		Login:  login,
+		IP:     clientIP(r),
+		Detail: detail,
+	})
+	if err != nil {
+		log.Printf("Failed to record %s audit event: %v", eventType, err)
+	}
+}
+
+func (o *Orchestrator) adminAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultAuditListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid limit")
+			return
+		}
+		limit = min(n, maxAuditListLimit)
+	}
+
+	list, err := o.Storage.ListAuditEvents(r.URL.Query().Get("type"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get audit events")
+		return
+	}
+
+	response := make([]map[string]interface{}, len(list))
+	for i, e := range list {
+		item := map[string]interface{}{
+			"id":         strconv.Itoa(e.ID),
+			"type":       e.Type,
+			"login":      e.Login,
+			"ip":         e.IP,
+			"created_at": e.CreatedAt.UTC().Format(time.RFC3339),
+		}
+		if e.UserID != 0 {
+			item["user_id"] = e.UserID
+		}
+		if e.Detail != "" {
+			item["detail"] = e.Detail
+		}
+		response[i] = item
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"events": response})
+}
@@ -0,0 +1,238 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"calc_service/internal/storage"
+)
+
+// batchItemError is the error /calculate would have answered a rejected
+// item with.
+type batchItemError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+type batchResultItem struct {
+	Index int             `json:"index"`
+	ID    string          `json:"id,omitempty"`
+	Error *batchItemError `json:"error,omitempty"`
+}
+
+// errBatchTooLarge is returned by decodeBatch as soon as the batch has more
+// items than allowed, without reading the rest of the body.
+var errBatchTooLarge = errors.New("batch too large")
+
+// decodeBatch reads a JSON array or, for an ndjson content type, one item
+// per line. A limit above 0 caps the number of items.
+func decodeBatch(r *http.Request, limit int) ([]submitRequest, error) {
+	var items []submitRequest
+	dec := json.NewDecoder(r.Body)
+	add := func() error {
+		var item submitRequest
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		if items = append(items, item); limit > 0 && len(items) > limit {
+			return errBatchTooLarge
+		}
+		return nil
+	}
+
+	if strings.Contains(r.Header.Get("Content-Type"), "ndjson") {
+		for {
+			if err := add(); err != nil {
+				if errors.Is(err, io.EOF) {
+					return items, nil
+				}
+				return nil, err
+			}
+		}
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('[') {
+		return nil, errors.New("batch must be a JSON array")
+	}
+	for dec.More() {
+		if err := add(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (o *Orchestrator) batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	limit := o.Config.Quota.MaxBatchSize
+	reqItems, err := decodeBatch(r, limit)
+	var mberr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errBatchTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, "batch_too_large", fmt.Sprintf("Batch exceeds %d expressions", limit))
+		return
+	case errors.As(err, &mberr):
+		writeProblem(w, bodyTooLarge(mberr.Limit))
+		return
+	case err != nil:
+		writeError(w, http.StatusUnprocessableEntity, "invalid_body", "Invalid Body")
+		return
+	}
+	if len(reqItems) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Batch is empty")
+		return
+	}
+
+	results := make([]batchResultItem, len(reqItems))
+	var items []*storage.BatchItem
+	var itemIndex []int
+	var itemTasks [][]*Task
+
+	for i, req := range reqItems {
+		results[i].Index = i
+
+		ast, err := o.validateSubmit(userID, req)
+		var aerr *apiError
+		if errors.As(err, &aerr) {
+			results[i].Error = &batchItemError{Code: aerr.Code, Message: aerr.Message, Details: aerr.Details}
+			continue
+		} else if err != nil {
+			writeAPIError(w, err, "Failed to create batch")
+			return
+		}
+
+		tasks := o.buildTasks(&Expression{Expr: req.Expression, AST: ast})
+		items = append(items, &storage.BatchItem{
+			Expression:  req.Expression,
+			Priority:    req.Priority,
+			Deadline:    req.Deadline,
+			CallbackURL: req.CallbackURL,
+			Tasks:       storageTasks(tasks),
+		})
+		itemIndex = append(itemIndex, i)
+		itemTasks = append(itemTasks, tasks)
+	}
+
+	if len(items) == 0 {
+		writeProblem(w, &apiError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    "invalid_batch",
+			Message: "No expression in the batch is valid",
+			Details: map[string]interface{}{"items": results},
+		})
+		return
+	}
+
+	release, err := o.takeRateSlot(userID)
+	if err != nil {
+		writeAPIError(w, err, "Failed to create batch")
+		return
+	}
+	batch, err := o.Storage.CreateBatch(userID, items, o.Config.Quota.MaxPendingExpressions)
+	if err != nil {
+		release()
+		if errors.Is(err, storage.ErrTooManyPending) {
+			writeAPIError(w, quotaError(err), "Failed to create batch")
+			return
+		}
+		log.Printf("Failed to create batch: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to create batch")
+		return
+	}
+
+	for k, item := range items {
+		results[itemIndex[k]].ID = strconv.Itoa(item.ID)
+		for _, task := range itemTasks[k] {
+			task.ExprID = strconv.Itoa(item.ID)
+			o.queueTask(task)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"batch_id": strconv.Itoa(batch.ID),
+		"items":    results,
+	})
+}
+
+func (o *Orchestrator) batchIDHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Wrong Method")
+		return
+	}
+
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/batches/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid batch ID")
+		return
+	}
+
+	batch, err := o.Storage.GetBatch(id, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "Batch not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get batch")
+		return
+	}
+
+	counts := make(map[string]int)
+	exprs := make([]map[string]interface{}, len(batch.Expressions))
+	for i, expr := range batch.Expressions {
+		counts[expr.Status]++
+		exprs[i] = expressionResponse(expr)
+	}
+
+	status := "completed"
+	if counts["pending"] > 0 {
+		status = "pending"
+	} else if counts["completed"] != len(batch.Expressions) {
+		status = "partial"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"batch": map[string]interface{}{
+			"id":          idStr,
+			"status":      status,
+			"total":       len(batch.Expressions),
+			"counts":      counts,
+			"created_at":  batch.CreatedAt.UTC().Format(time.RFC3339),
+			"expressions": exprs,
+		},
+	})
+}
@@ -0,0 +1,68 @@
+package orchestrator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatchLimitsAndItemErrors(t *testing.T) {
+	o := newTestOrchestrator(t)
+	o.Config.Quota.MaxBatchSize = 2
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("batchuser", "hash")
+	token := mustToken(t, o, userID)
+
+	// Decoding stops at the item over the limit, before the broken line
+	// behind it is read.
+	ndjson := "{\"expression\":\"1+1\"}\n{\"expression\":\"2+2\"}\n{\"expression\":\"3+3\"}\nnot json\n"
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate/batch", strings.NewReader(ndjson))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "batch_too_large") {
+		t.Errorf("Expected 413 batch_too_large for NDJSON, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/calculate/batch", token, `[{"expression":"1"},{"expression":"2"},{"expression":"3"}]`); rec.Code != http.StatusRequestEntityTooLarge || resp["code"] != "batch_too_large" {
+		t.Errorf("Expected 413 batch_too_large for an array, got %d: %v", rec.Code, resp)
+	}
+
+	// Rejected items carry the code /calculate answers with.
+	rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/calculate/batch", token, `[{"expression":"1+1"},{"expression":"2+"}]`)
+	items, _ := resp["items"].([]interface{})
+	if rec.Code != http.StatusCreated || len(items) != 2 {
+		t.Fatalf("Expected 201 with 2 items, got %d: %v", rec.Code, resp)
+	}
+	if item := items[0].(map[string]interface{}); item["id"] == nil || item["error"] != nil {
+		t.Errorf("Expected the first item to be stored, got %v", item)
+	}
+	itemErr, _ := items[1].(map[string]interface{})["error"].(map[string]interface{})
+	_, calc := doJSON(t, h, http.MethodPost, "/api/v1/calculate", token, `{"expression":"2+"}`)
+	if itemErr["code"] != "invalid_expression" || itemErr["code"] != calc["code"] || itemErr["message"] == "" {
+		t.Errorf("Expected the item error to match /calculate's %v, got %v", calc["code"], itemErr)
+	}
+	if details, _ := itemErr["details"].(map[string]interface{}); details["position"] == nil {
+		t.Errorf("Expected the parse position in the item error, got %v", itemErr)
+	}
+
+	o.Config.Quota.MaxBodyBytes = 64
+	body := `[{"expression":"` + strings.Repeat("1+", 100) + `1"}]`
+	if rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/calculate/batch", token, body); rec.Code != http.StatusRequestEntityTooLarge || resp["code"] != "body_too_large" {
+		t.Errorf("Expected 413 body_too_large, got %d: %v", rec.Code, resp)
+	}
+	// Without a declared length the limit is hit while decoding.
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate/batch", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "body_too_large") {
+		t.Errorf("Expected 413 body_too_large for a body of unknown length, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
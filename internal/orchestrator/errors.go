@@ -0,0 +1,62 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+const problemContentType = "application/problem+json"
+
+// apiError is rendered as an RFC 7807 problem document. Code is a stable
+// machine-readable identifier; Message is meant for humans.
+type apiError struct {
+	Status     int
+	Code       string
+	Message    string
+	Details    map[string]interface{}
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func writeProblem(w http.ResponseWriter, e *apiError) {
+	problem := map[string]interface{}{
+		"type":    "about:blank",
+		"title":   http.StatusText(e.Status),
+		"status":  e.Status,
+		"detail":  e.Message,
+		"code":    e.Code,
+		"message": e.Message,
+	}
+	if len(e.Details) > 0 {
+		problem["details"] = e.Details
+	}
+
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", retryAfterSeconds(e.RetryAfter))
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeProblem(w, &apiError{Status: status, Code: code, Message: message})
+}
+
+// writeAPIError writes err as a problem if it is an *apiError and as a
+// generic internal error with the given message otherwise.
+func writeAPIError(w http.ResponseWriter, err error, message string) {
+	var aerr *apiError
+	if !errors.As(err, &aerr) {
+		log.Printf("%s: %v", message, err)
+		writeError(w, http.StatusInternalServerError, "internal_error", message)
+		return
+	}
+	writeProblem(w, aerr)
+}
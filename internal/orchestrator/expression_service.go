@@ -0,0 +1,343 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/events"
+	"calc_service/internal/proto"
+	"calc_service/internal/storage"
+)
+
+const (
+	expressionServicePrefix = "/calc_service.ExpressionService/"
+	maxLongPollWait         = 60 * time.Second
+)
+
+type expressionServer struct {
+	proto.UnimplementedExpressionServiceServer
+	o *Orchestrator
+}
+
+// grpcMethodScope is the API key scope a method needs, mirroring
+// requestScope for the REST routes.
+func grpcMethodScope(fullMethod string) string {
+	switch strings.TrimPrefix(fullMethod, expressionServicePrefix) {
+	case "Submit", "Cancel":
+		return scopeWrite
+	}
+	return scopeRead
+}
+
+func (o *Orchestrator) authenticateContext(ctx context.Context, fullMethod string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var authorization, apiKey string
+	if values := md.Get("authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+	if values := md.Get(apiKeyMetadata); len(values) > 0 {
+		apiKey = values[0]
+	}
+
+	p, err := o.authenticateCredentials(authorization, apiKey)
+	if errors.Is(err, errNoCredentials) {
+		return nil, status.Error(codes.Unauthenticated, "authorization metadata is required")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if !p.allows(grpcMethodScope(fullMethod)) {
+		return nil, status.Errorf(codes.PermissionDenied, "API key lacks the %s scope", grpcMethodScope(fullMethod))
+	}
+	return context.WithValue(ctx, "userID", p.UserID), nil
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticateMethod authenticates users on the expression service and
+// agents on the calculator service.
+func (o *Orchestrator) authenticateMethod(ctx context.Context, fullMethod string) (context.Context, error) {
+	switch {
+	case strings.HasPrefix(fullMethod, expressionServicePrefix):
+		return o.authenticateContext(ctx, fullMethod)
+	case strings.HasPrefix(fullMethod, calculatorServicePrefix):
+		return o.authenticateAgentContext(ctx)
+	}
+	return ctx, nil
+}
+
+func (o *Orchestrator) authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := o.authenticateMethod(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (o *Orchestrator) authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := o.authenticateMethod(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// statusError carries a gRPC status while keeping the original error
+// available to the HTTP gateway.
+type statusError struct {
+	status *status.Status
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+func toProtoExpression(e *storage.Expression) *proto.Expression {
+	pe := &proto.Expression{
+		Id:         strconv.Itoa(e.ID),
+		Expression: e.Expression,
+		Status:     e.Status,
+		Result:     e.Result,
+		Priority:   int32(e.Priority),
+	}
+	if e.Deadline != nil {
+		pe.Deadline = timestamppb.New(*e.Deadline)
+	}
+	if !e.CreatedAt.IsZero() {
+		pe.CreatedAt = timestamppb.New(e.CreatedAt)
+	}
+	return pe
+}
+
+func toProtoEvent(e events.Event) *proto.ExpressionEvent {
+	return &proto.ExpressionEvent{
+		Type:         e.Type,
+		ExpressionId: strconv.Itoa(e.ExpressionID),
+		TaskId:       e.TaskID,
+		Status:       e.Status,
+		Result:       e.Result,
+		Time:         timestamppb.New(e.Time),
+	}
+}
+
+func apiErrorToStatus(err error) error {
+	var aerr *apiError
+	if !errors.As(err, &aerr) {
+		return status.Error(codes.Internal, "failed to create expression")
+	}
+
+	code := codes.Internal
+	switch aerr.Status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case http.StatusConflict:
+		code = codes.Aborted
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	}
+	return &statusError{status: status.New(code, aerr.Message), err: aerr}
+}
+
+func storageErrorToStatus(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return status.Error(codes.NotFound, "expression not found")
+	case errors.Is(err, storage.ErrNotPending):
+		return status.Error(codes.FailedPrecondition, "expression is not pending")
+	case errors.Is(err, storage.ErrInvalidCursor), errors.Is(err, storage.ErrInvalidSort):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+func parseExpressionID(id string) (int, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, status.Error(codes.InvalidArgument, "invalid expression ID")
+	}
+	return n, nil
+}
+
+func (s *expressionServer) Submit(ctx context.Context, req *proto.SubmitRequest) (*proto.SubmitResponse, error) {
+	userID, err := auth.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	sreq := submitRequest{
+		Expression:  req.Expression,
+		Priority:    int(req.Priority),
+		CallbackURL: req.CallbackUrl,
+	}
+	if req.Deadline != nil {
+		deadline := req.Deadline.AsTime()
+		sreq.Deadline = &deadline
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get(idempotencyKeyMetadata); len(keys) > 0 {
+			sreq.IdempotencyKey = keys[0]
+		}
+	}
+
+	expr, err := s.o.submitExpression(userID, sreq)
+	if err != nil {
+		return nil, apiErrorToStatus(err)
+	}
+	if expr.Replayed {
+		grpc.SetHeader(ctx, metadata.Pairs(idempotentReplayed, "true"))
+	}
+	return &proto.SubmitResponse{Id: expr.ID}, nil
+}
+
+func (s *expressionServer) Get(ctx context.Context, req *proto.GetExpressionRequest) (*proto.Expression, error) {
+	userID, err := auth.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	id, err := parseExpressionID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var expr *storage.Expression
+	if req.Wait != "" {
+		wait, perr := time.ParseDuration(req.Wait)
+		if perr != nil || wait < 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid wait duration")
+		}
+		if wait > maxLongPollWait {
+			wait = maxLongPollWait
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, wait)
+		defer cancel()
+		expr, err = s.o.Storage.WaitExpression(ctx, id, userID)
+	} else {
+		expr, err = s.o.Storage.GetExpressionByID(id, userID)
+	}
+	if err != nil {
+		return nil, storageErrorToStatus(err)
+	}
+	return toProtoExpression(expr), nil
+}
+
+func (s *expressionServer) List(ctx context.Context, req *proto.ListExpressionsRequest) (*proto.ListExpressionsResponse, error) {
+	userID, err := auth.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	filter := storage.ExpressionFilter{
+		Statuses: req.Status,
+		Search:   req.Search,
+		Sort:     req.Sort,
+		Cursor:   req.Cursor,
+		Limit:    int(req.Limit),
+	}
+	if req.CreatedAfter != nil {
+		t := req.CreatedAfter.AsTime()
+		filter.CreatedAfter = &t
+	}
+	if req.CreatedBefore != nil {
+		t := req.CreatedBefore.AsTime()
+		filter.CreatedBefore = &t
+	}
+
+	exprs, next, err := s.o.Storage.ListExpressions(userID, filter)
+	if err != nil {
+		return nil, storageErrorToStatus(err)
+	}
+
+	resp := &proto.ListExpressionsResponse{NextCursor: next}
+	for _, expr := range exprs {
+		resp.Expressions = append(resp.Expressions, toProtoExpression(expr))
+	}
+	return resp, nil
+}
+
+func (s *expressionServer) Cancel(ctx context.Context, req *proto.CancelExpressionRequest) (*proto.CancelExpressionResponse, error) {
+	userID, err := auth.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	id, err := parseExpressionID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.o.Storage.CancelExpression(id, userID); err != nil {
+		return nil, storageErrorToStatus(err)
+	}
+	return &proto.CancelExpressionResponse{Success: true}, nil
+}
+
+func (s *expressionServer) Watch(req *proto.WatchExpressionRequest, stream proto.ExpressionService_WatchServer) error {
+	ctx := stream.Context()
+	userID, err := auth.GetUserIDFromContext(ctx)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	id, err := parseExpressionID(req.Id)
+	if err != nil {
+		return err
+	}
+
+	ch, cancel := s.o.Storage.Events().Subscribe(id)
+	defer cancel()
+
+	expr, err := s.o.Storage.GetExpressionByID(id, userID)
+	if err != nil {
+		return storageErrorToStatus(err)
+	}
+	if isTerminalStatus(expr.Status) {
+		return stream.Send(&proto.ExpressionEvent{
+			Type:         events.ExpressionFinished,
+			ExpressionId: req.Id,
+			Status:       expr.Status,
+			Result:       expr.Result,
+			Time:         timestamppb.Now(),
+		})
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-ch:
+			if err := stream.Send(toProtoEvent(e)); err != nil {
+				return err
+			}
+			if e.Type == events.ExpressionFinished {
+				return nil
+			}
+		}
+	}
+}
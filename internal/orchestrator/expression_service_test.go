@@ -0,0 +1,123 @@
+package orchestrator
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"calc_service/internal/events"
+	"calc_service/internal/proto"
+)
+
+func newExpressionClient(t *testing.T, o *Orchestrator) proto.ExpressionServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(o.authUnaryInterceptor),
+		grpc.ChainStreamInterceptor(o.authStreamInterceptor),
+	)
+	proto.RegisterExpressionServiceServer(srv, &expressionServer{o: o})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewExpressionServiceClient(conn)
+}
+
+func TestExpressionServiceRequiresToken(t *testing.T) {
+	client := newExpressionClient(t, newTestOrchestrator(t))
+
+	_, err := client.List(context.Background(), &proto.ListExpressionsRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected Unauthenticated, got %v", err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer invalid")
+	_, err = client.List(ctx, &proto.ListExpressionsRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected Unauthenticated for invalid token, got %v", err)
+	}
+}
+
+func TestExpressionService(t *testing.T) {
+	o := newTestOrchestrator(t)
+	client := newExpressionClient(t, o)
+
+	userID, _ := o.Storage.CreateUser("grpcuser", "hash")
+	token := mustToken(t, o, userID)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+
+	if _, err := client.Submit(ctx, &proto.SubmitRequest{Expression: "2+"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for bad expression, got %v", err)
+	}
+
+	submitted, err := client.Submit(ctx, &proto.SubmitRequest{Expression: "2+2", Priority: 3})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	expr, err := client.Get(ctx, &proto.GetExpressionRequest{Id: submitted.Id})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if expr.Status != "pending" || expr.Priority != 3 || expr.Expression != "2+2" {
+		t.Errorf("Unexpected expression: %+v", expr)
+	}
+
+	list, err := client.List(ctx, &proto.ListExpressionsRequest{})
+	if err != nil || len(list.Expressions) != 1 {
+		t.Fatalf("Expected 1 expression, got %v (err %v)", list, err)
+	}
+
+	watchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	stream, err := client.Watch(watchCtx, &proto.WatchExpressionRequest{Id: submitted.Id})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	task, err := o.Storage.GetPendingTask("agent-1", time.Minute)
+	if err != nil {
+		t.Fatalf("GetPendingTask failed: %v", err)
+	}
+	if err := o.Storage.CompleteTask(task.ID, "agent-1", 4); err != nil {
+		t.Fatalf("CompleteTask failed: %v", err)
+	}
+
+	var last *proto.ExpressionEvent
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		last = ev
+	}
+	if last == nil || last.Type != events.ExpressionFinished || last.Status != "completed" || last.GetResult() != 4 {
+		t.Errorf("Expected completion event, got %+v", last)
+	}
+
+	_, err = client.Cancel(ctx, &proto.CancelExpressionRequest{Id: submitted.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition cancelling finished expression, got %v", err)
+	}
+	_, err = client.Get(ctx, &proto.GetExpressionRequest{Id: "999"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", err)
+	}
+}
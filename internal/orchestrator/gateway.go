@@ -0,0 +1,103 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"calc_service/internal/proto"
+)
+
+var grpcErrorCodes = map[codes.Code]string{
+	codes.InvalidArgument:    "invalid_argument",
+	codes.NotFound:           "not_found",
+	codes.FailedPrecondition: "failed_precondition",
+	codes.Unauthenticated:    "unauthorized",
+	codes.PermissionDenied:   "forbidden",
+	codes.ResourceExhausted:  "rate_limited",
+}
+
+// gatewayHandler serves the REST expression API from the same
+// ExpressionService implementation used by gRPC clients. Responses keep
+// the JSON shapes of the original /api/v1 handlers.
+func (o *Orchestrator) gatewayHandler() (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions: protojson.MarshalOptions{
+				UseProtoNames:     true,
+				EmitDefaultValues: true,
+			},
+			UnmarshalOptions: protojson.UnmarshalOptions{
+				DiscardUnknown: true,
+			},
+		}),
+		runtime.WithForwardResponseOption(gatewayResponseStatus),
+		runtime.WithForwardResponseRewriter(gatewayResponseBody),
+		runtime.WithIncomingHeaderMatcher(gatewayIncomingHeader),
+		runtime.WithOutgoingHeaderMatcher(gatewayOutgoingHeader),
+		runtime.WithErrorHandler(gatewayError),
+		runtime.WithRoutingErrorHandler(gatewayRoutingError),
+	)
+
+	if err := proto.RegisterExpressionServiceHandlerServer(context.Background(), mux, &expressionServer{o: o}); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
+
+func gatewayIncomingHeader(key string) (string, bool) {
+	if http.CanonicalHeaderKey(key) == idempotencyKeyHeader {
+		return idempotencyKeyMetadata, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+func gatewayOutgoingHeader(key string) (string, bool) {
+	if key == idempotentReplayed {
+		return "Idempotent-Replayed", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+func gatewayResponseStatus(ctx context.Context, w http.ResponseWriter, resp protobuf.Message) error {
+	if _, ok := resp.(*proto.SubmitResponse); ok {
+		w.WriteHeader(http.StatusCreated)
+	}
+	return nil
+}
+
+func gatewayResponseBody(ctx context.Context, resp protobuf.Message) (any, error) {
+	if expr, ok := resp.(*proto.Expression); ok {
+		return map[string]interface{}{"expression": expr}, nil
+	}
+	return resp, nil
+}
+
+func gatewayError(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	var aerr *apiError
+	if errors.As(err, &aerr) {
+		writeProblem(w, aerr)
+		return
+	}
+
+	st := status.Convert(err)
+	code, ok := grpcErrorCodes[st.Code()]
+	if !ok {
+		code = "internal_error"
+	}
+	writeError(w, runtime.HTTPStatusFromCode(st.Code()), code, st.Message())
+}
+
+func gatewayRoutingError(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, r *http.Request, httpStatus int) {
+	if httpStatus == http.StatusMethodNotAllowed {
+		writeError(w, httpStatus, "method_not_allowed", "Wrong Method")
+		return
+	}
+	writeError(w, http.StatusNotFound, "route_not_found", "API Not Found")
+}
@@ -0,0 +1,160 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func doJSON(t *testing.T, h http.Handler, method, path, token, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+func TestGatewayLegacyShapes(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("gwuser", "hash")
+	token := mustToken(t, o, userID)
+
+	rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/calculate", token, `{"expression":"2+2*2","priority":3}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	id, ok := resp["id"].(string)
+	if !ok || id == "" {
+		t.Fatalf("Expected string id, got %v", resp)
+	}
+
+	rec, resp = doJSON(t, h, http.MethodGet, "/api/v1/expressions/"+id, token, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	expr, ok := resp["expression"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected expression object, got %v", resp)
+	}
+	if expr["id"] != id || expr["expression"] != "2+2*2" || expr["status"] != "pending" || expr["priority"] != float64(3) {
+		t.Errorf("Unexpected expression: %v", expr)
+	}
+
+	rec, resp = doJSON(t, h, http.MethodGet, "/api/v1/expressions", token, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if list, ok := resp["expressions"].([]interface{}); !ok || len(list) != 1 {
+		t.Errorf("Expected one expression, got %v", resp)
+	}
+
+	rec, _ = doJSON(t, h, http.MethodPost, "/api/v1/expressions/"+id+"/cancel", token, "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for cancel, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec, resp = doJSON(t, h, http.MethodPost, "/api/v1/calculate", token, `{"expression":"2+"}`)
+	if rec.Code != http.StatusUnprocessableEntity || resp["code"] != "invalid_expression" {
+		t.Errorf("Expected 422 invalid_expression, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if details, _ := resp["details"].(map[string]interface{}); details["position"] != float64(2) {
+		t.Errorf("Expected parser position in details, got %v", resp["details"])
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != problemContentType {
+		t.Errorf("Expected %s, got %s", problemContentType, ct)
+	}
+
+	rec, _ = doJSON(t, h, http.MethodGet, "/api/v1/expressions/999", token, "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+
+	rec, _ = doJSON(t, h, http.MethodGet, "/api/v1/expressions", "", "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", rec.Code)
+	}
+}
+
+func TestGatewayEmptyList(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("emptyuser", "hash")
+	token := mustToken(t, o, userID)
+
+	rec, resp := doJSON(t, h, http.MethodGet, "/api/v1/expressions", token, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if list, ok := resp["expressions"].([]interface{}); !ok || len(list) != 0 {
+		t.Errorf("Expected empty expressions array, got %s", rec.Body.String())
+	}
+}
+
+func TestGatewayLongPoll(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("polluser", "hash")
+	token := mustToken(t, o, userID)
+
+	_, resp := doJSON(t, h, http.MethodPost, "/api/v1/calculate", token, `{"expression":"2+2"}`)
+	id, _ := resp["id"].(string)
+
+	for _, wait := range []string{"soon", "-1s"} {
+		if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/expressions/"+id+"?wait="+wait, token, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for wait=%s, got %d: %s", wait, rec.Code, rec.Body.String())
+		}
+	}
+
+	// A short wait on an expression nobody works on gives up and returns it
+	// as it is.
+	rec, resp := doJSON(t, h, http.MethodGet, "/api/v1/expressions/"+id+"?wait=50ms", token, "")
+	if expr, _ := resp["expression"].(map[string]interface{}); rec.Code != http.StatusOK || expr["status"] != "pending" {
+		t.Fatalf("Expected the pending expression after the wait, got %d: %v", rec.Code, resp)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		task, err := o.Storage.GetPendingTask("agent-1", time.Minute)
+		if err != nil {
+			t.Errorf("GetPendingTask failed: %v", err)
+			return
+		}
+		if err := o.Storage.CompleteTask(task.ID, "agent-1", 4); err != nil {
+			t.Errorf("CompleteTask failed: %v", err)
+		}
+	}()
+
+	start := time.Now()
+	rec, resp = doJSON(t, h, http.MethodGet, "/api/v1/expressions/"+id+"?wait=10s", token, "")
+	if elapsed := time.Since(start); elapsed >= 10*time.Second {
+		t.Errorf("Expected the result before the wait ran out, took %v", elapsed)
+	}
+	expr, _ := resp["expression"].(map[string]interface{})
+	if rec.Code != http.StatusOK || expr["status"] != "completed" || expr["result"] != 4.0 {
+		t.Errorf("Expected the completed expression, got %d: %v", rec.Code, resp)
+	}
+}
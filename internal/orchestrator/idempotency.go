@@ -0,0 +1,90 @@
+package orchestrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"calc_service/internal/storage"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotencyKeyMetadata  = "idempotency-key"
+	idempotentReplayed      = "idempotent-replayed"
+	maxIdempotencyKeyLength = 255
+
+	// idempotencyReservationTimeout is how long a key may stay reserved
+	// without an expression before another request can take it over.
+	idempotencyReservationTimeout = time.Minute
+)
+
+var errRequestInProgress = &apiError{
+	Status:  http.StatusConflict,
+	Code:    "request_in_progress",
+	Message: "A request with this Idempotency-Key is still being processed",
+}
+
+func requestHash(req submitRequest) string {
+	body, _ := json.Marshal(req)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// submitIdempotent creates the expression once per user and key. Replays of
+// the same request within the retention window return the original
+// expression instead of creating a new one.
+func (o *Orchestrator) submitIdempotent(userID int, req submitRequest) (*Expression, error) {
+	if len(req.IdempotencyKey) > maxIdempotencyKeyLength {
+		return nil, &apiError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_argument",
+			Message: "Idempotency-Key is too long",
+		}
+	}
+
+	hash := requestHash(req)
+	now := time.Now()
+	existing, err := o.Storage.ReserveIdempotencyKey(userID, req.IdempotencyKey, hash,
+		now.Add(-o.Config.IdempotencyTTL), now.Add(-idempotencyReservationTimeout))
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if existing.RequestHash != hash {
+			return nil, &apiError{
+				Status:  http.StatusUnprocessableEntity,
+				Code:    "idempotency_key_reused",
+				Message: "Idempotency-Key was already used with a different request",
+			}
+		}
+		if existing.ExpressionID == nil {
+			return nil, errRequestInProgress
+		}
+		return &Expression{ID: strconv.Itoa(*existing.ExpressionID), Replayed: true}, nil
+	}
+
+	expr, err := o.createExpression(userID, req)
+	if errors.Is(err, storage.ErrNotFound) {
+		// The reservation went stale and another request took the key.
+		return nil, errRequestInProgress
+	}
+	if err != nil {
+		if rerr := o.Storage.ReleaseIdempotencyKey(userID, req.IdempotencyKey); rerr != nil {
+			log.Printf("Failed to release idempotency key: %v", rerr)
+		}
+		return nil, err
+	}
+	return expr, nil
+}
+
+func (o *Orchestrator) purgeIdempotencyKeys() {
+	if _, err := o.Storage.PurgeIdempotencyKeys(time.Now().Add(-o.Config.IdempotencyTTL)); err != nil {
+		log.Printf("Failed to purge idempotency keys: %v", err)
+	}
+}
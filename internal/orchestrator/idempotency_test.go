@@ -0,0 +1,125 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotentSubmit(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("idemuser", "hash")
+	token := mustToken(t, o, userID)
+
+	submit := func(key, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	rec, first := submit("key-1", `{"expression":"2+2"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("First request must not be marked as replayed")
+	}
+
+	rec, second := submit("key-1", `{"expression":"2+2"}`)
+	if rec.Code != http.StatusCreated || second["id"] != first["id"] {
+		t.Fatalf("Expected replay of %v, got %d: %s", first["id"], rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected Idempotent-Replayed header on replay")
+	}
+
+	rec, resp := submit("key-1", `{"expression":"3+3"}`)
+	if rec.Code != http.StatusUnprocessableEntity || resp["code"] != "idempotency_key_reused" {
+		t.Errorf("Expected 422 idempotency_key_reused, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec, _ = submit("key-2", `{"expression":"2+"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected 422 for invalid expression, got %d", rec.Code)
+	}
+	rec, _ = submit("key-2", `{"expression":"2+1"}`)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("A failed request must release its key, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	exprs, err := o.Storage.GetExpressions(userID)
+	if err != nil {
+		t.Fatalf("GetExpressions failed: %v", err)
+	}
+	if len(exprs) != 2 {
+		t.Errorf("Expected 2 expressions, got %d", len(exprs))
+	}
+
+	o.Config.IdempotencyTTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	rec, third := submit("key-1", `{"expression":"2+2"}`)
+	if rec.Code != http.StatusCreated || third["id"] == first["id"] {
+		t.Errorf("Expected a new expression after the key expired, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestIdempotencyReservation(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("idemuser", "hash")
+	token := mustToken(t, o, userID)
+	body := `{"expression":"2+2"}`
+	submit := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", "key-1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A reservation left behind by a request that never finished.
+	hash := requestHash(submitRequest{Expression: "2+2", IdempotencyKey: "key-1"})
+	now := time.Now()
+	if _, err := o.Storage.ReserveIdempotencyKey(userID, "key-1", hash, now.Add(-time.Hour), now.Add(-time.Minute)); err != nil {
+		t.Fatalf("ReserveIdempotencyKey failed: %v", err)
+	}
+	if rec := submit(); rec.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 while the key is reserved, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	o.Storage.GetDB().Exec("UPDATE idempotency_keys SET created_at = ?", now.Add(-2*idempotencyReservationTimeout).UTC())
+	rec := submit()
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected a stale reservation to be taken over, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var expressionID *int
+	o.Storage.GetDB().QueryRow("SELECT expression_id FROM idempotency_keys WHERE key = 'key-1'").Scan(&expressionID)
+	if expressionID == nil {
+		t.Fatal("Expected the key to be completed with the expression")
+	}
+	if rec := submit(); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected a replay, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,188 @@
+package orchestrator
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/storage"
+)
+
+// LoginConfig controls how failed logins are slowed down. After the free
+// attempts every further failure doubles the wait before the next attempt,
+// starting at BaseBackoff and capped at MaxBackoff; failures are forgotten
+// once FailureWindow passes without one. LockoutThreshold consecutive
+// failures lock an existing account for LockoutDuration, except for
+// addresses the owner has signed in from before.
+type LoginConfig struct {
+	FreeAttempts     int
+	IPFreeAttempts   int
+	BaseBackoff      time.Duration
+	MaxBackoff       time.Duration
+	FailureWindow    time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+func loginConfiguration() LoginConfig {
+	return LoginConfig{
+		FreeAttempts:     envInt("LOGIN_FREE_ATTEMPTS", 3),
+		IPFreeAttempts:   envInt("LOGIN_IP_FREE_ATTEMPTS", 20),
+		BaseBackoff:      time.Duration(envInt("LOGIN_BACKOFF_MS", 1000)) * time.Millisecond,
+		MaxBackoff:       time.Duration(envInt("LOGIN_MAX_BACKOFF_MS", 300000)) * time.Millisecond,
+		FailureWindow:    time.Duration(envInt("LOGIN_FAILURE_WINDOW_MINUTES", 15)) * time.Minute,
+		LockoutThreshold: envInt("LOCKOUT_THRESHOLD", 10),
+		LockoutDuration:  time.Duration(envInt("LOCKOUT_MINUTES", 15)) * time.Minute,
+	}
+}
+
+// passwordPolicyConfiguration reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH
+// and the breached-password list in PASSWORD_BREACHED_FILE.
+func passwordPolicyConfiguration() auth.PasswordPolicy {
+	policy := auth.PasswordPolicy{
+		MinLength: envInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength: envInt("PASSWORD_MAX_LENGTH", 72),
+	}
+	if path := os.Getenv("PASSWORD_BREACHED_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("failed to open PASSWORD_BREACHED_FILE: %v", err)
+		}
+		defer f.Close()
+		if err := policy.LoadBreached(f); err != nil {
+			log.Fatalf("failed to read PASSWORD_BREACHED_FILE: %v", err)
+		}
+		log.Printf("Loaded %d breached passwords", policy.Breached())
+	}
+	return policy
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+	next  time.Time
+}
+
+// loginThrottle tracks failed logins per key ("login:<name>", "ip:<addr>")
+// in memory.
+type loginThrottle struct {
+	mu      sync.Mutex
+	entries map[string]*loginFailures
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{entries: make(map[string]*loginFailures)}
+}
+
+// wait returns how long the caller must wait before key may try again.
+func (t *loginThrottle) wait(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[key]; ok && now.Before(e.next) {
+		return e.next.Sub(now)
+	}
+	return 0
+}
+
+// fail records a failure for key and schedules the next allowed attempt.
+func (t *loginThrottle) fail(key string, free int, cfg LoginConfig, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok || now.Sub(e.last) > cfg.FailureWindow {
+		e = &loginFailures{}
+		t.entries[key] = e
+	}
+	e.count++
+	e.last = now
+	if over := e.count - free; over > 0 {
+		backoff := cfg.MaxBackoff
+		if over <= 30 {
+			backoff = min(cfg.BaseBackoff<<(over-1), cfg.MaxBackoff)
+		}
+		e.next = now.Add(backoff)
+	}
+}
+
+func (t *loginThrottle) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+// purge forgets keys whose last failure is older than window.
+func (t *loginThrottle) purge(window time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, e := range t.entries {
+		if now.Sub(e.last) > window && !now.Before(e.next) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+func (o *Orchestrator) purgeLoginFailures() {
+	o.logins.purge(o.Config.Login.FailureWindow, time.Now())
+}
+
+// clientIP is the address the request came from. X-Forwarded-For is not
+// trusted, as anyone could set it to dodge the per-IP limit.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// knownLoginAddress reports whether the user has signed in successfully from
+// the request's address before.
+func (o *Orchestrator) knownLoginAddress(r *http.Request, user *storage.User) bool {
+	known, err := o.Storage.HasAuditEvent(auditLoginSucceeded, user.ID, clientIP(r))
+	if err != nil {
+		log.Printf("Failed to look up previous logins: %v", err)
+		return false
+	}
+	return known
+}
+
+// loginThrottled answers an attempt made before the backoff has passed.
+func (o *Orchestrator) loginThrottled(w http.ResponseWriter, r *http.Request, login string, wait time.Duration) {
+	o.audit(r, auditLoginThrottled, 0, login, "")
+	writeProblem(w, &apiError{
+		Status:     http.StatusTooManyRequests,
+		Code:       "too_many_attempts",
+		Message:    "Too many failed login attempts, try again later",
+		RetryAfter: wait,
+	})
+}
+
+// loginFailed slows down further attempts for the login and the client
+// address and, for an existing user, counts towards the lockout.
+func (o *Orchestrator) loginFailed(r *http.Request, user *storage.User, login, loginKey, ipKey string, now time.Time) {
+	cfg := o.Config.Login
+	o.logins.fail(loginKey, cfg.FreeAttempts, cfg, now)
+	o.logins.fail(ipKey, cfg.IPFreeAttempts, cfg, now)
+
+	if user == nil {
+		o.audit(r, auditLoginFailed, 0, login, "unknown login")
+		return
+	}
+	o.audit(r, auditLoginFailed, user.ID, login, "wrong password")
+
+	until, err := o.Storage.RecordLoginFailure(user.ID, cfg.LockoutThreshold, cfg.LockoutDuration, now)
+	if err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+		return
+	}
+	if until != nil {
+		log.Printf("Account %s locked until %s", login, until.Format(time.RFC3339))
+		o.audit(r, auditAccountLocked, user.ID, login, "locked until "+until.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,141 @@
+package orchestrator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/storage"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	o := newTestOrchestrator(t)
+	o.Config.Login = LoginConfig{FreeAttempts: 1, IPFreeAttempts: 100, BaseBackoff: time.Minute, MaxBackoff: time.Hour, FailureWindow: time.Hour, LockoutThreshold: 100, LockoutDuration: time.Hour}
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	body := `{"login":"nobody","password":"whatever"}`
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/login", "", body); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for the free attempt, got %d", rec.Code)
+	}
+	rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/login", "", body)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for the first throttled failure, got %d", rec.Code)
+	}
+	rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/login", "", body)
+	if rec.Code != http.StatusTooManyRequests || resp["code"] != "too_many_attempts" {
+		t.Fatalf("Expected 429 too_many_attempts, got %d: %v", rec.Code, resp)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Expected Retry-After of 60 seconds, got %q", got)
+	}
+
+	if o.dummyHash == "" {
+		t.Error("Expected an unknown login to be checked against a dummy hash")
+	}
+
+	// Failed guesses at someone's login do not keep them out from an
+	// address they signed in from before.
+	hash, _ := o.Config.Hasher.Hash("correct-horse")
+	ownerID, _ := o.Storage.CreateUser("owner", hash)
+	o.Storage.RecordAuditEvent(&storage.AuditEvent{Type: auditLoginSucceeded, UserID: ownerID, Login: "owner", IP: "198.51.100.7"})
+	for i := 0; i < 2; i++ {
+		doJSON(t, h, http.MethodPost, "/api/v1/login", "", `{"login":"owner","password":"wrong"}`)
+	}
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/login", "", `{"login":"owner","password":"correct-horse"}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected an unknown address to be throttled, got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(`{"login":"owner","password":"correct-horse"}`))
+	req.RemoteAddr = "198.51.100.7:4242"
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected a known address to skip the login backoff, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The backoff doubles with every further failure and is capped.
+	throttle := newLoginThrottle()
+	now := time.Now()
+	cfg := o.Config.Login
+	cfg.MaxBackoff = 3 * time.Minute
+	for _, want := range []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		throttle.fail("k", cfg.FreeAttempts, cfg, now)
+		if got := throttle.wait("k", now); got != want {
+			t.Errorf("Expected a wait of %v, got %v", want, got)
+		}
+	}
+	throttle.purge(cfg.FailureWindow, now.Add(2*cfg.FailureWindow))
+	if got := throttle.wait("k", now); got != 0 {
+		t.Errorf("Expected purged failures to be forgotten, got %v", got)
+	}
+}
+
+func TestAccountLockout(t *testing.T) {
+	o := newTestOrchestrator(t)
+	o.Config.Login = LoginConfig{FreeAttempts: 100, IPFreeAttempts: 100, BaseBackoff: time.Second, MaxBackoff: time.Second, FailureWindow: time.Hour, LockoutThreshold: 2, LockoutDuration: time.Hour}
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	if rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/register", "", `{"login":"alice","password":"alice"}`); rec.Code != http.StatusBadRequest || resp["code"] != "weak_password" {
+		t.Errorf("Expected 400 weak_password, got %d: %v", rec.Code, resp)
+	}
+
+	hash, _ := o.Config.Hasher.Hash("correct-horse")
+	userID, _ := o.Storage.CreateUser("alice", hash)
+
+	for i := 0; i < 2; i++ {
+		if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/login", "", `{"login":"alice","password":"wrong"}`); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401, got %d", rec.Code)
+		}
+	}
+	// While locked, the right password gets the same answer as a wrong one
+	// or an unknown login.
+	_, unknown := doJSON(t, h, http.MethodPost, "/api/v1/login", "", `{"login":"nobody","password":"correct-horse"}`)
+	rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/login", "", `{"login":"alice","password":"correct-horse"}`)
+	if rec.Code != http.StatusUnauthorized || resp["code"] != unknown["code"] || resp["message"] != unknown["message"] || rec.Header().Get("Retry-After") != "" {
+		t.Fatalf("Expected the locked account to answer like an unknown login, got %d: %v", rec.Code, resp)
+	}
+
+	// The owner still gets in from an address they signed in from before.
+	o.Storage.RecordAuditEvent(&storage.AuditEvent{Type: auditLoginSucceeded, UserID: userID, Login: "alice", IP: "198.51.100.7"})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(`{"login":"alice","password":"correct-horse"}`))
+	req.RemoteAddr = "198.51.100.7:4242"
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a known address to bypass the lock, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if user, _ := o.Storage.GetUserByID(userID); user.FailedLogins != 0 || user.LockedUntil != nil {
+		t.Errorf("Expected a successful login to clear the failures, got %+v", user)
+	}
+
+	o.Storage.GetDB().Exec("UPDATE users SET locked_until = ? WHERE id = ?", time.Now().Add(-time.Minute), userID)
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/login", "", `{"login":"alice","password":"correct-horse"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the login to succeed once the lock expired, got %d", rec.Code)
+	}
+
+	adminID, _ := o.Storage.CreateUser("root", "hash")
+	o.Storage.SetUserRole(adminID, auth.RoleAdmin)
+	admin, _ := o.startSession(adminID)
+	want := map[string]int{
+		auditPasswordRejected: 1,
+		auditLoginFailed:      3,
+		auditAccountLocked:    1,
+		auditLoginThrottled:   1,
+		auditLoginSucceeded:   3,
+	}
+	for eventType, n := range want {
+		rec, resp := doJSON(t, h, http.MethodGet, "/api/v1/admin/audit-events?type="+eventType, admin.Token, "")
+		events, _ := resp["events"].([]interface{})
+		if rec.Code != http.StatusOK || len(events) != n {
+			t.Errorf("Expected %d %s events, got %d: %v", n, eventType, rec.Code, resp)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package orchestrator
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"calc_service/internal/oidc"
+	"calc_service/internal/storage"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	// oidcLoginTTL is how long the user has to sign in at the provider.
+	oidcLoginTTL = 10 * time.Minute
+	// maxPendingOIDCLogins bounds the memory unauthenticated callers can
+	// make the orchestrator hold.
+	maxPendingOIDCLogins = 10000
+	// oidcLoginPrefix starts the logins of users created through OIDC; local
+	// registration cannot use it.
+	oidcLoginPrefix = "oidc-"
+)
+
+// oidcConfiguration reads the provider settings. OIDC login is off unless
+// OIDC_ISSUER is set.
+func oidcConfiguration() oidc.Config {
+	cfg := oidc.Config{
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       strings.Fields(envString("OIDC_SCOPES", "openid email profile")),
+	}
+	if cfg.Issuer != "" && (cfg.ClientID == "" || cfg.RedirectURL == "") {
+		log.Fatalf("OIDC_ISSUER requires OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+	}
+	return cfg
+}
+
+type pendingOIDCLogin struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+// ssoLogin is the OIDC provider together with the logins started with it,
+// keyed by state.
+type ssoLogin struct {
+	provider *oidc.Provider
+
+	mu      sync.Mutex
+	pending map[string]pendingOIDCLogin
+}
+
+func newSSOLogin(cfg oidc.Config) *ssoLogin {
+	if cfg.Issuer == "" {
+		return nil
+	}
+	return &ssoLogin{provider: oidc.New(cfg), pending: make(map[string]pendingOIDCLogin)}
+}
+
+func (s *ssoLogin) start(state string, p pendingOIDCLogin) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) >= maxPendingOIDCLogins {
+		s.purgeLocked(time.Now())
+		if len(s.pending) >= maxPendingOIDCLogins {
+			return false
+		}
+	}
+	s.pending[state] = p
+	return true
+}
+
+// finish returns the login started with state; a state works only once.
+func (s *ssoLogin) finish(state string, now time.Time) (pendingOIDCLogin, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pending[state]
+	delete(s.pending, state)
+	return p, ok && now.Before(p.expires)
+}
+
+func (s *ssoLogin) purgeLocked(now time.Time) {
+	for state, p := range s.pending {
+		if !now.Before(p.expires) {
+			delete(s.pending, state)
+		}
+	}
+}
+
+func (o *Orchestrator) purgeOIDCLogins() {
+	if o.sso == nil {
+		return
+	}
+	o.sso.mu.Lock()
+	defer o.sso.mu.Unlock()
+	o.sso.purgeLocked(time.Now())
+}
+
+// oidcLoginHandler sends the browser to the provider. The state is also
+// kept in a cookie, so the callback only completes in the browser that
+// started the login.
+func (o *Orchestrator) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if o.sso == nil {
+		writeError(w, http.StatusNotFound, "not_found", "OIDC login is not configured")
+		return
+	}
+
+	state, nonce, verifier := oidc.RandomString(), oidc.RandomString(), oidc.RandomString()
+	target, err := o.sso.provider.AuthCodeURL(r.Context(), state, nonce, oidc.Challenge(verifier))
+	if err != nil {
+		log.Printf("OIDC provider unavailable: %v", err)
+		writeError(w, http.StatusBadGateway, "oidc_unavailable", "Identity provider is unavailable")
+		return
+	}
+	if !o.sso.start(state, pendingOIDCLogin{nonce: nonce, verifier: verifier, expires: time.Now().Add(oidcLoginTTL)}) {
+		writeError(w, http.StatusServiceUnavailable, "unavailable", "Too many logins in progress")
+		return
+	}
+
+	http.SetCookie(w, o.oidcStateCookie(state, int(oidcLoginTTL.Seconds())))
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func (o *Orchestrator) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if o.sso == nil {
+		writeError(w, http.StatusNotFound, "not_found", "OIDC login is not configured")
+		return
+	}
+
+	q := r.URL.Query()
+	state := q.Get("state")
+	pending, ok := o.sso.finish(state, time.Now())
+	cookie, err := r.Cookie(oidcStateCookie)
+	if !ok || err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		writeError(w, http.StatusBadRequest, "invalid_state", "Unknown or expired login state")
+		return
+	}
+	http.SetCookie(w, o.oidcStateCookie("", -1))
+
+	if reason := q.Get("error"); reason != "" {
+		o.audit(r, auditLoginFailed, 0, "", "oidc: "+reason)
+		writeError(w, http.StatusUnauthorized, "oidc_error", "Identity provider refused the login: "+reason)
+		return
+	}
+	if q.Get("code") == "" {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "code is required")
+		return
+	}
+
+	identity, err := o.sso.provider.Exchange(r.Context(), q.Get("code"), pending.verifier, pending.nonce)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		o.audit(r, auditLoginFailed, 0, "", "oidc: "+err.Error())
+		writeError(w, http.StatusUnauthorized, "oidc_error", "Could not verify the identity provider's response")
+		return
+	}
+
+	user, err := o.oidcUser(identity)
+	if err != nil {
+		log.Printf("Failed to map OIDC identity %s: %v", identity.Subject, err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	if user.DisabledAt != nil {
+		writeError(w, http.StatusForbidden, "account_disabled", "Account is disabled")
+		return
+	}
+
+	pair, err := o.startSession(user.ID)
+	if err != nil {
+		log.Printf("Failed to start session: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	o.audit(r, auditLoginSucceeded, user.ID, user.Login, "oidc")
+	writeLoginResponse(w, pair, user)
+}
+
+func (o *Orchestrator) oidcStateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/v1/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(o.sso.provider.Config().RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// oidcUser returns the local user linked to the identity, creating one on
+// first login. The login is preferred_username, the e-mail if the provider
+// verified it, or the subject, always behind the oidc- prefix so that an
+// identity cannot claim a login reserved for local accounts, such as those
+// in ADMIN_LOGINS. If another account has it, a numeric suffix is added
+// rather than linking the identity to an account someone else may own.
+// Such users have no password and sign in through the provider only.
+func (o *Orchestrator) oidcUser(id *oidc.Identity) (*storage.User, error) {
+	user, err := o.Storage.GetUserByIdentity(id.Issuer, id.Subject)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return user, err
+	}
+
+	name := id.PreferredUsername
+	if name == "" && id.EmailVerified {
+		name = id.Email
+	}
+	if name == "" {
+		name = id.Subject
+	}
+	base := oidcLoginPrefix + name
+
+	for i := 1; i <= 100; i++ {
+		login := base
+		if i > 1 {
+			login = fmt.Sprintf("%s-%d", base, i)
+		}
+		_, err := o.Storage.CreateUserWithIdentity(login, "", id.Issuer, id.Subject, id.Email)
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			// Either the login is taken or a concurrent callback linked
+			// the identity first.
+			if user, err := o.Storage.GetUserByIdentity(id.Issuer, id.Subject); err == nil {
+				return user, nil
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return o.Storage.GetUserByIdentity(id.Issuer, id.Subject)
+	}
+	return nil, fmt.Errorf("no free login for %q", base)
+}
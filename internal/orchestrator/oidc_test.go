@@ -0,0 +1,188 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/oidctest"
+)
+
+const oidcRedirectURL = "http://calc.example.com/api/v1/oidc/callback"
+
+func newOIDCTestOrchestrator(t *testing.T) (*Orchestrator, http.Handler, *oidctest.Provider) {
+	t.Helper()
+	provider := oidctest.NewProvider(t)
+	o := newTestOrchestrator(t)
+	o.sso = newSSOLogin(provider.Config(oidcRedirectURL))
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	return o, h, provider
+}
+
+// startOIDCLogin follows the redirect to the mock provider and returns the
+// callback URL it sends the browser back to, with the state cookie.
+func startOIDCLogin(t *testing.T, h http.Handler) (string, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect to the provider, got %d: %s", rec.Code, rec.Body.String())
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookie || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HttpOnly state cookie, got %v", cookies)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Authorization request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected the provider to redirect back, got %d", resp.StatusCode)
+	}
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	if !strings.HasPrefix(callback.String(), oidcRedirectURL) {
+		t.Fatalf("Unexpected callback %s", callback)
+	}
+	return callback.RequestURI(), cookies[0]
+}
+
+func oidcCallback(t *testing.T, h http.Handler, callback string, cookie *http.Cookie) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+func TestOIDCLogin(t *testing.T) {
+	o, h, provider := newOIDCTestOrchestrator(t)
+
+	callback, cookie := startOIDCLogin(t, h)
+	rec, resp := oidcCallback(t, h, callback, cookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	user, _ := resp["user"].(map[string]interface{})
+	if user["login"] != "oidc-alice" || user["role"] != auth.RoleUser {
+		t.Errorf("Expected a new user oidc-alice, got %v", user)
+	}
+	token, _ := resp["token"].(string)
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/me", token, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected the issued token to work, got %d", rec.Code)
+	}
+	if rec, _ := oidcCallback(t, h, callback, cookie); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a replayed state to be rejected, got %d", rec.Code)
+	}
+
+	// The same identity maps to the same user.
+	callback, cookie = startOIDCLogin(t, h)
+	_, again := oidcCallback(t, h, callback, cookie)
+	if again["user"].(map[string]interface{})["id"] != user["id"] {
+		t.Errorf("Expected the same user, got %v", again["user"])
+	}
+
+	// A different identity with a taken name gets a login of its own. Logins
+	// are namespaced, so local accounts and ADMIN_LOGINS cannot be claimed,
+	// and an unverified e-mail is not used at all.
+	o.Config.AdminLogins = []string{"root"}
+	o.Storage.CreateUser("bob", "hash")
+	for _, tc := range []struct {
+		id         oidctest.Identity
+		unverified bool
+		want       string
+	}{
+		{oidctest.Identity{Subject: "sub-alice-2", PreferredUsername: "alice"}, false, "oidc-alice-2"},
+		{oidctest.Identity{Subject: "sub-bob", PreferredUsername: "bob"}, false, "oidc-bob"},
+		{oidctest.Identity{Subject: "sub-root", PreferredUsername: "root"}, false, "oidc-root"},
+		{oidctest.Identity{Subject: "sub-carol", Email: "carol@example.com"}, false, "oidc-carol@example.com"},
+		{oidctest.Identity{Subject: "sub-eve", Email: "eve@example.com"}, true, "oidc-sub-eve"},
+	} {
+		provider.SignIn(tc.id)
+		if tc.unverified {
+			provider.Tamper(func(c jwt.MapClaims) { c["email_verified"] = false })
+		}
+		callback, cookie = startOIDCLogin(t, h)
+		_, resp = oidcCallback(t, h, callback, cookie)
+		provider.Tamper(nil)
+		if got := resp["user"].(map[string]interface{})["login"]; got != tc.want {
+			t.Errorf("Expected login %s, got %v", tc.want, got)
+		}
+	}
+	o.bootstrapAdmins()
+	if root, _ := o.Storage.GetUserByLogin("oidc-root"); root.Role != auth.RoleUser {
+		t.Errorf("Expected an SSO user named root to stay a plain user, got %q", root.Role)
+	}
+	if rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/register", "", `{"login":"oidc-mallory","password":"correct-horse-1"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected registration of an oidc- login to be refused, got %d: %v", rec.Code, resp)
+	}
+
+	local, _ := o.Storage.GetUserByLogin("oidc-alice")
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/login", "", `{"login":"oidc-alice","password":""}`); rec.Code != http.StatusBadRequest && rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a password login without a password to fail, got %d", rec.Code)
+	}
+	o.Storage.SetUserDisabled(local.ID, true)
+	provider.SignIn(oidctest.Identity{Subject: "1001", PreferredUsername: "alice"})
+	callback, cookie = startOIDCLogin(t, h)
+	if rec, resp := oidcCallback(t, h, callback, cookie); rec.Code != http.StatusForbidden || resp["code"] != "account_disabled" {
+		t.Errorf("Expected 403 account_disabled, got %d: %v", rec.Code, resp)
+	}
+}
+
+func TestOIDCLoginFailures(t *testing.T) {
+	o, h, provider := newOIDCTestOrchestrator(t)
+
+	callback, _ := startOIDCLogin(t, h)
+	if rec, resp := oidcCallback(t, h, callback, nil); rec.Code != http.StatusBadRequest || resp["code"] != "invalid_state" {
+		t.Errorf("Expected 400 invalid_state without the cookie, got %d: %v", rec.Code, resp)
+	}
+	callback, cookie := startOIDCLogin(t, h)
+	forged := strings.Replace(callback, "state=", "state=x", 1)
+	if rec, _ := oidcCallback(t, h, forged, cookie); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown state, got %d", rec.Code)
+	}
+
+	provider.Deny(true)
+	callback, cookie = startOIDCLogin(t, h)
+	if rec, resp := oidcCallback(t, h, callback, cookie); rec.Code != http.StatusUnauthorized || resp["code"] != "oidc_error" {
+		t.Errorf("Expected 401 oidc_error when the provider refuses, got %d: %v", rec.Code, resp)
+	}
+	provider.Deny(false)
+
+	callback, cookie = startOIDCLogin(t, h)
+	u, _ := url.Parse(callback)
+	q := u.Query()
+	q.Set("code", "bogus")
+	u.RawQuery = q.Encode()
+	if rec, _ := oidcCallback(t, h, u.RequestURI(), cookie); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a bad code, got %d", rec.Code)
+	}
+
+	if _, err := o.Storage.GetUserByLogin("oidc-alice"); err == nil {
+		t.Error("Expected no user to be created by failed logins")
+	}
+
+	o.sso = nil
+	h, _ = o.Handler()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/oidc/login", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 when OIDC is not configured, got %d", rec.Code)
+	}
+}
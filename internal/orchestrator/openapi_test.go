@@ -0,0 +1,209 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/oidctest"
+)
+
+type contractStep struct {
+	method string
+	path   string
+	body   string
+	auth   bool
+	status int
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	ctx := context.Background()
+
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		t.Fatalf("Failed to load spec: %v", err)
+	}
+	if err := doc.Validate(ctx); err != nil {
+		t.Fatalf("Invalid spec: %v", err)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatalf("Failed to build router: %v", err)
+	}
+
+	openapi3filter.RegisterBodyDecoder("text/event-stream", func(body io.Reader, _ http.Header, _ *openapi3.SchemaRef, _ openapi3filter.EncodingFn) (interface{}, error) {
+		data, err := io.ReadAll(body)
+		return string(data), err
+	})
+	defer openapi3filter.UnregisterBodyDecoder("text/event-stream")
+
+	o := newTestOrchestrator(t)
+	o.sso = newSSOLogin(oidctest.NewProvider(t).Config(oidcRedirectURL))
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	covered := make(map[string]bool)
+	var token string
+	state := make(map[string]string)
+
+	run := func(step contractStep) map[string]interface{} {
+		t.Helper()
+		path := step.path
+		for k, v := range state {
+			path = strings.ReplaceAll(path, "{"+k+"}", v)
+		}
+
+		req := httptest.NewRequest(step.method, "/api/v1"+path, strings.NewReader(step.body))
+		if step.body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if step.auth {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		route, pathParams, err := router.FindRoute(req)
+		if err != nil {
+			t.Fatalf("%s %s is not in the spec: %v", step.method, path, err)
+		}
+		covered[route.Operation.OperationID] = true
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+		}
+		if err := openapi3filter.ValidateRequest(ctx, input); err != nil {
+			t.Fatalf("%s %s request does not match spec: %v", step.method, path, err)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != step.status {
+			t.Fatalf("%s %s: expected %d, got %d: %s", step.method, path, step.status, rec.Code, rec.Body.String())
+		}
+
+		body := rec.Body.Bytes()
+		err = openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 rec.Code,
+			Header:                 rec.Header(),
+			Body:                   io.NopCloser(strings.NewReader(string(body))),
+			Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+		})
+		if err != nil {
+			t.Fatalf("%s %s response does not match spec: %v\n%s", step.method, path, err, body)
+		}
+
+		var resp map[string]interface{}
+		json.Unmarshal(body, &resp)
+		return resp
+	}
+
+	run(contractStep{method: "GET", path: "/openapi.json", status: http.StatusOK})
+	run(contractStep{method: "POST", path: "/register", body: `{"login":"contract","password":"short"}`, status: http.StatusBadRequest})
+	run(contractStep{method: "POST", path: "/register", body: `{"login":"contract","password":"correct-horse-1"}`, status: http.StatusCreated})
+	run(contractStep{method: "POST", path: "/register", body: `{"login":"contract","password":"correct-horse-1"}`, status: http.StatusConflict})
+	o.Storage.SetUserRoleByLogin("contract", auth.RoleAdmin)
+	run(contractStep{method: "POST", path: "/login", body: `{"login":"contract","password":"wrong"}`, status: http.StatusUnauthorized})
+	resp := run(contractStep{method: "POST", path: "/login", body: `{"login":"contract","password":"correct-horse-1"}`, status: http.StatusOK})
+	refresh, _ := resp["refresh_token"].(string)
+	resp = run(contractStep{method: "POST", path: "/refresh", body: `{"refresh_token":"` + refresh + `"}`, status: http.StatusOK})
+	token, _ = resp["token"].(string)
+	run(contractStep{method: "POST", path: "/refresh", body: `{"refresh_token":"bogus"}`, status: http.StatusUnauthorized})
+	run(contractStep{method: "GET", path: "/oidc/login", status: http.StatusFound})
+	run(contractStep{method: "GET", path: "/oidc/callback?state=bogus&code=bogus", status: http.StatusBadRequest})
+
+	run(contractStep{method: "GET", path: "/expressions", status: http.StatusUnauthorized})
+	resp = run(contractStep{method: "POST", path: "/calculate", body: `{"expression":"2+2*2","priority":1}`, auth: true, status: http.StatusCreated})
+	state["expr"], _ = resp["id"].(string)
+	run(contractStep{method: "POST", path: "/calculate", body: `{"expression":"2+"}`, auth: true, status: http.StatusUnprocessableEntity})
+	run(contractStep{method: "GET", path: "/expressions", auth: true, status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/expressions?limit=1&status=pending&status=error&sort=-priority&search=2&created_after=2020-01-01T00:00:00Z", auth: true, status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/expressions?cursor=bogus", auth: true, status: http.StatusBadRequest})
+	run(contractStep{method: "GET", path: "/expressions/{expr}", auth: true, status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/expressions/{expr}?wait=10ms", auth: true, status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/expressions/{expr}?wait=soon", auth: true, status: http.StatusBadRequest})
+	run(contractStep{method: "GET", path: "/expressions/999", auth: true, status: http.StatusNotFound})
+	run(contractStep{method: "POST", path: "/expressions/{expr}/cancel", auth: true, status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/expressions/{expr}/events", auth: true, status: http.StatusOK})
+
+	mateID, _ := o.Storage.CreateUser("teammate", "hash")
+	shareWithMate := fmt.Sprintf(`{"user_id":%d}`, mateID)
+	resp = run(contractStep{method: "POST", path: "/expressions/{expr}/shares", body: shareWithMate, auth: true, status: http.StatusCreated})
+	state["share"], _ = resp["id"].(string)
+	run(contractStep{method: "POST", path: "/expressions/{expr}/shares", body: shareWithMate, auth: true, status: http.StatusConflict})
+	resp = run(contractStep{method: "POST", path: "/expressions/{expr}/shares", body: `{"public":true}`, auth: true, status: http.StatusCreated})
+	state["link"], _ = resp["token"].(string)
+	run(contractStep{method: "GET", path: "/expressions/{expr}/shares", auth: true, status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/me/shared", auth: true, status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/shared/{link}", status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/shared/bogus", status: http.StatusNotFound})
+	run(contractStep{method: "DELETE", path: "/expressions/{expr}/shares/{share}", auth: true, status: http.StatusNoContent})
+	run(contractStep{method: "DELETE", path: "/expressions/{expr}/shares/{share}", auth: true, status: http.StatusNotFound})
+
+	resp = run(contractStep{method: "POST", path: "/calculate/batch", body: `[{"expression":"1+1"},{"expression":"1+"}]`, auth: true, status: http.StatusCreated})
+	state["batch"], _ = resp["batch_id"].(string)
+	run(contractStep{method: "GET", path: "/batches/{batch}", auth: true, status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/me/quota", auth: true, status: http.StatusOK})
+
+	resp = run(contractStep{method: "POST", path: "/webhooks", body: `{"url":"http://example.com/hook"}`, auth: true, status: http.StatusCreated})
+	state["hook"], _ = resp["id"].(string)
+	run(contractStep{method: "GET", path: "/webhooks", auth: true, status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/webhooks/deliveries", auth: true, status: http.StatusOK})
+	run(contractStep{method: "POST", path: "/webhooks/callback-secret", auth: true, status: http.StatusCreated})
+	run(contractStep{method: "DELETE", path: "/webhooks/{hook}", auth: true, status: http.StatusNoContent})
+	run(contractStep{method: "DELETE", path: "/webhooks/{hook}", auth: true, status: http.StatusNotFound})
+
+	resp = run(contractStep{method: "GET", path: "/admin/users", auth: true, status: http.StatusOK})
+	users, _ := resp["users"].([]interface{})
+	state["user"] = fmt.Sprint(users[0].(map[string]interface{})["id"])
+	run(contractStep{method: "PATCH", path: "/admin/users/{user}", body: `{"role":"admin"}`, auth: true, status: http.StatusOK})
+	run(contractStep{method: "PATCH", path: "/admin/users/999", body: `{"disabled":true}`, auth: true, status: http.StatusNotFound})
+	run(contractStep{method: "GET", path: "/admin/expressions?limit=1&user_id={user}", auth: true, status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/admin/audit-events?type=login_failed&limit=10", auth: true, status: http.StatusOK})
+	resp = run(contractStep{method: "GET", path: "/admin/tasks", auth: true, status: http.StatusOK})
+	tasks, _ := resp["tasks"].([]interface{})
+	state["task"], _ = tasks[0].(map[string]interface{})["id"].(string)
+	run(contractStep{method: "POST", path: "/admin/tasks/{task}/requeue", auth: true, status: http.StatusNoContent})
+	run(contractStep{method: "POST", path: "/admin/tasks/{task}/cancel", auth: true, status: http.StatusNoContent})
+	run(contractStep{method: "POST", path: "/admin/tasks/{task}/cancel", auth: true, status: http.StatusConflict})
+
+	resp = run(contractStep{method: "POST", path: "/api-keys", body: `{"name":"ci","scopes":["read"]}`, auth: true, status: http.StatusCreated})
+	state["key"], _ = resp["id"].(string)
+	run(contractStep{method: "POST", path: "/api-keys", body: `{"name":""}`, auth: true, status: http.StatusBadRequest})
+	run(contractStep{method: "GET", path: "/api-keys", auth: true, status: http.StatusOK})
+	run(contractStep{method: "DELETE", path: "/api-keys/{key}", auth: true, status: http.StatusNoContent})
+	run(contractStep{method: "DELETE", path: "/api-keys/{key}", auth: true, status: http.StatusNotFound})
+
+	run(contractStep{method: "GET", path: "/me", auth: true, status: http.StatusOK})
+	run(contractStep{method: "PUT", path: "/me/password", body: `{"old_password":"wrong","new_password":"correct-horse-2"}`, auth: true, status: http.StatusForbidden})
+	resp = run(contractStep{method: "PUT", path: "/me/password", body: `{"old_password":"correct-horse-1","new_password":"correct-horse-2"}`, auth: true, status: http.StatusOK})
+	run(contractStep{method: "GET", path: "/me", auth: true, status: http.StatusUnauthorized})
+	token, _ = resp["token"].(string)
+	refresh, _ = resp["refresh_token"].(string)
+	run(contractStep{method: "DELETE", path: "/me", auth: true, status: http.StatusNoContent})
+
+	run(contractStep{method: "POST", path: "/logout", body: `{"refresh_token":"` + refresh + `"}`, status: http.StatusNoContent})
+	run(contractStep{method: "GET", path: "/me/quota", auth: true, status: http.StatusUnauthorized})
+
+	for path, item := range doc.Paths.Map() {
+		for method, op := range item.Operations() {
+			if !covered[op.OperationID] {
+				t.Errorf("%s %s is not exercised by the contract test", method, path)
+			}
+		}
+	}
+}
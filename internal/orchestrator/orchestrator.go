@@ -14,11 +14,14 @@ import (
 	"sync"
 	"time"
 
-	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"calc_service/internal/auth"
+	"calc_service/internal/oidc"
 	"calc_service/internal/proto"
 	"calc_service/internal/storage"
+	"calc_service/internal/webhooks"
 )
 
 type server struct {
@@ -33,6 +36,19 @@ type Config struct {
 	TimeSubtraction     int
 	TimeMultiplications int
 	TimeDivisions       int
+	Quota               QuotaConfig
+	Webhooks            webhooks.Config
+	IdempotencyTTL      time.Duration
+	Auth                auth.Config
+	RefreshTokenTTL     time.Duration
+	AdminLogins         []string
+	Agents              AgentConfig
+	TLS                 TLSConfig
+	Login               LoginConfig
+	PasswordPolicy      auth.PasswordPolicy
+	Hasher              auth.Hasher
+	OIDC                oidc.Config
+	WebsocketOrigins    []string
 }
 
 type Orchestrator struct {
@@ -44,14 +60,24 @@ type Orchestrator struct {
 	exprCounter int64
 	taskCounter int64
 	Storage     *storage.Storage
+	Tokens      *auth.Tokens
+	limiter     *rateLimiter
+	logins      *loginThrottle
+	sso         *ssoLogin
+
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
 type Expression struct {
-	ID     string   `json:"id"`
-	Expr   string   `json:"expression"`
-	Status string   `json:"status"`
-	Result *float64 `json:"result,omitempty"`
-	AST    *ASTNode `json:"-"`
+	ID       string     `json:"id"`
+	Expr     string     `json:"expression"`
+	Status   string     `json:"status"`
+	Result   *float64   `json:"result,omitempty"`
+	Priority int        `json:"priority"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+	AST      *ASTNode   `json:"-"`
+	Replayed bool       `json:"-"`
 }
 
 type Task struct {
@@ -102,11 +128,24 @@ func Configuration() *Config {
 		TimeSubtraction:     ts,
 		TimeMultiplications: tm,
 		TimeDivisions:       td,
+		Quota:               quotaConfiguration(),
+		Webhooks:            webhookConfiguration(),
+		IdempotencyTTL:      time.Duration(envInt("IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour,
+		Auth:                authConfiguration(),
+		RefreshTokenTTL:     time.Duration(envInt("REFRESH_TOKEN_TTL_HOURS", 30*24)) * time.Hour,
+		AdminLogins:         strings.FieldsFunc(os.Getenv("ADMIN_LOGINS"), func(r rune) bool { return r == ',' || r == ' ' }),
+		Agents:              agentConfiguration(),
+		TLS:                 tlsConfiguration(),
+		Login:               loginConfiguration(),
+		PasswordPolicy:      passwordPolicyConfiguration(),
+		Hasher:              hasherConfiguration(),
+		OIDC:                oidcConfiguration(),
+		WebsocketOrigins:    strings.FieldsFunc(os.Getenv("WEBSOCKET_ALLOWED_ORIGINS"), func(r rune) bool { return r == ',' || r == ' ' }),
 	}
 }
 
 func (s *server) GetTask(ctx context.Context, req *proto.TaskRequest) (*proto.TaskResponse, error) {
-	task, err := s.o.Storage.GetPendingTask()
+	task, err := s.o.Storage.GetPendingTask(agentIDFromContext(ctx), s.o.Config.Agents.LeaseTTL)
 	if err != nil {
 		return nil, err
 	}
@@ -121,7 +160,10 @@ func (s *server) GetTask(ctx context.Context, req *proto.TaskRequest) (*proto.Ta
 }
 
 func (s *server) SubmitResult(ctx context.Context, req *proto.ResultRequest) (*proto.ResultResponse, error) {
-	if err := s.o.Storage.CompleteTask(req.Id, req.Result); err != nil {
+	if err := s.o.Storage.CompleteTask(req.Id, agentIDFromContext(ctx), req.Result); err != nil {
+		if errors.Is(err, storage.ErrLeaseNotHeld) {
+			return nil, status.Error(codes.FailedPrecondition, "task is not leased to this agent")
+		}
 		return nil, err
 	}
 	return &proto.ResultResponse{Success: true}, nil
@@ -138,18 +180,30 @@ func NewOrchestrator() *Orchestrator {
 		log.Fatal(err)
 	}
 
-	return &Orchestrator{
-		Config:    Configuration(),
+	config := Configuration()
+	tokens, err := auth.NewTokens(config.Auth)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	o := &Orchestrator{
+		Config:    config,
 		Storage:   storage,
+		Tokens:    tokens,
 		exprStore: make(map[string]*Expression),
 		taskStore: make(map[string]*Task),
 		taskQueue: make([]*Task, 0),
+		limiter:   newRateLimiter(),
+		logins:    newLoginThrottle(),
+		sso:       newSSOLogin(config.OIDC),
 	}
+	o.bootstrapAdmins()
+	return o
 }
 
 func (o *Orchestrator) registerHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
@@ -159,30 +213,39 @@ func (o *Orchestrator) registerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"Invalid request body"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "invalid_body", "Invalid request body")
 		return
 	}
 
 	if req.Login == "" || req.Password == "" {
-		http.Error(w, `{"error":"Login and password are required"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Login and password are required")
+		return
+	}
+	if strings.HasPrefix(req.Login, oidcLoginPrefix) {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Logins starting with "+oidcLoginPrefix+" are reserved for single sign-on")
+		return
+	}
+	if err := o.Config.PasswordPolicy.Check(req.Login, req.Password); err != nil {
+		o.audit(r, auditPasswordRejected, 0, req.Login, err.Error())
+		writeError(w, http.StatusBadRequest, "weak_password", err.Error())
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(req.Password)
+	hashedPassword, err := o.Config.Hasher.Hash(req.Password)
 	if err != nil {
 		log.Printf("Failed to hash password: %v", err)
-		http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
 		return
 	}
 
 	userID, err := o.Storage.CreateUser(req.Login, hashedPassword)
 	if err != nil {
 		if errors.Is(err, storage.ErrAlreadyExists) {
-			http.Error(w, `{"error":"User already exists"}`, http.StatusConflict)
+			writeError(w, http.StatusConflict, "already_exists", "User already exists")
 			return
 		}
 		log.Printf("Failed to create user: %v", err)
-		http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
 		return
 	}
 
@@ -191,12 +254,13 @@ func (o *Orchestrator) registerHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"id":    userID,
 		"login": req.Login,
+		"role":  auth.RoleUser,
 	})
 }
 
 func (o *Orchestrator) loginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
@@ -206,39 +270,83 @@ func (o *Orchestrator) loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"Invalid request body"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return
+	}
+
+	now := time.Now()
+	loginKey, ipKey := "login:"+req.Login, "ip:"+clientIP(r)
+	if wait := o.logins.wait(ipKey, now); wait > 0 {
+		o.loginThrottled(w, r, req.Login, wait)
 		return
 	}
 
 	user, err := o.Storage.GetUserByLogin(req.Login)
-	if err != nil {
-		if errors.Is(err, storage.ErrNotFound) {
-			http.Error(w, `{"error":"Invalid credentials"}`, http.StatusUnauthorized)
-			return
-		}
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
 		log.Printf("Failed to get user: %v", err)
-		http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
 		return
 	}
 
-	if !auth.CheckPasswordHash(req.Password, user.Password) {
-		http.Error(w, `{"error":"Invalid credentials"}`, http.StatusUnauthorized)
+	// Failures against a login slow down everyone trying it, so the owner
+	// is exempt from that backoff from an address they signed in from
+	// before; otherwise anyone could keep them out by guessing.
+	if wait := o.logins.wait(loginKey, now); wait > 0 && (user == nil || !o.knownLoginAddress(r, user)) {
+		o.loginThrottled(w, r, req.Login, wait)
 		return
 	}
 
-	token, err := auth.GenerateJWT(user.ID)
+	if user == nil {
+		o.checkDummyPassword(req.Password)
+	}
+	if user == nil || !auth.CheckPasswordHash(req.Password, user.Password) {
+		o.loginFailed(r, user, req.Login, loginKey, ipKey, now)
+		writeError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
+		return
+	}
+
+	// A locked account answers like a wrong password, so the lock reveals
+	// neither that the login exists nor that the password was right. The
+	// owner can still sign in from an address they signed in from before.
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) && !o.knownLoginAddress(r, user) {
+		o.audit(r, auditLoginThrottled, user.ID, req.Login, "account locked")
+		writeError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
+		return
+	}
+
+	if user.DisabledAt != nil {
+		writeError(w, http.StatusForbidden, "account_disabled", "Account is disabled")
+		return
+	}
+
+	o.logins.reset(loginKey)
+	o.upgradePasswordHash(user, req.Password)
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := o.Storage.ResetLoginFailures(user.ID); err != nil {
+			log.Printf("Failed to reset login failures: %v", err)
+		}
+	}
+
+	pair, err := o.startSession(user.ID)
 	if err != nil {
-		log.Printf("Failed to generate token: %v", err)
-		http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+		log.Printf("Failed to start session: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
 		return
 	}
+	o.audit(r, auditLoginSucceeded, user.ID, req.Login, "")
+	writeLoginResponse(w, pair, user)
+}
 
+func writeLoginResponse(w http.ResponseWriter, pair *tokenPair, user *storage.User) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"token": token,
+		"token":         pair.Token,
+		"refresh_token": pair.RefreshToken,
+		"expires_in":    pair.ExpiresIn,
 		"user": map[string]interface{}{
 			"id":    user.ID,
 			"login": user.Login,
+			"role":  user.Role,
 		},
 	})
 }
@@ -252,161 +360,58 @@ func (o *Orchestrator) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, `{"error":"Authorization header is required"}`, http.StatusUnauthorized)
+		p, err := o.authenticateCredentials(r.Header.Get("Authorization"), r.Header.Get(apiKeyHeader))
+		if errors.Is(err, errNoCredentials) {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Authorization header is required")
 			return
 		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == "" {
-			http.Error(w, `{"error":"Invalid authorization header format"}`, http.StatusUnauthorized)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "invalid_token", "Invalid token")
 			return
 		}
 
-		userID, err := auth.ParseJWT(tokenString)
-		if err != nil {
-			http.Error(w, `{"error":"Invalid token"}`, http.StatusUnauthorized)
+		if p.APIKeyID != 0 && requiresSession(r) {
+			writeError(w, http.StatusForbidden, "forbidden", "API keys cannot manage credentials or the account")
+			return
+		}
+		if !p.allows(requestScope(r.Method)) {
+			writeError(w, http.StatusForbidden, "insufficient_scope", "API key lacks the "+requestScope(r.Method)+" scope")
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), "userID", userID)
+		ctx := context.WithValue(r.Context(), "userID", p.UserID)
+		ctx = context.WithValue(ctx, "principal", p)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func (o *Orchestrator) calculateHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received calculate request")
-	userID, ok := r.Context().Value("userID").(int)
-	if !ok {
-		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
-	var req struct {
-		Expression string `json:"expression"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"Invalid Body"}`, http.StatusUnprocessableEntity)
-		return
-	}
-
-	dbExpr, err := o.Storage.CreateExpression(userID, req.Expression)
-	if err != nil {
-		http.Error(w, `{"error":"Failed to create expression"}`, http.StatusInternalServerError)
-		return
-	}
-
-	expr := &Expression{
-		ID:     strconv.Itoa(dbExpr.ID),
-		Expr:   req.Expression,
-		Status: "pending",
-	}
-
-	ast, err := ParseAST(req.Expression)
-	if err != nil {
-		o.Storage.UpdateExpression(&storage.Expression{
-			ID:     dbExpr.ID,
-			Status: "error",
-		})
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusUnprocessableEntity)
-		return
-	}
-
-	expr.AST = ast
-	o.Tasks(expr)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"id": expr.ID})
-}
-
-func (o *Orchestrator) expressionsHandler(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value("userID").(int)
-	if !ok {
-		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
-	dbExprs, err := o.Storage.GetExpressions(userID)
-	if err != nil {
-		http.Error(w, `{"error":"Failed to get expressions"}`, http.StatusInternalServerError)
-		return
-	}
-
-	response := make([]map[string]interface{}, len(dbExprs))
-	for i, expr := range dbExprs {
-		item := map[string]interface{}{
-			"id":         strconv.Itoa(expr.ID),
-			"expression": expr.Expression,
-			"status":     expr.Status,
-		}
-		if expr.Result != nil {
-			item["result"] = *expr.Result
-		}
-		response[i] = item
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"expressions": response})
-}
-
-func (o *Orchestrator) expressionIDHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, `{"error":"Wrong Method"}`, http.StatusMethodNotAllowed)
-		return
-	}
-
-	userID, ok := r.Context().Value("userID").(int)
-	if !ok {
-		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 2 {
-		http.Error(w, `{"error":"Invalid expression ID"}`, http.StatusBadRequest)
-		return
-	}
-	idStr := pathParts[len(pathParts)-1]
-
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		http.Error(w, `{"error":"Invalid expression ID"}`, http.StatusBadRequest)
-		return
+func expressionResponse(expr *storage.Expression) map[string]interface{} {
+	item := map[string]interface{}{
+		"id":         strconv.Itoa(expr.ID),
+		"expression": expr.Expression,
+		"status":     expr.Status,
+		"priority":   expr.Priority,
 	}
-
-	dbExpr, err := o.Storage.GetExpressionByID(id, userID)
-	if err != nil {
-		if errors.Is(err, storage.ErrNotFound) {
-			http.Error(w, `{"error":"Expression not found"}`, http.StatusNotFound)
-			return
-		}
-		http.Error(w, `{"error":"Failed to get expression"}`, http.StatusInternalServerError)
-		return
+	if expr.Result != nil {
+		item["result"] = *expr.Result
 	}
-
-	response := map[string]interface{}{
-		"id":         idStr,
-		"expression": dbExpr.Expression,
-		"status":     dbExpr.Status,
+	if expr.Deadline != nil {
+		item["deadline"] = expr.Deadline.Format(time.RFC3339)
 	}
-	if dbExpr.Result != nil {
-		response["result"] = *dbExpr.Result
+	if !expr.CreatedAt.IsZero() {
+		item["created_at"] = expr.CreatedAt.UTC().Format(time.RFC3339)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"expression": response})
+	return item
 }
 
 func (o *Orchestrator) getTaskHandler(w http.ResponseWriter, r *http.Request) {
-	task, err := o.Storage.GetPendingTask()
+	task, err := o.Storage.GetPendingTask(agentIDFromContext(r.Context()), o.Config.Agents.LeaseTTL)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
-			http.Error(w, `{"error":"No task available"}`, http.StatusNotFound)
+			writeError(w, http.StatusNotFound, "not_found", "No task available")
 			return
 		}
-		http.Error(w, `{"error":"Internal error"}`, http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Internal error")
 		return
 	}
 
@@ -421,12 +426,16 @@ func (o *Orchestrator) postTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"Invalid Body"}`, http.StatusUnprocessableEntity)
+		writeError(w, http.StatusUnprocessableEntity, "invalid_body", "Invalid Body")
 		return
 	}
 
-	if err := o.Storage.CompleteTask(req.ID, req.Result); err != nil {
-		http.Error(w, `{"error":"Failed to complete task"}`, http.StatusInternalServerError)
+	if err := o.Storage.CompleteTask(req.ID, agentIDFromContext(r.Context()), req.Result); err != nil {
+		if errors.Is(err, storage.ErrLeaseNotHeld) {
+			writeError(w, http.StatusConflict, "lease_not_held", "Task is not leased to this agent")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to complete task")
 		return
 	}
 
@@ -434,10 +443,16 @@ func (o *Orchestrator) postTaskHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"result accepted"}`))
 }
 
-func (o *Orchestrator) Tasks(expr *Expression) {
-	log.Printf("Creating tasks for expression %s", expr.ID)
-	exprID, _ := strconv.Atoi(expr.ID)
+func (o *Orchestrator) queueTask(task *Task) {
+	o.mu.Lock()
+	o.taskStore[task.ID] = task
+	o.taskQueue = append(o.taskQueue, task)
+	o.mu.Unlock()
+	log.Printf("Created task %s: %.2f %s %.2f",
+		task.ID, task.Arg1, task.Operation, task.Arg2)
+}
 
+func (o *Orchestrator) buildTasks(expr *Expression) []*Task {
 	var stack []*ASTNode
 	var tasks []*Task
 
@@ -460,8 +475,10 @@ func (o *Orchestrator) Tasks(expr *Expression) {
 			left := stack[len(stack)-2]
 			stack = stack[:len(stack)-2]
 
+			o.mu.Lock()
 			o.taskCounter++
 			taskID := fmt.Sprintf("%d", o.taskCounter)
+			o.mu.Unlock()
 
 			var opTime int
 			switch node.Operator {
@@ -500,34 +517,93 @@ func (o *Orchestrator) Tasks(expr *Expression) {
 	}
 
 	postOrder(expr.AST)
+	return tasks
+}
 
-	for _, task := range tasks {
-		if err := o.Storage.CreateTask(&storage.Task{
-			ID:            task.ID,
-			ExprID:        exprID,
-			Arg1:          task.Arg1,
-			Arg2:          task.Arg2,
-			Operation:     task.Operation,
-			OperationTime: task.OperationTime,
-		}); err != nil {
-			log.Printf("Failed to create task: %v", err)
-			continue
-		}
-		o.taskStore[task.ID] = task
-		o.taskQueue = append(o.taskQueue, task)
-		log.Printf("Created task %s: %.2f %s %.2f",
-			task.ID, task.Arg1, task.Operation, task.Arg2)
+func (o *Orchestrator) expireDeadlines() {
+	ids, err := o.Storage.ExpireExpressions(time.Now())
+	if err != nil {
+		log.Printf("Failed to expire expressions: %v", err)
+		return
+	}
+	for _, id := range ids {
+		log.Printf("Expression %d expired, remaining tasks cancelled", id)
 	}
 }
 
+func (o *Orchestrator) Handler() (http.Handler, error) {
+	gateway, err := o.gatewayHandler()
+	if err != nil {
+		return nil, fmt.Errorf("failed to register gateway: %v", err)
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v1/login", o.loginHandler)
+	mux.HandleFunc("/api/v1/register", o.registerHandler)
+	mux.HandleFunc("/api/v1/refresh", o.refreshHandler)
+	mux.HandleFunc("/api/v1/logout", o.logoutHandler)
+	mux.HandleFunc("GET /api/v1/oidc/login", o.oidcLoginHandler)
+	mux.HandleFunc("GET /api/v1/oidc/callback", o.oidcCallbackHandler)
+	mux.HandleFunc("GET "+sharedExpressionPath+"{token}", o.sharedExpressionHandler)
+	mux.Handle("/api/v1/ws", o.websocketHandler())
+	mux.HandleFunc("/api/v1/openapi.json", o.openAPIHandler)
+	mux.HandleFunc("GET /.well-known/jwks.json", o.jwksHandler)
+
+	protected := http.NewServeMux()
+	protected.Handle("/calculate/batch", o.limitBody(http.HandlerFunc(o.batchHandler)))
+	protected.HandleFunc("/batches/", o.batchIDHandler)
+	protected.HandleFunc("GET /me", o.meHandler)
+	protected.HandleFunc("DELETE /me", o.deleteMeHandler)
+	protected.HandleFunc("PUT /me/password", o.changePasswordHandler)
+	protected.HandleFunc("/me/quota", o.quotaHandler)
+	protected.HandleFunc("GET /me/shared", o.sharedWithMeHandler)
+	protected.HandleFunc("/webhooks", o.webhooksHandler)
+	protected.HandleFunc("/webhooks/", o.webhookIDHandler)
+	protected.HandleFunc("/api-keys", o.apiKeysHandler)
+	protected.HandleFunc("/api-keys/", o.apiKeyIDHandler)
+
+	api := http.NewServeMux()
+	api.Handle("/api/v1/calculate", o.limitBody(gateway))
+	api.Handle("/api/v1/expressions", o.limitBody(gateway))
+	api.Handle("/api/v1/expressions/", o.limitBody(gateway))
+	api.HandleFunc("GET /api/v1/expressions/{id}/events", o.expressionEventsHandler)
+	api.HandleFunc("GET /api/v1/expressions/{id}/shares", o.sharesHandler)
+	api.HandleFunc("POST /api/v1/expressions/{id}/shares", o.sharesHandler)
+	api.HandleFunc("DELETE /api/v1/expressions/{id}/shares/{share_id}", o.deleteShareHandler)
+	api.HandleFunc("GET /api/v1/admin/users", requireRole(o.adminUsersHandler, auth.RoleAdmin))
+	api.HandleFunc("PATCH /api/v1/admin/users/{id}", requireRole(o.adminUpdateUserHandler, auth.RoleAdmin))
+	api.HandleFunc("GET /api/v1/admin/expressions", requireRole(o.adminExpressionsHandler, auth.RoleAdmin))
+	api.HandleFunc("GET /api/v1/admin/audit-events", requireRole(o.adminAuditEventsHandler, auth.RoleAdmin))
+	api.HandleFunc("GET /api/v1/admin/tasks", requireRole(o.adminTasksHandler, auth.RoleAdmin, auth.RoleAgentOperator))
+	api.HandleFunc("POST /api/v1/admin/tasks/{id}/requeue", requireRole(o.adminTaskActionHandler(o.Storage.RequeueTask), auth.RoleAdmin, auth.RoleAgentOperator))
+	api.HandleFunc("POST /api/v1/admin/tasks/{id}/cancel", requireRole(o.adminTaskActionHandler(o.Storage.CancelTask), auth.RoleAdmin, auth.RoleAgentOperator))
+	api.Handle("/api/v1/", http.StripPrefix("/api/v1", protected))
+
+	mux.Handle("/api/v1/", o.authMiddleware(api))
+
+	internal := http.NewServeMux()
+	internal.HandleFunc("GET /internal/task", o.getTaskHandler)
+	internal.HandleFunc("POST /internal/task", o.postTaskHandler)
+	mux.Handle("/internal/", o.agentMiddleware(internal))
+
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "route_not_found", "API Not Found")
+	})
+
+	return mux, nil
+}
+
 func (o *Orchestrator) RunServer() error {
 	lis, err := net.Listen("tcp", ":"+o.Config.GRPCAddr)
 	if err != nil {
 		return fmt.Errorf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
-	proto.RegisterCalculatorServer(grpcServer, &server{o: o})
+	grpcServer, err := o.newGRPCServer()
+	if err != nil {
+		return err
+	}
 
 	go func() {
 		log.Printf("Starting gRPC server on port %s", o.Config.GRPCAddr)
@@ -536,28 +612,12 @@ func (o *Orchestrator) RunServer() error {
 		}
 	}()
 
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("/api/v1/login", o.loginHandler)
-	mux.HandleFunc("/api/v1/register", o.registerHandler)
-
-	protected := http.NewServeMux()
-	protected.HandleFunc("/calculate", o.calculateHandler)
-	protected.HandleFunc("/expressions", o.expressionsHandler)
-	protected.HandleFunc("/expressions/", o.expressionIDHandler)
-	protected.HandleFunc("/internal/task", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			o.getTaskHandler(w, r)
-		} else if r.Method == http.MethodPost {
-			o.postTaskHandler(w, r)
-		}
-	})
-
-	mux.Handle("/api/v1/", http.StripPrefix("/api/v1", o.authMiddleware(protected)))
+	handler, err := o.Handler()
+	if err != nil {
+		return err
+	}
 
-	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, `{"error":"API Not Found"}`, http.StatusNotFound)
-	})
+	go webhooks.NewDispatcher(o.Storage, o.Config.Webhooks).Run(context.Background())
 
 	go func() {
 		for {
@@ -567,9 +627,14 @@ func (o *Orchestrator) RunServer() error {
 				log.Printf("Pending tasks in queue: %d", len(o.taskQueue))
 			}
 			o.mu.Unlock()
+			o.expireDeadlines()
+			o.purgeIdempotencyKeys()
+			o.purgeRefreshTokens()
+			o.purgeLoginFailures()
+			o.purgeOIDCLogins()
 		}
 	}()
 
 	log.Printf("Starting HTTP server on port %s", o.Config.HTTPAddr)
-	return http.ListenAndServe(":"+o.Config.HTTPAddr, mux)
+	return http.ListenAndServe(":"+o.Config.HTTPAddr, handler)
 }
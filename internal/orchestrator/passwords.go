@@ -0,0 +1,79 @@
+package orchestrator
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/storage"
+)
+
+// hasherConfiguration reads PASSWORD_HASH (bcrypt or argon2id), BCRYPT_COST
+// and the ARGON2_* parameters.
+func hasherConfiguration() auth.Hasher {
+	d := auth.DefaultHasher
+	h := auth.Hasher{
+		Algorithm:  envString("PASSWORD_HASH", d.Algorithm),
+		BcryptCost: envInt("BCRYPT_COST", d.BcryptCost),
+		Argon2: auth.Argon2Params{
+			Time:    uint32(envUint("ARGON2_TIME", uint64(d.Argon2.Time), 32)),
+			Memory:  uint32(envUint("ARGON2_MEMORY_KIB", uint64(d.Argon2.Memory), 32)),
+			Threads: uint8(envUint("ARGON2_THREADS", uint64(d.Argon2.Threads), 8)),
+			SaltLen: d.Argon2.SaltLen,
+			KeyLen:  d.Argon2.KeyLen,
+		},
+	}
+	if err := h.Validate(); err != nil {
+		log.Fatalf("invalid password hash configuration: %v", err)
+	}
+	return h
+}
+
+// envUint reads an unsigned integer of the given bit size. Unlike envInt it
+// exits on a value it cannot use, since converting an out-of-range value
+// would silently wrap it into very different hashing parameters.
+func envUint(name string, def uint64, bits int) uint64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseUint(v, 10, bits)
+	if err != nil {
+		log.Fatalf("invalid %s: must be an integer between 0 and %d", name, uint64(1)<<bits-1)
+	}
+	return n
+}
+
+// upgradePasswordHash rehashes a password that has just been verified if
+// its stored hash uses another algorithm or outdated parameters. Failures
+// are logged; the old hash keeps working.
+func (o *Orchestrator) upgradePasswordHash(user *storage.User, password string) {
+	if !o.Config.Hasher.NeedsRehash(user.Password) {
+		return
+	}
+	hash, err := o.Config.Hasher.Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v", user.ID, err)
+		return
+	}
+	if err := o.Storage.UpgradePasswordHash(user.ID, user.Password, hash); err != nil {
+		log.Printf("Failed to store rehashed password of user %d: %v", user.ID, err)
+		return
+	}
+	user.Password = hash
+}
+
+// checkDummyPassword spends as long on an unknown login as checking a real
+// password would, so the response time does not tell whether it exists.
+func (o *Orchestrator) checkDummyPassword(password string) {
+	o.dummyHashOnce.Do(func() {
+		hash, err := o.Config.Hasher.Hash("dummy password")
+		if err != nil {
+			log.Printf("Failed to hash dummy password: %v", err)
+			return
+		}
+		o.dummyHash = hash
+	})
+	auth.CheckPasswordHash(password, o.dummyHash)
+}
@@ -0,0 +1,64 @@
+package orchestrator
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"calc_service/internal/auth"
+)
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	hash, _ := o.Config.Hasher.Hash("correct-horse")
+	userID, _ := o.Storage.CreateUser("alice", hash)
+
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/login", "", `{"login":"alice","password":"correct-horse"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if user, _ := o.Storage.GetUserByID(userID); user.Password != hash {
+		t.Error("Expected a current hash to be left alone")
+	}
+
+	o.Config.Hasher = auth.Hasher{Algorithm: auth.HashArgon2id, Argon2: auth.Argon2Params{Time: 1, Memory: 64, Threads: 1, SaltLen: 16, KeyLen: 32}}
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/login", "", `{"login":"alice","password":"wrong-horse"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", rec.Code)
+	}
+	if user, _ := o.Storage.GetUserByID(userID); user.Password != hash {
+		t.Error("Expected a failed login not to touch the hash")
+	}
+
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/login", "", `{"login":"alice","password":"correct-horse"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	user, _ := o.Storage.GetUserByID(userID)
+	if !strings.HasPrefix(user.Password, "$argon2id$") || !auth.CheckPasswordHash("correct-horse", user.Password) {
+		t.Fatalf("Expected the hash to be upgraded to argon2id, got %q", user.Password)
+	}
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/login", "", `{"login":"alice","password":"correct-horse"}`); rec.Code != http.StatusOK {
+		t.Errorf("Expected the upgraded hash to work, got %d", rec.Code)
+	}
+
+	if err := o.Storage.UpgradePasswordHash(userID, hash, "stale"); err != nil {
+		t.Fatalf("UpgradePasswordHash failed: %v", err)
+	}
+	if after, _ := o.Storage.GetUserByID(userID); after.Password != user.Password {
+		t.Error("Expected an upgrade from a stale hash to be ignored")
+	}
+}
+
+func TestHasherConfiguration(t *testing.T) {
+	t.Setenv("PASSWORD_HASH", auth.HashArgon2id)
+	t.Setenv("ARGON2_THREADS", "255")
+	t.Setenv("ARGON2_MEMORY_KIB", "4294967295")
+
+	h := hasherConfiguration()
+	if h.Argon2.Threads != 255 || h.Argon2.Memory != 4294967295 || h.Argon2.Time != auth.DefaultArgon2.Time {
+		t.Errorf("Unexpected argon2 parameters %+v", h.Argon2)
+	}
+}
@@ -0,0 +1,256 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"calc_service/internal/storage"
+)
+
+const rateWindow = time.Minute
+
+type QuotaConfig struct {
+	RequestsPerMinute     int
+	MaxPendingExpressions int
+	MaxASTNodes           int
+	MaxASTDepth           int
+	MaxTasksPerExpression int
+	MaxBatchSize          int
+	MaxBodyBytes          int
+}
+
+func quotaConfiguration() QuotaConfig {
+	return QuotaConfig{
+		RequestsPerMinute:     envInt("QUOTA_REQUESTS_PER_MINUTE", 60),
+		MaxPendingExpressions: envInt("QUOTA_MAX_PENDING_EXPRESSIONS", 20),
+		MaxASTNodes:           envInt("QUOTA_MAX_AST_NODES", 1000),
+		MaxASTDepth:           envInt("QUOTA_MAX_AST_DEPTH", 100),
+		MaxTasksPerExpression: envInt("QUOTA_MAX_TASKS_PER_EXPRESSION", 500),
+		MaxBatchSize:          envInt("QUOTA_MAX_BATCH_SIZE", 1000),
+		MaxBodyBytes:          envInt("QUOTA_MAX_BODY_BYTES", 1<<20),
+	}
+}
+
+func envInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || v < 0 {
+		return def
+	}
+	return v
+}
+
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	requests map[int][]time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{requests: make(map[int][]time.Time)}
+}
+
+func (l *rateLimiter) prune(userID int, now time.Time) []time.Time {
+	reqs := l.requests[userID]
+	i := 0
+	for i < len(reqs) && now.Sub(reqs[i]) >= rateWindow {
+		i++
+	}
+	reqs = reqs[i:]
+	if len(reqs) == 0 {
+		delete(l.requests, userID)
+	} else {
+		l.requests[userID] = reqs
+	}
+	return reqs
+}
+
+func (l *rateLimiter) allow(userID, limit int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reqs := l.prune(userID, now)
+	if limit > 0 && len(reqs) >= limit {
+		return false, reqs[0].Add(rateWindow).Sub(now)
+	}
+	l.requests[userID] = append(reqs, now)
+	return true, 0
+}
+
+// release gives back the slot taken at the given time.
+func (l *rateLimiter) release(userID int, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reqs := l.requests[userID]
+	for i := len(reqs) - 1; i >= 0; i-- {
+		if reqs[i].Equal(at) {
+			l.requests[userID] = append(reqs[:i:i], reqs[i+1:]...)
+			break
+		}
+	}
+	l.prune(userID, at)
+}
+
+func (l *rateLimiter) usage(userID int, now time.Time) (int, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reqs := l.prune(userID, now)
+	if len(reqs) == 0 {
+		return 0, now
+	}
+	return len(reqs), reqs[0].Add(rateWindow)
+}
+
+// limitBody caps the request body at MaxBodyBytes. A body declared larger
+// is refused at once; one that turns out larger fails to decode.
+func (o *Orchestrator) limitBody(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := int64(o.Config.Quota.MaxBodyBytes)
+		if limit > 0 {
+			if r.ContentLength > limit {
+				writeProblem(w, bodyTooLarge(limit))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func bodyTooLarge(limit int64) *apiError {
+	return &apiError{
+		Status:  http.StatusRequestEntityTooLarge,
+		Code:    "body_too_large",
+		Message: fmt.Sprintf("Request body exceeds %d bytes", limit),
+	}
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// takeRateSlot counts a submission against the per-minute limit. Callers
+// give the slot back with the returned func when nothing was stored, so
+// requests refused for other reasons do not use up the limit.
+func (o *Orchestrator) takeRateSlot(userID int) (func(), error) {
+	now := time.Now()
+	if ok, retry := o.limiter.allow(userID, o.Config.Quota.RequestsPerMinute, now); !ok {
+		return nil, &apiError{
+			Status:     http.StatusTooManyRequests,
+			Code:       "rate_limited",
+			Message:    "Rate limit exceeded",
+			RetryAfter: retry,
+		}
+	}
+	return func() { o.limiter.release(userID, now) }, nil
+}
+
+// quotaError turns the storage's pending limit into the API error.
+func quotaError(err error) error {
+	if errors.Is(err, storage.ErrTooManyPending) {
+		return &apiError{
+			Status:     http.StatusTooManyRequests,
+			Code:       "too_many_pending",
+			Message:    "Too many pending expressions",
+			RetryAfter: 2 * time.Second,
+		}
+	}
+	return err
+}
+
+// parseExpression parses the expression within the AST quotas. Nesting is
+// limited while parsing, so a deeply nested expression is refused before
+// it can exhaust the stack.
+func (q QuotaConfig) parseExpression(expression string) (*ASTNode, error) {
+	limit := maxParseNesting
+	if q.MaxASTDepth > 0 {
+		limit = q.MaxASTDepth
+	}
+	ast, err := parseAST(expression, limit)
+	var nerr *NestingError
+	if errors.As(err, &nerr) {
+		return nil, complexityError(fmt.Sprintf("expression exceeds depth %d", nerr.Limit), "max_ast_depth", nerr.Limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := q.checkAST(ast); err != nil {
+		return nil, err
+	}
+	return ast, nil
+}
+
+func (q QuotaConfig) checkAST(ast *ASTNode) error {
+	if q.MaxASTNodes > 0 && ast.Size() > q.MaxASTNodes {
+		return complexityError(fmt.Sprintf("expression exceeds %d nodes", q.MaxASTNodes), "max_ast_nodes", q.MaxASTNodes)
+	}
+	if q.MaxASTDepth > 0 && ast.Depth() > q.MaxASTDepth {
+		return complexityError(fmt.Sprintf("expression exceeds depth %d", q.MaxASTDepth), "max_ast_depth", q.MaxASTDepth)
+	}
+	if q.MaxTasksPerExpression > 0 && ast.OperationCount() > q.MaxTasksPerExpression {
+		return complexityError(fmt.Sprintf("expression exceeds %d tasks", q.MaxTasksPerExpression), "max_tasks_per_expression", q.MaxTasksPerExpression)
+	}
+	return nil
+}
+
+func complexityError(message, limit string, value int) *apiError {
+	return &apiError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    "expression_too_complex",
+		Message: message,
+		Details: map[string]interface{}{"limit": limit, "value": value},
+	}
+}
+
+func (o *Orchestrator) quotaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	pending, err := o.Storage.CountPendingExpressions(userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get quota")
+		return
+	}
+
+	q := o.Config.Quota
+	used, reset := o.limiter.usage(userID, time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"limits": map[string]int{
+			"requests_per_minute":      q.RequestsPerMinute,
+			"max_pending_expressions":  q.MaxPendingExpressions,
+			"max_ast_nodes":            q.MaxASTNodes,
+			"max_ast_depth":            q.MaxASTDepth,
+			"max_tasks_per_expression": q.MaxTasksPerExpression,
+			"max_batch_size":           q.MaxBatchSize,
+		},
+		"usage": map[string]interface{}{
+			"requests_last_minute": used,
+			"pending_expressions":  pending,
+			"window_reset":         reset.UTC().Format(time.RFC3339),
+		},
+	})
+}
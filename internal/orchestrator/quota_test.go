@@ -0,0 +1,147 @@
+package orchestrator
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow(1, 3, now); !ok {
+			t.Fatalf("Request %d should be allowed", i)
+		}
+	}
+
+	ok, retry := l.allow(1, 3, now.Add(10*time.Second))
+	if ok {
+		t.Fatal("Fourth request in the same minute should be rejected")
+	}
+	if retry != 50*time.Second {
+		t.Errorf("Expected retry after 50s, got %v", retry)
+	}
+
+	if ok, _ := l.allow(2, 3, now); !ok {
+		t.Error("Limits must be tracked per user")
+	}
+
+	if ok, _ := l.allow(1, 3, now.Add(rateWindow)); !ok {
+		t.Error("Request after the window should be allowed")
+	}
+}
+
+func TestSubmitQuota(t *testing.T) {
+	o := newTestOrchestrator(t)
+	o.Config.Quota.RequestsPerMinute = 2
+	o.Config.Quota.MaxPendingExpressions = 1
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("quotauser", "hash")
+	token := mustToken(t, o, userID)
+
+	rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/calculate", token, `{"expression":"2+"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected 422 for invalid expression, got %d", rec.Code)
+	}
+	if exprs, _ := o.Storage.GetExpressions(userID); len(exprs) != 0 {
+		t.Errorf("An invalid expression must not be stored, got %d", len(exprs))
+	}
+
+	rec, _ = doJSON(t, h, http.MethodPost, "/api/v1/calculate", token, `{"expression":"2+2"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/calculate", token, `{"expression":"3+3"}`)
+	if rec.Code != http.StatusTooManyRequests || resp["code"] != "too_many_pending" {
+		t.Fatalf("Expected 429 too_many_pending, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if used, _ := o.limiter.usage(userID, time.Now()); used != 1 {
+		t.Errorf("Only the stored expression should use the rate limit, got %d", used)
+	}
+	if pending, _ := o.Storage.CountPendingExpressions(userID); pending != 1 {
+		t.Errorf("Expected 1 pending expression, got %d", pending)
+	}
+}
+
+func TestCheckAST(t *testing.T) {
+	ast, err := ParseAST("(1+2)*(3+4)")
+	if err != nil {
+		t.Fatalf("ParseAST failed: %v", err)
+	}
+
+	if ast.Size() != 7 || ast.Depth() != 3 || ast.OperationCount() != 3 {
+		t.Fatalf("Unexpected AST metrics: size=%d depth=%d ops=%d",
+			ast.Size(), ast.Depth(), ast.OperationCount())
+	}
+
+	tests := []struct {
+		name    string
+		quota   QuotaConfig
+		wantErr bool
+	}{
+		{"unlimited", QuotaConfig{}, false},
+		{"within limits", QuotaConfig{MaxASTNodes: 7, MaxASTDepth: 3, MaxTasksPerExpression: 3}, false},
+		{"too many nodes", QuotaConfig{MaxASTNodes: 6}, true},
+		{"too deep", QuotaConfig{MaxASTDepth: 2}, true},
+		{"too many tasks", QuotaConfig{MaxTasksPerExpression: 2}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.quota.checkAST(ast)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkAST() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseExpressionNesting(t *testing.T) {
+	deep := strings.Repeat("(", 1_000_000) + "1" + strings.Repeat(")", 1_000_000)
+
+	_, err := QuotaConfig{MaxASTDepth: 100}.parseExpression(deep)
+	var aerr *apiError
+	if !errors.As(err, &aerr) || aerr.Code != "expression_too_complex" || aerr.Details["limit"] != "max_ast_depth" {
+		t.Errorf("Expected the depth quota to stop the parse, got %v", err)
+	}
+
+	var nerr *NestingError
+	if _, err := ParseAST(deep); !errors.As(err, &nerr) || nerr.Limit != maxParseNesting {
+		t.Errorf("Expected the parser's own limit without a quota, got %v", err)
+	}
+
+	if _, err := (QuotaConfig{MaxASTDepth: 3}).parseExpression("((1+2)*3)"); err != nil {
+		t.Errorf("Expected nesting within the limit to parse, got %v", err)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	o := newTestOrchestrator(t)
+	o.Config.Quota.MaxBodyBytes = 64
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("bodyuser", "hash")
+	token := mustToken(t, o, userID)
+
+	body := `{"expression":"` + strings.Repeat("1+", 100) + `1"}`
+	rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/calculate", token, body)
+	if rec.Code != http.StatusRequestEntityTooLarge || resp["code"] != "body_too_large" {
+		t.Errorf("Expected 413 body_too_large, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/calculate", token, `{"expression":"1+1"}`); rec.Code != http.StatusCreated {
+		t.Errorf("Expected a small body to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,61 @@
+package orchestrator
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/storage"
+)
+
+// principal is an authenticated caller. Callers holding a session token may
+// do anything their role allows; API keys are further limited to their
+// scopes.
+type principal struct {
+	UserID   int
+	Role     string
+	APIKeyID int
+	Scopes   []string
+}
+
+func (p *principal) hasRole(roles ...string) bool {
+	for _, role := range roles {
+		if p.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// requireRole lets the request through only for callers with one of the
+// roles; authMiddleware puts the caller in the context. API keys carry their
+// owner's role but are never accepted here, so a leaked key of an admin
+// cannot manage other users.
+func requireRole(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := r.Context().Value("principal").(*principal)
+		if ok && p.APIKeyID != 0 {
+			writeError(w, http.StatusForbidden, "forbidden", "API keys cannot be used for this endpoint")
+			return
+		}
+		if !ok || !p.hasRole(roles...) {
+			writeError(w, http.StatusForbidden, "forbidden", "Insufficient role")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// bootstrapAdmins grants the admin role to the logins in ADMIN_LOGINS at
+// startup, so a fresh deployment has someone who can manage the others. Only
+// accounts that already exist are promoted; registering one of these logins
+// does not grant the role.
+func (o *Orchestrator) bootstrapAdmins() {
+	for _, login := range o.Config.AdminLogins {
+		err := o.Storage.SetUserRoleByLogin(login, auth.RoleAdmin)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to grant admin role to %s: %v", login, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package orchestrator
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"calc_service/internal/auth"
+)
+
+func TestAdminEndpoints(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	adminID, _ := o.Storage.CreateUser("root", "hash")
+	o.Storage.SetUserRole(adminID, auth.RoleAdmin)
+	admin, _ := o.startSession(adminID)
+
+	userID, _ := o.Storage.CreateUser("alice", "hash")
+	user, _ := o.startSession(userID)
+
+	for _, path := range []string{"/api/v1/admin/users", "/api/v1/admin/expressions", "/api/v1/admin/tasks"} {
+		if rec, _ := doJSON(t, h, http.MethodGet, path, user.Token, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("GET %s as user: expected 403, got %d", path, rec.Code)
+		}
+	}
+
+	rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/calculate", user.Token, `{"expression":"2+2"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", rec.Code)
+	}
+	exprID, _ := resp["id"].(string)
+
+	_, resp = doJSON(t, h, http.MethodGet, "/api/v1/admin/expressions", admin.Token, "")
+	exprs, _ := resp["expressions"].([]interface{})
+	if len(exprs) != 1 || exprs[0].(map[string]interface{})["user_id"] != float64(userID) {
+		t.Fatalf("Expected alice's expression in the admin listing, got %v", resp)
+	}
+
+	_, resp = doJSON(t, h, http.MethodGet, "/api/v1/admin/users", admin.Token, "")
+	if users, _ := resp["users"].([]interface{}); len(users) != 2 {
+		t.Fatalf("Expected 2 users, got %v", resp)
+	}
+
+	// Demoting an admin takes effect at once, not when their token expires.
+	otherAdminID, _ := o.Storage.CreateUser("deputy", "hash")
+	o.Storage.SetUserRole(otherAdminID, auth.RoleAdmin)
+	deputy, _ := o.startSession(otherAdminID)
+	if rec, _ := doJSON(t, h, http.MethodPatch, fmt.Sprintf("/api/v1/admin/users/%d", otherAdminID), admin.Token, `{"role":"user"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 demoting an admin, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/admin/users", deputy.Token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the demoted admin's token to be rejected, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodPatch, fmt.Sprintf("/api/v1/admin/users/%d", userID), admin.Token, `{"role":"user"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 setting the same role, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/expressions", user.Token, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected an unchanged role to keep the session, got %d", rec.Code)
+	}
+
+	if rec, _ := doJSON(t, h, http.MethodPatch, fmt.Sprintf("/api/v1/admin/users/%d", adminID), admin.Token, `{"disabled":true}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected admins to be unable to disable themselves, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodPatch, fmt.Sprintf("/api/v1/admin/users/%d", userID), admin.Token, `{"role":"root"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown role to be rejected, got %d", rec.Code)
+	}
+
+	rec, resp = doJSON(t, h, http.MethodPatch, fmt.Sprintf("/api/v1/admin/users/%d", userID), admin.Token, `{"role":"agent-operator"}`)
+	if rec.Code != http.StatusOK || resp["role"] != auth.RoleAgentOperator {
+		t.Fatalf("Expected role change, got %d: %v", rec.Code, resp)
+	}
+	// The old session carried the old role, so it ends and the user signs in
+	// again to act with the new one.
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/expressions", user.Token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the role change to revoke the access token, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/refresh", "", fmt.Sprintf(`{"refresh_token":%q}`, user.RefreshToken)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the role change to revoke the refresh token, got %d", rec.Code)
+	}
+	session, _ := o.startSession(userID)
+	operator := session.Token
+
+	rec, resp = doJSON(t, h, http.MethodGet, "/api/v1/admin/tasks", operator, "")
+	tasks, _ := resp["tasks"].([]interface{})
+	if rec.Code != http.StatusOK || len(tasks) == 0 {
+		t.Fatalf("Expected open tasks for the operator, got %d: %v", rec.Code, resp)
+	}
+	taskID, _ := tasks[0].(map[string]interface{})["id"].(string)
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/admin/users", operator, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected operators to be kept out of user management, got %d", rec.Code)
+	}
+
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/admin/tasks/"+taskID+"/requeue", operator, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 on requeue, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/admin/tasks/"+taskID+"/cancel", operator, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 on cancel, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/admin/tasks/"+taskID+"/cancel", operator, ""); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 cancelling a cancelled task, got %d", rec.Code)
+	}
+	if rec, resp := doJSON(t, h, http.MethodGet, "/api/v1/expressions/"+exprID, operator, ""); rec.Code != http.StatusOK || resp["expression"].(map[string]interface{})["status"] != "cancelled" {
+		t.Errorf("Expected the expression to be cancelled, got %d: %v", rec.Code, resp)
+	}
+
+	if rec, _ := doJSON(t, h, http.MethodPatch, fmt.Sprintf("/api/v1/admin/users/%d", userID), admin.Token, `{"disabled":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 disabling a user, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/expressions", operator, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected tokens of a disabled user to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestBootstrapAdmins(t *testing.T) {
+	o := newTestOrchestrator(t)
+	o.Config.AdminLogins = []string{"root"}
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/register", "", `{"login":"root","password":"correct-horse-1"}`)
+	if rec.Code != http.StatusCreated || resp["role"] != auth.RoleUser {
+		t.Fatalf("Expected registering an ADMIN_LOGINS login to create a plain user, got %d: %v", rec.Code, resp)
+	}
+	user, _ := o.Storage.GetUserByLogin("root")
+	if user.Role != auth.RoleUser {
+		t.Fatalf("Expected role %q after registration, got %q", auth.RoleUser, user.Role)
+	}
+
+	o.bootstrapAdmins()
+	if user, _ = o.Storage.GetUserByLogin("root"); user.Role != auth.RoleAdmin {
+		t.Errorf("Expected the existing account to be promoted at startup, got %q", user.Role)
+	}
+}
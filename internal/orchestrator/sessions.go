@@ -0,0 +1,156 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/storage"
+)
+
+var (
+	errSessionRevoked  = errors.New("session revoked")
+	errNoSession       = errors.New("token has no session")
+	errAccountDisabled = errors.New("account disabled")
+)
+
+// authenticate validates an access token and returns its user. Tokens of a
+// revoked session are rejected even before they expire, and a token without
+// a session could never be revoked, so it is not accepted at all.
+func (o *Orchestrator) authenticate(token string) (*principal, error) {
+	claims, err := o.Tokens.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID == 0 {
+		return nil, errNoSession
+	}
+	revoked, err := o.Storage.SessionRevoked(claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errSessionRevoked
+	}
+	return &principal{UserID: claims.UserID, Role: claims.Role}, nil
+}
+
+type tokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// startSession opens a login session and returns its first token pair.
+func (o *Orchestrator) startSession(userID int) (*tokenPair, error) {
+	refresh, hash := auth.NewRefreshToken()
+	session, err := o.Storage.CreateSession(userID, hash, time.Now().Add(o.Config.RefreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+	return o.tokenPair(session, refresh)
+}
+
+// tokenPair issues an access token for the session with the user's current
+// role. A role change revokes the user's sessions, so no token outlives it.
+func (o *Orchestrator) tokenPair(session *storage.Session, refresh string) (*tokenPair, error) {
+	user, err := o.Storage.GetUserByID(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt != nil {
+		return nil, errAccountDisabled
+	}
+
+	token, err := o.Tokens.Generate(user.ID, user.Role, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenPair{
+		Token:        token,
+		RefreshToken: refresh,
+		ExpiresIn:    int(o.Tokens.TTL().Seconds()),
+	}, nil
+}
+
+func decodeRefreshToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		return "", false
+	}
+	if req.RefreshToken == "" {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "refresh_token is required")
+		return "", false
+	}
+	return req.RefreshToken, true
+}
+
+func (o *Orchestrator) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	refresh, ok := decodeRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	next, hash := auth.NewRefreshToken()
+	session, err := o.Storage.RotateRefreshToken(auth.HashToken(refresh), hash, time.Now().Add(o.Config.RefreshTokenTTL))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrTokenReused):
+			log.Printf("Refresh token reuse detected, session revoked")
+			writeError(w, http.StatusUnauthorized, "refresh_token_reused", "Refresh token was already used, session revoked")
+		case errors.Is(err, storage.ErrNotFound):
+			writeError(w, http.StatusUnauthorized, "invalid_refresh_token", "Invalid refresh token")
+		default:
+			log.Printf("Failed to rotate refresh token: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		}
+		return
+	}
+
+	pair, err := o.tokenPair(session, next)
+	if err != nil {
+		log.Printf("Failed to generate token: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pair)
+}
+
+func (o *Orchestrator) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	refresh, ok := decodeRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	err := o.Storage.RevokeSessionByRefreshToken(auth.HashToken(refresh))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Failed to revoke session: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (o *Orchestrator) purgeRefreshTokens() {
+	if _, err := o.Storage.PurgeRefreshTokens(time.Now()); err != nil {
+		log.Printf("Failed to purge refresh tokens: %v", err)
+	}
+}
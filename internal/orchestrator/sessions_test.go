@@ -0,0 +1,93 @@
+package orchestrator
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"calc_service/internal/auth"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("sessionuser", "hash")
+	pair, err := o.startSession(userID)
+	if err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+
+	refresh := func(token string) (int, map[string]interface{}) {
+		rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/refresh", "", fmt.Sprintf(`{"refresh_token":%q}`, token))
+		return rec.Code, resp
+	}
+	authorized := func(token string) bool {
+		rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/expressions", token, "")
+		return rec.Code == http.StatusOK
+	}
+
+	code, resp := refresh(pair.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 on refresh, got %d: %v", code, resp)
+	}
+	rotated, _ := resp["refresh_token"].(string)
+	access, _ := resp["token"].(string)
+	if rotated == "" || rotated == pair.RefreshToken || access == "" {
+		t.Fatalf("Expected a new token pair, got %v", resp)
+	}
+	if !authorized(access) || !authorized(pair.Token) {
+		t.Fatal("Expected access tokens of a live session to be accepted")
+	}
+
+	code, resp = refresh(pair.RefreshToken)
+	if code != http.StatusUnauthorized || resp["code"] != "refresh_token_reused" {
+		t.Fatalf("Expected refresh_token_reused, got %d: %v", code, resp)
+	}
+	if authorized(access) {
+		t.Error("Access token was accepted after refresh token reuse")
+	}
+	if code, _ := refresh(rotated); code != http.StatusUnauthorized {
+		t.Errorf("Expected the rotated token to die with its session, got %d", code)
+	}
+
+	if code, resp := refresh("bogus"); code != http.StatusUnauthorized || resp["code"] != "invalid_refresh_token" {
+		t.Errorf("Expected invalid_refresh_token, got %d: %v", code, resp)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("logoutuser", "hash")
+	pair, _ := o.startSession(userID)
+	other, _ := o.startSession(userID)
+
+	body := fmt.Sprintf(`{"refresh_token":%q}`, pair.RefreshToken)
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/logout", "", body); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 on logout, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/expressions", pair.Token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for the logged out session, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/refresh", "", body); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 refreshing a logged out session, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/expressions", other.Token, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected other sessions to stay valid, got %d", rec.Code)
+	}
+
+	// A validly signed token outside any session could never be logged out.
+	orphan, _ := o.Tokens.Generate(userID, auth.RoleUser, 0)
+	if rec, _ := doJSON(t, h, http.MethodGet, "/api/v1/expressions", orphan, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a token without a session, got %d", rec.Code)
+	}
+}
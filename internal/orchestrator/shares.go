@@ -0,0 +1,190 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/storage"
+)
+
+const sharedExpressionPath = "/api/v1/shared/"
+
+func shareResponse(sh *storage.Share) map[string]interface{} {
+	item := map[string]interface{}{
+		"id":            strconv.Itoa(sh.ID),
+		"expression_id": strconv.Itoa(sh.ExpressionID),
+		"public":        sh.Public(),
+		"created_at":    sh.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if !sh.Public() {
+		item["user_id"] = sh.GranteeID
+	}
+	return item
+}
+
+// sharesHandler lists and creates shares of an expression the caller owns.
+// A share either names a user, who can then read the expression with their
+// own credentials, or is a public link whose token is returned only once.
+// Users are named by ID, which the grantee reads from GET /me and passes on;
+// a login would make the answer tell which logins exist, and responses never
+// give the grantee's login, so IDs cannot be turned into logins either.
+func (o *Orchestrator) sharesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+	exprID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid expression ID")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		shares, err := o.Storage.ListShares(exprID, userID)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				writeError(w, http.StatusNotFound, "not_found", "Expression not found")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get shares")
+			return
+		}
+
+		response := make([]map[string]interface{}, len(shares))
+		for i, sh := range shares {
+			response[i] = shareResponse(sh)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"shares": response})
+		return
+	}
+
+	var req struct {
+		UserID int  `json:"user_id"`
+		Public bool `json:"public"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid_body", "Invalid Body")
+		return
+	}
+	if (req.UserID == 0) == !req.Public {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Either user_id or public is required")
+		return
+	}
+
+	var token, hash string
+	if req.Public {
+		token, hash = auth.NewShareToken()
+	} else {
+		if req.UserID == userID {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "Cannot share an expression with yourself")
+			return
+		}
+		if _, err := o.Storage.GetUserByID(req.UserID); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				writeError(w, http.StatusNotFound, "not_found", "User not found")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get user")
+			return
+		}
+	}
+
+	sh, err := o.Storage.CreateShare(exprID, userID, req.UserID, hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			writeError(w, http.StatusNotFound, "not_found", "Expression not found")
+		case errors.Is(err, storage.ErrAlreadyExists):
+			writeError(w, http.StatusConflict, "already_exists", "Expression is already shared with this user")
+		default:
+			log.Printf("Failed to share expression %d: %v", exprID, err)
+			writeError(w, http.StatusInternalServerError, "internal_error", "Failed to share expression")
+		}
+		return
+	}
+
+	response := shareResponse(sh)
+	if req.Public {
+		response["token"] = token
+		response["url"] = sharedExpressionPath + token
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// sharedWithMeHandler lists the expressions other users shared with the
+// caller, so a grantee does not need the IDs passed on out of band.
+func (o *Orchestrator) sharedWithMeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	exprs, err := o.Storage.ListSharedExpressions(userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get expressions")
+		return
+	}
+	response := make([]map[string]interface{}, len(exprs))
+	for i, expr := range exprs {
+		item := expressionResponse(expr)
+		item["owner_id"] = expr.UserID
+		response[i] = item
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"expressions": response})
+}
+
+func (o *Orchestrator) deleteShareHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+	exprID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid expression ID")
+		return
+	}
+	shareID, err := strconv.Atoi(r.PathValue("share_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid share ID")
+		return
+	}
+
+	if err := o.Storage.DeleteShare(shareID, exprID, userID); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "Share not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to revoke share")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sharedExpressionHandler serves a public link without authentication.
+func (o *Orchestrator) sharedExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	expr, err := o.Storage.GetSharedExpression(auth.HashToken(r.PathValue("token")))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "Shared expression not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get expression")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"expression": expressionResponse(expr)})
+}
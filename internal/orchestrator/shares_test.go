@@ -0,0 +1,133 @@
+package orchestrator
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestShareExpression(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	ownerID, _ := o.Storage.CreateUser("owner", "hash")
+	owner, _ := o.startSession(ownerID)
+	mateID, _ := o.Storage.CreateUser("teammate", "hash")
+	mate, _ := o.startSession(mateID)
+	strangerID, _ := o.Storage.CreateUser("stranger", "hash")
+	stranger, _ := o.startSession(strangerID)
+
+	_, resp := doJSON(t, h, http.MethodPost, "/api/v1/calculate", owner.Token, `{"expression":"2+2*2"}`)
+	exprID, _ := resp["id"].(string)
+	exprPath := "/api/v1/expressions/" + exprID
+	sharesPath := exprPath + "/shares"
+
+	if rec, _ := doJSON(t, h, http.MethodGet, exprPath, mate.Token, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 before sharing, got %d", rec.Code)
+	}
+
+	shareWithMate := fmt.Sprintf(`{"user_id":%d}`, mateID)
+	rec, resp := doJSON(t, h, http.MethodPost, sharesPath, owner.Token, shareWithMate)
+	if rec.Code != http.StatusCreated || resp["user_id"] != float64(mateID) || resp["public"] != false {
+		t.Fatalf("Expected 201 for a user share, got %d: %v", rec.Code, resp)
+	}
+	userShareID, _ := resp["id"].(string)
+
+	for _, tc := range []struct {
+		name, token, body string
+		want              int
+	}{
+		{"duplicate", owner.Token, shareWithMate, http.StatusConflict},
+		{"self", owner.Token, fmt.Sprintf(`{"user_id":%d}`, ownerID), http.StatusBadRequest},
+		{"unknown user", owner.Token, `{"user_id":999}`, http.StatusNotFound},
+		{"login", owner.Token, `{"login":"teammate"}`, http.StatusBadRequest},
+		{"both", owner.Token, fmt.Sprintf(`{"user_id":%d,"public":true}`, mateID), http.StatusBadRequest},
+		{"neither", owner.Token, `{}`, http.StatusBadRequest},
+		{"not the owner", mate.Token, fmt.Sprintf(`{"user_id":%d}`, strangerID), http.StatusNotFound},
+	} {
+		if rec, _ := doJSON(t, h, http.MethodPost, sharesPath, tc.token, tc.body); rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rec.Code)
+		}
+	}
+
+	rec, resp = doJSON(t, h, http.MethodGet, exprPath, mate.Token, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the teammate to read the expression, got %d: %v", rec.Code, resp)
+	}
+	rec, resp = doJSON(t, h, http.MethodGet, "/api/v1/me/shared", mate.Token, "")
+	shared, _ := resp["expressions"].([]interface{})
+	if rec.Code != http.StatusOK || len(shared) != 1 {
+		t.Fatalf("Expected the expression in the teammate's shared list, got %d: %v", rec.Code, resp)
+	}
+	if item := shared[0].(map[string]interface{}); item["id"] != exprID || item["owner_id"] != float64(ownerID) {
+		t.Errorf("Unexpected shared expression %v", item)
+	}
+	if _, resp := doJSON(t, h, http.MethodGet, "/api/v1/me/shared", stranger.Token, ""); len(resp["expressions"].([]interface{})) != 0 {
+		t.Errorf("Expected nothing shared with others, got %v", resp)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, exprPath, stranger.Token, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected others to still get 404, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodPost, exprPath+"/cancel", mate.Token, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a share to be read-only, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, sharesPath, mate.Token, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected only the owner to list shares, got %d", rec.Code)
+	}
+
+	rec, resp = doJSON(t, h, http.MethodPost, sharesPath, owner.Token, `{"public":true}`)
+	if rec.Code != http.StatusCreated || resp["public"] != true {
+		t.Fatalf("Expected 201 for a public link, got %d: %v", rec.Code, resp)
+	}
+	url, _ := resp["url"].(string)
+	linkID, _ := resp["id"].(string)
+	if !strings.HasPrefix(url, sharedExpressionPath) || len(resp["token"].(string)) < 40 {
+		t.Fatalf("Expected an unguessable link, got %v", resp)
+	}
+
+	rec, resp = doJSON(t, h, http.MethodGet, url, "", "")
+	expr, _ := resp["expression"].(map[string]interface{})
+	if rec.Code != http.StatusOK || expr["id"] != exprID || expr["expression"] != "2+2*2" {
+		t.Fatalf("Expected the link to work without credentials, got %d: %v", rec.Code, resp)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, sharedExpressionPath+"guess", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown link, got %d", rec.Code)
+	}
+
+	rec, resp = doJSON(t, h, http.MethodGet, sharesPath, owner.Token, "")
+	shares, _ := resp["shares"].([]interface{})
+	if rec.Code != http.StatusOK || len(shares) != 2 {
+		t.Fatalf("Expected 2 shares, got %d: %v", rec.Code, resp)
+	}
+	if _, leaked := shares[1].(map[string]interface{})["token"]; leaked {
+		t.Error("Expected the link token not to be listed")
+	}
+
+	if rec, _ := doJSON(t, h, http.MethodDelete, sharesPath+"/"+userShareID, mate.Token, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected only the owner to revoke, got %d", rec.Code)
+	}
+	for _, id := range []string{userShareID, linkID} {
+		if rec, _ := doJSON(t, h, http.MethodDelete, sharesPath+"/"+id, owner.Token, ""); rec.Code != http.StatusNoContent {
+			t.Errorf("Expected 204 on revoke, got %d", rec.Code)
+		}
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, exprPath, mate.Token, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the teammate to lose access, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodGet, url, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the revoked link to stop working, got %d", rec.Code)
+	}
+
+	// Shares go with either account.
+	doJSON(t, h, http.MethodPost, sharesPath, owner.Token, shareWithMate)
+	if rec, _ := doJSON(t, h, http.MethodDelete, "/api/v1/me", mate.Token, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the grantee to be able to delete their account, got %d", rec.Code)
+	}
+	if rec, _ := doJSON(t, h, http.MethodDelete, "/api/v1/me", owner.Token, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the owner to be able to delete their account, got %d", rec.Code)
+	}
+}
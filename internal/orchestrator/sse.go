@@ -0,0 +1,107 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"calc_service/internal/events"
+	"calc_service/internal/storage"
+)
+
+const sseHeartbeat = 15 * time.Second
+
+func isTerminalStatus(status string) bool {
+	return status != "pending"
+}
+
+func writeSSE(w http.ResponseWriter, f http.Flusher, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	f.Flush()
+	return nil
+}
+
+func (o *Orchestrator) expressionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid expression ID")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Streaming not supported")
+		return
+	}
+
+	// Subscribe before reading the current state so that a completion
+	// between the two steps is not lost.
+	ch, cancel := o.Storage.Events().Subscribe(id)
+	defer cancel()
+
+	dbExpr, err := o.Storage.GetExpressionByID(id, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "Expression not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get expression")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeSSE(w, flusher, "status", expressionResponse(dbExpr)); err != nil {
+		return
+	}
+	if isTerminalStatus(dbExpr.Status) {
+		writeSSE(w, flusher, events.ExpressionFinished, events.Event{
+			Type:         events.ExpressionFinished,
+			ExpressionID: id,
+			Status:       dbExpr.Status,
+			Result:       dbExpr.Result,
+			Time:         time.Now(),
+		})
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e := <-ch:
+			if err := writeSSE(w, flusher, e.Type, e); err != nil {
+				return
+			}
+			if e.Type == events.ExpressionFinished {
+				return
+			}
+		}
+	}
+}
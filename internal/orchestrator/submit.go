@@ -0,0 +1,121 @@
+package orchestrator
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"calc_service/internal/storage"
+)
+
+type submitRequest struct {
+	Expression  string     `json:"expression"`
+	Priority    int        `json:"priority"`
+	Deadline    *time.Time `json:"deadline"`
+	CallbackURL string     `json:"callback_url"`
+
+	IdempotencyKey string `json:"-"`
+}
+
+func (o *Orchestrator) submitExpression(userID int, req submitRequest) (*Expression, error) {
+	if req.IdempotencyKey != "" {
+		return o.submitIdempotent(userID, req)
+	}
+	return o.createExpression(userID, req)
+}
+
+// createExpression validates the request completely before anything is
+// stored, so a rejected expression leaves no row behind, and stores the
+// expression together with its tasks.
+func (o *Orchestrator) createExpression(userID int, req submitRequest) (*Expression, error) {
+	ast, err := o.validateSubmit(userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	expr := &Expression{
+		Expr:     req.Expression,
+		Status:   "pending",
+		Priority: req.Priority,
+		AST:      ast,
+	}
+	tasks := o.buildTasks(expr)
+
+	release, err := o.takeRateSlot(userID)
+	if err != nil {
+		return nil, err
+	}
+	dbExpr, err := o.Storage.CreatePendingExpression(userID, &storage.BatchItem{
+		Expression:  req.Expression,
+		Priority:    req.Priority,
+		Deadline:    req.Deadline,
+		CallbackURL: req.CallbackURL,
+		Tasks:       storageTasks(tasks),
+	}, o.Config.Quota.MaxPendingExpressions, req.IdempotencyKey)
+	if err != nil {
+		release()
+		return nil, quotaError(err)
+	}
+
+	expr.ID = strconv.Itoa(dbExpr.ID)
+	expr.Deadline = dbExpr.Deadline
+	for _, task := range tasks {
+		task.ExprID = expr.ID
+		o.queueTask(task)
+	}
+	return expr, nil
+}
+
+// validateSubmit checks a request the same way for /calculate and for every
+// batch item and returns the parsed expression. Rejections are *apiError;
+// any other error is a failure to check.
+func (o *Orchestrator) validateSubmit(userID int, req submitRequest) (*ASTNode, error) {
+	if req.Deadline != nil && !req.Deadline.After(time.Now()) {
+		return nil, &apiError{Status: http.StatusBadRequest, Code: "invalid_argument", Message: "Deadline must be in the future"}
+	}
+
+	if req.CallbackURL != "" && !validCallbackURL(req.CallbackURL) {
+		return nil, &apiError{Status: http.StatusBadRequest, Code: "invalid_argument", Message: "Invalid callback_url"}
+	}
+	if req.CallbackURL != "" {
+		if err := o.checkCallbackSecret(userID); err != nil {
+			return nil, err
+		}
+	}
+
+	ast, err := o.Config.Quota.parseExpression(req.Expression)
+	if err != nil {
+		return nil, expressionError(err)
+	}
+	return ast, nil
+}
+
+// storageTasks converts the tasks built from an AST for storing.
+func storageTasks(tasks []*Task) []*storage.Task {
+	dbTasks := make([]*storage.Task, len(tasks))
+	for i, task := range tasks {
+		dbTasks[i] = &storage.Task{
+			ID:            task.ID,
+			Arg1:          task.Arg1,
+			Arg2:          task.Arg2,
+			Operation:     task.Operation,
+			OperationTime: task.OperationTime,
+		}
+	}
+	return dbTasks
+}
+
+func expressionError(err error) *apiError {
+	var aerr *apiError
+	if errors.As(err, &aerr) {
+		return aerr
+	}
+
+	aerr = &apiError{Status: http.StatusUnprocessableEntity, Code: "invalid_expression", Message: err.Error()}
+	var perr *ParseError
+	if errors.As(err, &perr) {
+		aerr.Details = map[string]interface{}{"position": perr.Position}
+	}
+	return aerr
+}
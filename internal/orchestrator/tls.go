@@ -0,0 +1,98 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+
+	"calc_service/internal/proto"
+)
+
+// TLSConfig locates the certificate the gRPC server presents. ClientCAFile
+// enables mutual TLS: an agent presenting a certificate signed by one of
+// these CAs is identified by the certificate's common name and needs no
+// token.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+func tlsConfiguration() TLSConfig {
+	return TLSConfig{
+		CertFile:     os.Getenv("GRPC_TLS_CERT_FILE"),
+		KeyFile:      os.Getenv("GRPC_TLS_KEY_FILE"),
+		ClientCAFile: os.Getenv("GRPC_TLS_CLIENT_CA_FILE"),
+	}
+}
+
+func (c TLSConfig) serverCredentials() (credentials.TransportCredentials, error) {
+	if c.CertFile == "" && c.KeyFile == "" {
+		if c.ClientCAFile != "" {
+			return nil, fmt.Errorf("GRPC_TLS_CLIENT_CA_FILE requires GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE")
+		}
+		return insecure.NewCredentials(), nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load gRPC certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", c.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		// Users of the expression service connect without certificates,
+		// so a certificate is verified only when one is presented.
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return credentials.NewTLS(cfg), nil
+}
+
+// certAgentID returns the common name of a verified client certificate, or
+// "" when the peer did not present one.
+func certAgentID(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return ""
+	}
+	return info.State.VerifiedChains[0][0].Subject.CommonName
+}
+
+func (o *Orchestrator) newGRPCServer() (*grpc.Server, error) {
+	creds, err := o.Config.TLS.serverCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	srv := grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.ChainUnaryInterceptor(o.authUnaryInterceptor),
+		grpc.ChainStreamInterceptor(o.authStreamInterceptor),
+	)
+	proto.RegisterCalculatorServer(srv, &server{o: o})
+	proto.RegisterExpressionServiceServer(srv, &expressionServer{o: o})
+	return srv, nil
+}
@@ -0,0 +1,119 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"calc_service/internal/agent"
+	"calc_service/internal/certtest"
+	"calc_service/internal/proto"
+	"calc_service/internal/storage"
+)
+
+func startGRPCServer(t *testing.T, o *Orchestrator) string {
+	t.Helper()
+	srv, err := o.newGRPCServer()
+	if err != nil {
+		t.Fatalf("newGRPCServer failed: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestAgentTLS(t *testing.T) {
+	certs := certtest.Generate(t, "agent-cert")
+	o := newAgentTestOrchestrator(t)
+	o.Config.TLS = TLSConfig{CertFile: certs.ServerCert, KeyFile: certs.ServerKey, ClientCAFile: certs.CA}
+	addr := startGRPCServer(t, o)
+
+	userID, _ := o.Storage.CreateUser("tlsuser", "hash")
+	expr, _ := o.Storage.CreateExpression(userID, "2+2")
+	o.Storage.CreateTask(&storage.Task{ID: "1", ExprID: expr.ID, Arg1: 2, Arg2: 2, Operation: "+", OperationTime: 100})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	plain := dialCalculator(t, addr, insecure.NewCredentials())
+	if _, err := plain.GetTask(agentContext("agent-a", "shared-agent-token"), &proto.TaskRequest{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected a plaintext agent to be refused, got %v", err)
+	}
+
+	other := certtest.Generate(t, "agent-cert")
+	pool := x509.NewCertPool()
+	pem, _ := os.ReadFile(other.CA)
+	pool.AppendCertsFromPEM(pem)
+	untrusted := dialCalculator(t, addr, credentials.NewTLS(&tls.Config{RootCAs: pool}))
+	if _, err := untrusted.GetTask(agentContext("agent-a", "shared-agent-token"), &proto.TaskRequest{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected an agent trusting another CA to be refused, got %v", err)
+	}
+
+	tokenAgent, err := agent.New(agent.Config{ID: "agent-a", Token: "shared-agent-token", OrchestratorURL: addr, CAFile: certs.CA})
+	if err != nil {
+		t.Fatalf("Failed to connect over TLS: %v", err)
+	}
+	defer tokenAgent.Conn.Close()
+	if _, err := tokenAgent.Client.GetTask(ctx, &proto.TaskRequest{}); err != nil {
+		t.Fatalf("Expected the token to be accepted over TLS, got %v", err)
+	}
+	o.Storage.RequeueTask("1")
+
+	// The client certificate identifies the agent; no token is sent and the
+	// claimed ID is ignored.
+	certAgent, err := agent.New(agent.Config{
+		ID:              "spoofed",
+		OrchestratorURL: addr,
+		CAFile:          certs.CA,
+		CertFile:        certs.ClientCert,
+		KeyFile:         certs.ClientKey,
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect with a client certificate: %v", err)
+	}
+	defer certAgent.Conn.Close()
+
+	task, err := certAgent.Client.GetTask(ctx, &proto.TaskRequest{})
+	if err != nil {
+		t.Fatalf("GetTask failed: %v", err)
+	}
+	leased, _ := o.Storage.GetTaskByID(task.Id)
+	if leased.AgentID.String != "agent-cert" {
+		t.Errorf("Expected the lease to use the certificate name, got %q", leased.AgentID.String)
+	}
+	if _, err := certAgent.Client.SubmitResult(ctx, &proto.ResultRequest{Id: task.Id, Result: 4}); err != nil {
+		t.Fatalf("SubmitResult failed: %v", err)
+	}
+}
+
+func dialCalculator(t *testing.T, addr string, creds credentials.TransportCredentials) proto.CalculatorClient {
+	t.Helper()
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewCalculatorClient(conn)
+}
+
+func TestTLSConfigRequiresCertificate(t *testing.T) {
+	if _, err := (TLSConfig{ClientCAFile: "ca.pem"}).serverCredentials(); err == nil {
+		t.Error("Expected a client CA without a server certificate to be rejected")
+	}
+	if _, err := (TLSConfig{CertFile: "missing.pem", KeyFile: "missing-key.pem"}).serverCredentials(); err == nil {
+		t.Error("Expected missing certificate files to be reported")
+	}
+}
@@ -0,0 +1,92 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"calc_service/internal/auth"
+)
+
+// keyFileEntry is a key in JWT_KEYS_FILE. PEM keys may be given inline or
+// by path.
+type keyFileEntry struct {
+	auth.Key
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
+}
+
+// authConfiguration reads the token settings. Signing keys come from
+// JWT_KEYS_FILE (several keys, for rotation) or a single key described by
+// JWT_ALGORITHM and JWT_SECRET/JWT_SECRET_FILE (HS256) or
+// JWT_PRIVATE_KEY_FILE (RS256, EdDSA).
+func authConfiguration() auth.Config {
+	cfg := auth.Config{
+		Issuer:   envString("JWT_ISSUER", "calc_service"),
+		Audience: envString("JWT_AUDIENCE", "calc_service"),
+		TTL:      time.Duration(envInt("JWT_TTL_MINUTES", 15)) * time.Minute,
+		Leeway:   time.Duration(envInt("JWT_LEEWAY_SECONDS", 30)) * time.Second,
+	}
+
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		var file struct {
+			Active string         `json:"active"`
+			Keys   []keyFileEntry `json:"keys"`
+		}
+		if err := json.Unmarshal(readFile("JWT_KEYS_FILE", path), &file); err != nil {
+			log.Fatalf("failed to parse JWT_KEYS_FILE: %v", err)
+		}
+		for _, entry := range file.Keys {
+			if entry.PrivateKeyFile != "" {
+				entry.PrivateKey = string(readFile("private_key_file", entry.PrivateKeyFile))
+			}
+			if entry.PublicKeyFile != "" {
+				entry.PublicKey = string(readFile("public_key_file", entry.PublicKeyFile))
+			}
+			cfg.Keys = append(cfg.Keys, entry.Key)
+		}
+		cfg.ActiveKey = file.Active
+		return cfg
+	}
+
+	key := auth.Key{
+		ID:        envString("JWT_KEY_ID", "default"),
+		Algorithm: envString("JWT_ALGORITHM", auth.AlgHS256),
+		Secret:    os.Getenv("JWT_SECRET"),
+	}
+	if path := os.Getenv("JWT_SECRET_FILE"); path != "" {
+		key.Secret = strings.TrimSpace(string(readFile("JWT_SECRET_FILE", path)))
+	}
+	if path := os.Getenv("JWT_PRIVATE_KEY_FILE"); path != "" {
+		key.PrivateKey = string(readFile("JWT_PRIVATE_KEY_FILE", path))
+	}
+
+	if key.Secret == "" && key.PrivateKey == "" {
+		log.Printf("No %s signing key is configured, tokens are signed with a random per-process key", key.Algorithm)
+		generated, err := auth.GenerateKey(key.ID, key.Algorithm)
+		if err != nil {
+			log.Fatalf("failed to generate signing key: %v", err)
+		}
+		key = generated
+	}
+
+	cfg.Keys = []auth.Key{key}
+	return cfg
+}
+
+func readFile(name, path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("failed to read %s: %v", name, err)
+	}
+	return data
+}
+
+func (o *Orchestrator) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(o.Tokens.JWKS())
+}
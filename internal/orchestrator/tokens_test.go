@@ -0,0 +1,57 @@
+package orchestrator
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"calc_service/internal/auth"
+)
+
+func TestJWKSEndpoint(t *testing.T) {
+	o := newTestOrchestrator(t)
+	key, err := auth.GenerateKey("ed-1", auth.AlgEdDSA)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	o.Config.Auth.Keys = []auth.Key{key}
+	o.Config.Auth.ActiveKey = key.ID
+	if o.Tokens, err = auth.NewTokens(o.Config.Auth); err != nil {
+		t.Fatalf("NewTokens failed: %v", err)
+	}
+
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var set auth.JWKSet
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatalf("Invalid JWKS: %v", err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].KeyID != "ed-1" || set.Keys[0].Curve != "Ed25519" {
+		t.Fatalf("Unexpected JWKS: %s", rec.Body.String())
+	}
+	x, err := base64.RawURLEncoding.DecodeString(set.Keys[0].X)
+	if err != nil {
+		t.Fatalf("Invalid x: %v", err)
+	}
+
+	token, _ := o.Tokens.Generate(9, auth.RoleUser, 0)
+	_, err = jwt.Parse(token, func(tok *jwt.Token) (interface{}, error) {
+		return ed25519.PublicKey(x), nil
+	}, jwt.WithValidMethods([]string{auth.AlgEdDSA}))
+	if err != nil {
+		t.Errorf("Token did not verify against the published key: %v", err)
+	}
+}
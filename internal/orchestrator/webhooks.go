@@ -0,0 +1,244 @@
+package orchestrator
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"calc_service/internal/storage"
+	"calc_service/internal/webhooks"
+)
+
+func webhookConfiguration() webhooks.Config {
+	// Deliveries to internal addresses are refused unless their network is
+	// listed, e.g. WEBHOOK_ALLOWED_NETWORKS=10.0.5.0/24 for an in-cluster
+	// receiver.
+	var allowed []netip.Prefix
+	for _, cidr := range strings.FieldsFunc(os.Getenv("WEBHOOK_ALLOWED_NETWORKS"), func(r rune) bool { return r == ',' || r == ' ' }) {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			log.Fatalf("invalid WEBHOOK_ALLOWED_NETWORKS entry %q: %v", cidr, err)
+		}
+		allowed = append(allowed, prefix)
+	}
+
+	return webhooks.Config{
+		MaxAttempts:     envInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		BaseBackoff:     time.Duration(envInt("WEBHOOK_BACKOFF_MS", 1000)) * time.Millisecond,
+		MaxBackoff:      time.Duration(envInt("WEBHOOK_MAX_BACKOFF_MS", 300000)) * time.Millisecond,
+		Timeout:         time.Duration(envInt("WEBHOOK_TIMEOUT_MS", 5000)) * time.Millisecond,
+		Workers:         envInt("WEBHOOK_WORKERS", 8),
+		AllowedNetworks: allowed,
+	}
+}
+
+func randomSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("failed to generate secret: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// errCallbackDisabled is returned for callback_url when the user has no
+// callback secret the deliveries could be verified with.
+var errCallbackDisabled = &apiError{
+	Status:  http.StatusBadRequest,
+	Code:    "callback_disabled",
+	Message: "callback_url requires a callback secret; create one with POST /api/v1/webhooks/callback-secret",
+}
+
+// checkCallbackSecret returns errCallbackDisabled if the user has not
+// created a callback secret yet.
+func (o *Orchestrator) checkCallbackSecret(userID int) error {
+	secret, err := o.Storage.GetCallbackSecret(userID)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return errCallbackDisabled
+	}
+	return nil
+}
+
+func validCallbackURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (o *Orchestrator) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := o.Storage.GetWebhooks(userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get webhooks")
+			return
+		}
+
+		response := make([]map[string]interface{}, len(hooks))
+		for i, hook := range hooks {
+			response[i] = map[string]interface{}{
+				"id":         strconv.Itoa(hook.ID),
+				"url":        hook.URL,
+				"created_at": hook.CreatedAt.UTC().Format(time.RFC3339),
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"webhooks": response})
+
+	case http.MethodPost:
+		var req struct {
+			URL string `json:"url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "invalid_body", "Invalid Body")
+			return
+		}
+		if !validCallbackURL(req.URL) {
+			writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid webhook URL")
+			return
+		}
+
+		hook, err := o.Storage.CreateWebhook(userID, req.URL, randomSecret())
+		if err != nil {
+			log.Printf("Failed to create webhook: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal_error", "Failed to create webhook")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":     strconv.Itoa(hook.ID),
+			"url":    hook.URL,
+			"secret": hook.Secret,
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	}
+}
+
+func (o *Orchestrator) webhookIDHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+	switch idStr {
+	case "deliveries":
+		o.webhookDeliveriesHandler(w, r, userID)
+		return
+	case "callback-secret":
+		o.callbackSecretHandler(w, r, userID)
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_argument", "Invalid webhook ID")
+		return
+	}
+
+	if err := o.Storage.DeleteWebhook(id, userID); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "Webhook not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to delete webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// callbackSecretHandler creates the secret the user's callback_url
+// deliveries are signed with, replacing the previous one. Like a webhook
+// secret it is only returned here.
+func (o *Orchestrator) callbackSecretHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	secret := randomSecret()
+	if err := o.Storage.SetCallbackSecret(userID, secret); err != nil {
+		log.Printf("Failed to set callback secret: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to create callback secret")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"secret": secret})
+}
+
+func (o *Orchestrator) webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	deliveries, err := o.Storage.GetWebhookDeliveries(userID, 100)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "Failed to get deliveries")
+		return
+	}
+
+	response := make([]map[string]interface{}, len(deliveries))
+	for i, d := range deliveries {
+		item := map[string]interface{}{
+			"id":            strconv.Itoa(d.ID),
+			"expression_id": strconv.Itoa(d.ExpressionID),
+			"url":           d.URL,
+			"status":        d.Status,
+			"attempts":      d.Attempts,
+			"created_at":    d.CreatedAt.UTC().Format(time.RFC3339),
+		}
+		if d.WebhookID != nil {
+			item["webhook_id"] = strconv.Itoa(*d.WebhookID)
+		}
+		if d.LastStatusCode != nil {
+			item["last_status_code"] = *d.LastStatusCode
+		}
+		if d.LastError != "" {
+			item["last_error"] = d.LastError
+		}
+		if d.Status == "pending" {
+			item["next_attempt_at"] = d.NextAttemptAt.UTC().Format(time.RFC3339)
+		}
+		if d.DeliveredAt != nil {
+			item["delivered_at"] = d.DeliveredAt.UTC().Format(time.RFC3339)
+		}
+		response[i] = item
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"deliveries": response})
+}
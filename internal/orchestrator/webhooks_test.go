@@ -0,0 +1,50 @@
+package orchestrator
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCallbackURLRequiresSecret(t *testing.T) {
+	o := newTestOrchestrator(t)
+	h, err := o.Handler()
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	userID, _ := o.Storage.CreateUser("hookuser", "hash")
+	session, _ := o.startSession(userID)
+
+	body := `{"expression":"2+2","callback_url":"https://example.com/hook"}`
+	if rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/calculate", session.Token, body); rec.Code != http.StatusBadRequest || resp["code"] != "callback_disabled" {
+		t.Errorf("Expected 400 callback_disabled without a callback secret, got %d: %v", rec.Code, resp)
+	}
+	rec, resp := doJSON(t, h, http.MethodPost, "/api/v1/calculate/batch", session.Token, "["+body+"]")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected the batch item to be refused, got %d: %v", rec.Code, resp)
+	}
+
+	rec, resp = doJSON(t, h, http.MethodPost, "/api/v1/webhooks/callback-secret", session.Token, "")
+	first, _ := resp["secret"].(string)
+	if rec.Code != http.StatusCreated || first == "" {
+		t.Fatalf("Expected a callback secret, got %d: %v", rec.Code, resp)
+	}
+	if stored, _ := o.Storage.GetCallbackSecret(userID); stored != first {
+		t.Errorf("Expected the returned secret to be stored, got %q", stored)
+	}
+	if rec, _ := doJSON(t, h, http.MethodPost, "/api/v1/calculate", session.Token, body); rec.Code != http.StatusCreated {
+		t.Errorf("Expected 201 with a callback secret, got %d", rec.Code)
+	}
+
+	// Each user signs with their own secret, and asking again rotates it.
+	otherID, _ := o.Storage.CreateUser("otheruser", "hash")
+	other, _ := o.startSession(otherID)
+	_, resp = doJSON(t, h, http.MethodPost, "/api/v1/webhooks/callback-secret", other.Token, "")
+	if resp["secret"] == first {
+		t.Error("Expected users to get different callback secrets")
+	}
+	_, resp = doJSON(t, h, http.MethodPost, "/api/v1/webhooks/callback-secret", session.Token, "")
+	if resp["secret"] == first {
+		t.Error("Expected a new callback secret to replace the old one")
+	}
+}
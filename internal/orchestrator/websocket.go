@@ -0,0 +1,217 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"calc_service/internal/storage"
+)
+
+const wsAuthTimeout = 10 * time.Second
+
+type wsMessage struct {
+	Type       string     `json:"type"`
+	RequestID  string     `json:"request_id,omitempty"`
+	Token      string     `json:"token,omitempty"`
+	ID         string     `json:"id,omitempty"`
+	Expression string     `json:"expression,omitempty"`
+	Priority   int        `json:"priority,omitempty"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+}
+
+type wsSession struct {
+	conn   *websocket.Conn
+	sendMu sync.Mutex
+}
+
+func (s *wsSession) send(v interface{}) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	return websocket.JSON.Send(s.conn, v)
+}
+
+func (s *wsSession) sendError(requestID, message string) error {
+	return s.send(map[string]string{
+		"type":       "error",
+		"request_id": requestID,
+		"error":      message,
+	})
+}
+
+func (o *Orchestrator) websocketHandler() http.Handler {
+	return websocket.Server{
+		Handshake: o.checkWebsocketOrigin,
+		Handler:   o.serveWebsocket,
+	}
+}
+
+// checkWebsocketOrigin accepts browser connections only from the service's
+// own origin and from WEBSOCKET_ALLOWED_ORIGINS. Clients that send no Origin
+// are not browsers and are let through.
+func (o *Orchestrator) checkWebsocketOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || strings.EqualFold(origin.Host, r.Host) {
+		return nil
+	}
+	for _, allowed := range o.Config.WebsocketOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin.Scheme+"://"+origin.Host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+func (o *Orchestrator) serveWebsocket(conn *websocket.Conn) {
+	defer conn.Close()
+	session := &wsSession{conn: conn}
+
+	token, err := o.authenticateWebsocket(conn)
+	if err != nil {
+		session.sendError("", err.Error())
+		return
+	}
+	p, err := o.authenticate(token)
+	if err != nil {
+		session.sendError("", "Invalid token")
+		return
+	}
+	userID := p.UserID
+	session.send(map[string]interface{}{"type": "authenticated", "user_id": userID})
+
+	// The connection lives no longer than the token it was opened with.
+	if claims, err := o.Tokens.Parse(token); err == nil && claims.ExpiresAt != nil {
+		expiry := time.AfterFunc(time.Until(claims.ExpiresAt.Time), func() {
+			session.sendError("", "Token expired")
+			conn.Close()
+		})
+		defer expiry.Stop()
+	}
+
+	events, cancel := o.Storage.Events().SubscribeUser(userID)
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case e := <-events:
+				if err := session.send(map[string]interface{}{"type": "event", "event": e}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var msg wsMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return
+		}
+
+		// Logout, revocation and disabling the account end the session
+		// between messages, not only when the socket is opened.
+		if _, err := o.authenticate(token); err != nil {
+			session.sendError(msg.RequestID, "Session is no longer valid")
+			return
+		}
+
+		switch msg.Type {
+		case "submit":
+			expr, err := o.submitExpression(userID, submitRequest{
+				Expression: msg.Expression,
+				Priority:   msg.Priority,
+				Deadline:   msg.Deadline,
+			})
+			if err != nil {
+				var aerr *apiError
+				if !errors.As(err, &aerr) {
+					session.sendError(msg.RequestID, "Failed to create expression")
+					continue
+				}
+				session.sendError(msg.RequestID, aerr.Message)
+				continue
+			}
+			session.send(map[string]string{
+				"type":       "submitted",
+				"request_id": msg.RequestID,
+				"id":         expr.ID,
+			})
+
+		case "cancel":
+			id, err := strconv.Atoi(msg.ID)
+			if err != nil {
+				session.sendError(msg.RequestID, "Invalid expression ID")
+				continue
+			}
+			if err := o.Storage.CancelExpression(id, userID); err != nil {
+				switch {
+				case errors.Is(err, storage.ErrNotFound):
+					session.sendError(msg.RequestID, "Expression not found")
+				case errors.Is(err, storage.ErrNotPending):
+					session.sendError(msg.RequestID, "Expression is not pending")
+				default:
+					session.sendError(msg.RequestID, "Failed to cancel expression")
+				}
+				continue
+			}
+			session.send(map[string]string{
+				"type":       "cancelled",
+				"request_id": msg.RequestID,
+				"id":         msg.ID,
+			})
+
+		case "list":
+			exprs, err := o.Storage.GetExpressions(userID)
+			if err != nil {
+				session.sendError(msg.RequestID, "Failed to get expressions")
+				continue
+			}
+			response := make([]map[string]interface{}, len(exprs))
+			for i, expr := range exprs {
+				response[i] = expressionResponse(expr)
+			}
+			session.send(map[string]interface{}{
+				"type":        "expressions",
+				"request_id":  msg.RequestID,
+				"expressions": response,
+			})
+
+		default:
+			session.sendError(msg.RequestID, "Unknown message type")
+		}
+	}
+}
+
+// authenticateWebsocket returns the access token the client presented,
+// either in the Authorization header or in a first "auth" message.
+func (o *Orchestrator) authenticateWebsocket(conn *websocket.Conn) (string, error) {
+	if header := conn.Request().Header.Get("Authorization"); header != "" {
+		return strings.TrimPrefix(header, "Bearer "), nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var msg wsMessage
+	if err := websocket.JSON.Receive(conn, &msg); err != nil {
+		return "", errors.New("Authentication required")
+	}
+	if msg.Type != "auth" || msg.Token == "" {
+		return "", errors.New("Authentication required")
+	}
+	return msg.Token, nil
+}
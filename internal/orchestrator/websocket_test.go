@@ -0,0 +1,189 @@
+package orchestrator
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/websocket"
+
+	"calc_service/internal/auth"
+	"calc_service/internal/storage"
+)
+
+func newTestOrchestrator(t *testing.T) *Orchestrator {
+	stor, err := storage.NewStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { stor.GetDB().Close() })
+
+	config := Configuration()
+	config.Hasher.BcryptCost = bcrypt.MinCost
+	tokens, err := auth.NewTokens(config.Auth)
+	if err != nil {
+		t.Fatalf("Failed to create tokens: %v", err)
+	}
+
+	return &Orchestrator{
+		Config:    config,
+		Storage:   stor,
+		Tokens:    tokens,
+		exprStore: make(map[string]*Expression),
+		taskStore: make(map[string]*Task),
+		taskQueue: make([]*Task, 0),
+		limiter:   newRateLimiter(),
+		logins:    newLoginThrottle(),
+	}
+}
+
+func receive(t *testing.T, conn *websocket.Conn, wantType string) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg map[string]interface{}
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			t.Fatalf("Failed to receive %s message: %v", wantType, err)
+		}
+		if msg["type"] == wantType {
+			return msg
+		}
+		if msg["type"] == "error" {
+			t.Fatalf("Expected %s message, got error: %v", wantType, msg["error"])
+		}
+	}
+}
+
+func TestWebsocketSession(t *testing.T) {
+	o := newTestOrchestrator(t)
+	srv := httptest.NewServer(o.websocketHandler())
+	defer srv.Close()
+
+	userID, _ := o.Storage.CreateUser("wsuser", "hash")
+	token := mustToken(t, o, userID)
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, err := websocket.Dial(wsURL, "", srv.URL)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	websocket.JSON.Send(conn, wsMessage{Type: "auth", Token: token})
+	receive(t, conn, "authenticated")
+
+	websocket.JSON.Send(conn, wsMessage{Type: "submit", RequestID: "r1", Expression: "2+2"})
+	submitted := receive(t, conn, "submitted")
+	if submitted["request_id"] != "r1" || submitted["id"] == "" {
+		t.Fatalf("Unexpected submit response: %v", submitted)
+	}
+
+	websocket.JSON.Send(conn, wsMessage{Type: "submit", RequestID: "r2", Expression: "2+"})
+	var errMsg map[string]interface{}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := websocket.JSON.Receive(conn, &errMsg); err != nil || errMsg["type"] != "error" {
+		t.Fatalf("Expected error for invalid expression, got %v (err %v)", errMsg, err)
+	}
+
+	websocket.JSON.Send(conn, wsMessage{Type: "cancel", RequestID: "r3", ID: submitted["id"].(string)})
+
+	var gotCancelled, gotEvent bool
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for !gotCancelled || !gotEvent {
+		var msg map[string]interface{}
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			t.Fatalf("Failed to receive cancellation: %v", err)
+		}
+		switch msg["type"] {
+		case "cancelled":
+			gotCancelled = true
+		case "event":
+			event := msg["event"].(map[string]interface{})
+			if event["type"] == "expression_finished" {
+				if event["status"] != "cancelled" {
+					t.Errorf("Expected cancelled status, got %v", event)
+				}
+				gotEvent = true
+			}
+		}
+	}
+}
+
+func TestWebsocketRejectsInvalidToken(t *testing.T) {
+	o := newTestOrchestrator(t)
+	srv := httptest.NewServer(o.websocketHandler())
+	defer srv.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	websocket.JSON.Send(conn, wsMessage{Type: "auth", Token: "invalid"})
+
+	var msg map[string]interface{}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := websocket.JSON.Receive(conn, &msg); err != nil || msg["type"] != "error" {
+		t.Fatalf("Expected auth error, got %v (err %v)", msg, err)
+	}
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	o := newTestOrchestrator(t)
+	srv := httptest.NewServer(o.websocketHandler())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if _, err := websocket.Dial(wsURL, "", "https://evil.example"); err == nil {
+		t.Fatal("A foreign origin must be refused")
+	}
+
+	o.Config.WebsocketOrigins = []string{"https://app.example"}
+	conn, err := websocket.Dial(wsURL, "", "https://app.example")
+	if err != nil {
+		t.Fatalf("An allowed origin must be accepted: %v", err)
+	}
+	conn.Close()
+}
+
+func TestWebsocketSessionRevoked(t *testing.T) {
+	o := newTestOrchestrator(t)
+	srv := httptest.NewServer(o.websocketHandler())
+	defer srv.Close()
+
+	userID, _ := o.Storage.CreateUser("wsrevoked", "hash")
+	pair, err := o.startSession(userID)
+	if err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	websocket.JSON.Send(conn, wsMessage{Type: "auth", Token: pair.Token})
+	receive(t, conn, "authenticated")
+
+	if err := o.Storage.RevokeSessionByRefreshToken(auth.HashToken(pair.RefreshToken)); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+
+	websocket.JSON.Send(conn, wsMessage{Type: "submit", RequestID: "r1", Expression: "2+2"})
+	var msg map[string]interface{}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := websocket.JSON.Receive(conn, &msg); err != nil || msg["type"] != "error" {
+		t.Fatalf("Expected an error after logout, got %v (err %v)", msg, err)
+	}
+	if err := websocket.JSON.Receive(conn, &msg); err == nil {
+		t.Errorf("Expected the connection to be closed, got %v", msg)
+	}
+	if exprs, _ := o.Storage.GetExpressions(userID); len(exprs) != 0 {
+		t.Errorf("A revoked session must not submit, got %d expressions", len(exprs))
+	}
+}
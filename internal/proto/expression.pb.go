@@ -0,0 +1,780 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v3.19.4
+// source: internal/proto/expression.proto
+
+package proto
+
+import (
+	_ "google.golang.org/genproto/googleapis/api/annotations"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Expression struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Expression    string                 `protobuf:"bytes,2,opt,name=expression,proto3" json:"expression,omitempty"`
+	Status        string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Result        *float64               `protobuf:"fixed64,4,opt,name=result,proto3,oneof" json:"result,omitempty"`
+	Priority      int32                  `protobuf:"varint,5,opt,name=priority,proto3" json:"priority,omitempty"`
+	Deadline      *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=deadline,proto3" json:"deadline,omitempty"`
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Expression) Reset() {
+	*x = Expression{}
+	mi := &file_internal_proto_expression_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Expression) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Expression) ProtoMessage() {}
+
+func (x *Expression) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_expression_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Expression.ProtoReflect.Descriptor instead.
+func (*Expression) Descriptor() ([]byte, []int) {
+	return file_internal_proto_expression_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Expression) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Expression) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
+func (x *Expression) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Expression) GetResult() float64 {
+	if x != nil && x.Result != nil {
+		return *x.Result
+	}
+	return 0
+}
+
+func (x *Expression) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *Expression) GetDeadline() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Deadline
+	}
+	return nil
+}
+
+func (x *Expression) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+type SubmitRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Expression    string                 `protobuf:"bytes,1,opt,name=expression,proto3" json:"expression,omitempty"`
+	Priority      int32                  `protobuf:"varint,2,opt,name=priority,proto3" json:"priority,omitempty"`
+	Deadline      *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=deadline,proto3" json:"deadline,omitempty"`
+	CallbackUrl   string                 `protobuf:"bytes,4,opt,name=callback_url,json=callbackUrl,proto3" json:"callback_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitRequest) Reset() {
+	*x = SubmitRequest{}
+	mi := &file_internal_proto_expression_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitRequest) ProtoMessage() {}
+
+func (x *SubmitRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_expression_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitRequest.ProtoReflect.Descriptor instead.
+func (*SubmitRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_expression_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SubmitRequest) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
+func (x *SubmitRequest) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *SubmitRequest) GetDeadline() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Deadline
+	}
+	return nil
+}
+
+func (x *SubmitRequest) GetCallbackUrl() string {
+	if x != nil {
+		return x.CallbackUrl
+	}
+	return ""
+}
+
+type SubmitResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitResponse) Reset() {
+	*x = SubmitResponse{}
+	mi := &file_internal_proto_expression_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitResponse) ProtoMessage() {}
+
+func (x *SubmitResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_expression_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitResponse.ProtoReflect.Descriptor instead.
+func (*SubmitResponse) Descriptor() ([]byte, []int) {
+	return file_internal_proto_expression_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SubmitResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type GetExpressionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Wait          string                 `protobuf:"bytes,2,opt,name=wait,proto3" json:"wait,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetExpressionRequest) Reset() {
+	*x = GetExpressionRequest{}
+	mi := &file_internal_proto_expression_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetExpressionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetExpressionRequest) ProtoMessage() {}
+
+func (x *GetExpressionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_expression_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetExpressionRequest.ProtoReflect.Descriptor instead.
+func (*GetExpressionRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_expression_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetExpressionRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *GetExpressionRequest) GetWait() string {
+	if x != nil {
+		return x.Wait
+	}
+	return ""
+}
+
+type ListExpressionsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Limit         int32                  `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	Cursor        string                 `protobuf:"bytes,2,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	Status        []string               `protobuf:"bytes,3,rep,name=status,proto3" json:"status,omitempty"`
+	CreatedAfter  *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=created_after,json=createdAfter,proto3" json:"created_after,omitempty"`
+	CreatedBefore *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created_before,json=createdBefore,proto3" json:"created_before,omitempty"`
+	Search        string                 `protobuf:"bytes,6,opt,name=search,proto3" json:"search,omitempty"`
+	Sort          string                 `protobuf:"bytes,7,opt,name=sort,proto3" json:"sort,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListExpressionsRequest) Reset() {
+	*x = ListExpressionsRequest{}
+	mi := &file_internal_proto_expression_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListExpressionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListExpressionsRequest) ProtoMessage() {}
+
+func (x *ListExpressionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_expression_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListExpressionsRequest.ProtoReflect.Descriptor instead.
+func (*ListExpressionsRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_expression_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListExpressionsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListExpressionsRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+func (x *ListExpressionsRequest) GetStatus() []string {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+func (x *ListExpressionsRequest) GetCreatedAfter() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAfter
+	}
+	return nil
+}
+
+func (x *ListExpressionsRequest) GetCreatedBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedBefore
+	}
+	return nil
+}
+
+func (x *ListExpressionsRequest) GetSearch() string {
+	if x != nil {
+		return x.Search
+	}
+	return ""
+}
+
+func (x *ListExpressionsRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+type ListExpressionsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Expressions   []*Expression          `protobuf:"bytes,1,rep,name=expressions,proto3" json:"expressions,omitempty"`
+	NextCursor    string                 `protobuf:"bytes,2,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListExpressionsResponse) Reset() {
+	*x = ListExpressionsResponse{}
+	mi := &file_internal_proto_expression_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListExpressionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListExpressionsResponse) ProtoMessage() {}
+
+func (x *ListExpressionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_expression_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListExpressionsResponse.ProtoReflect.Descriptor instead.
+func (*ListExpressionsResponse) Descriptor() ([]byte, []int) {
+	return file_internal_proto_expression_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListExpressionsResponse) GetExpressions() []*Expression {
+	if x != nil {
+		return x.Expressions
+	}
+	return nil
+}
+
+func (x *ListExpressionsResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+type CancelExpressionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelExpressionRequest) Reset() {
+	*x = CancelExpressionRequest{}
+	mi := &file_internal_proto_expression_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelExpressionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelExpressionRequest) ProtoMessage() {}
+
+func (x *CancelExpressionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_expression_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelExpressionRequest.ProtoReflect.Descriptor instead.
+func (*CancelExpressionRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_expression_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CancelExpressionRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type CancelExpressionResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelExpressionResponse) Reset() {
+	*x = CancelExpressionResponse{}
+	mi := &file_internal_proto_expression_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelExpressionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelExpressionResponse) ProtoMessage() {}
+
+func (x *CancelExpressionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_expression_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelExpressionResponse.ProtoReflect.Descriptor instead.
+func (*CancelExpressionResponse) Descriptor() ([]byte, []int) {
+	return file_internal_proto_expression_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *CancelExpressionResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+type WatchExpressionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchExpressionRequest) Reset() {
+	*x = WatchExpressionRequest{}
+	mi := &file_internal_proto_expression_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchExpressionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchExpressionRequest) ProtoMessage() {}
+
+func (x *WatchExpressionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_expression_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchExpressionRequest.ProtoReflect.Descriptor instead.
+func (*WatchExpressionRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_expression_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *WatchExpressionRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type ExpressionEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	ExpressionId  string                 `protobuf:"bytes,2,opt,name=expression_id,json=expressionId,proto3" json:"expression_id,omitempty"`
+	TaskId        string                 `protobuf:"bytes,3,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	Status        string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	Result        *float64               `protobuf:"fixed64,5,opt,name=result,proto3,oneof" json:"result,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExpressionEvent) Reset() {
+	*x = ExpressionEvent{}
+	mi := &file_internal_proto_expression_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExpressionEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExpressionEvent) ProtoMessage() {}
+
+func (x *ExpressionEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_expression_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExpressionEvent.ProtoReflect.Descriptor instead.
+func (*ExpressionEvent) Descriptor() ([]byte, []int) {
+	return file_internal_proto_expression_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ExpressionEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *ExpressionEvent) GetExpressionId() string {
+	if x != nil {
+		return x.ExpressionId
+	}
+	return ""
+}
+
+func (x *ExpressionEvent) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *ExpressionEvent) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ExpressionEvent) GetResult() float64 {
+	if x != nil && x.Result != nil {
+		return *x.Result
+	}
+	return 0
+}
+
+func (x *ExpressionEvent) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+var File_internal_proto_expression_proto protoreflect.FileDescriptor
+
+const file_internal_proto_expression_proto_rawDesc = "" +
+	"\n" +
+	"\x1finternal/proto/expression.proto\x12\fcalc_service\x1a\x1cgoogle/api/annotations.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x8b\x02\n" +
+	"\n" +
+	"Expression\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1e\n" +
+	"\n" +
+	"expression\x18\x02 \x01(\tR\n" +
+	"expression\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x1b\n" +
+	"\x06result\x18\x04 \x01(\x01H\x00R\x06result\x88\x01\x01\x12\x1a\n" +
+	"\bpriority\x18\x05 \x01(\x05R\bpriority\x126\n" +
+	"\bdeadline\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\bdeadline\x129\n" +
+	"\n" +
+	"created_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAtB\t\n" +
+	"\a_result\"\xa6\x01\n" +
+	"\rSubmitRequest\x12\x1e\n" +
+	"\n" +
+	"expression\x18\x01 \x01(\tR\n" +
+	"expression\x12\x1a\n" +
+	"\bpriority\x18\x02 \x01(\x05R\bpriority\x126\n" +
+	"\bdeadline\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\bdeadline\x12!\n" +
+	"\fcallback_url\x18\x04 \x01(\tR\vcallbackUrl\" \n" +
+	"\x0eSubmitResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\":\n" +
+	"\x14GetExpressionRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04wait\x18\x02 \x01(\tR\x04wait\"\x8e\x02\n" +
+	"\x16ListExpressionsRequest\x12\x14\n" +
+	"\x05limit\x18\x01 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06cursor\x18\x02 \x01(\tR\x06cursor\x12\x16\n" +
+	"\x06status\x18\x03 \x03(\tR\x06status\x12?\n" +
+	"\rcreated_after\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\fcreatedAfter\x12A\n" +
+	"\x0ecreated_before\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\rcreatedBefore\x12\x16\n" +
+	"\x06search\x18\x06 \x01(\tR\x06search\x12\x12\n" +
+	"\x04sort\x18\a \x01(\tR\x04sort\"v\n" +
+	"\x17ListExpressionsResponse\x12:\n" +
+	"\vexpressions\x18\x01 \x03(\v2\x18.calc_service.ExpressionR\vexpressions\x12\x1f\n" +
+	"\vnext_cursor\x18\x02 \x01(\tR\n" +
+	"nextCursor\")\n" +
+	"\x17CancelExpressionRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"4\n" +
+	"\x18CancelExpressionResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\"(\n" +
+	"\x16WatchExpressionRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xd3\x01\n" +
+	"\x0fExpressionEvent\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12#\n" +
+	"\rexpression_id\x18\x02 \x01(\tR\fexpressionId\x12\x17\n" +
+	"\atask_id\x18\x03 \x01(\tR\x06taskId\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x1b\n" +
+	"\x06result\x18\x05 \x01(\x01H\x00R\x06result\x88\x01\x01\x12.\n" +
+	"\x04time\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\x04timeB\t\n" +
+	"\a_result2\xa4\x04\n" +
+	"\x11ExpressionService\x12a\n" +
+	"\x06Submit\x12\x1b.calc_service.SubmitRequest\x1a\x1c.calc_service.SubmitResponse\"\x1c\x82\xd3\xe4\x93\x02\x16:\x01*\"\x11/api/v1/calculate\x12e\n" +
+	"\x03Get\x12\".calc_service.GetExpressionRequest\x1a\x18.calc_service.Expression\" \x82\xd3\xe4\x93\x02\x1a\x12\x18/api/v1/expressions/{id}\x12p\n" +
+	"\x04List\x12$.calc_service.ListExpressionsRequest\x1a%.calc_service.ListExpressionsResponse\"\x1b\x82\xd3\xe4\x93\x02\x15\x12\x13/api/v1/expressions\x12\x80\x01\n" +
+	"\x06Cancel\x12%.calc_service.CancelExpressionRequest\x1a&.calc_service.CancelExpressionResponse\"'\x82\xd3\xe4\x93\x02!\"\x1f/api/v1/expressions/{id}/cancel\x12P\n" +
+	"\x05Watch\x12$.calc_service.WatchExpressionRequest\x1a\x1d.calc_service.ExpressionEvent\"\x000\x01B\tZ\a./protob\x06proto3"
+
+var (
+	file_internal_proto_expression_proto_rawDescOnce sync.Once
+	file_internal_proto_expression_proto_rawDescData []byte
+)
+
+func file_internal_proto_expression_proto_rawDescGZIP() []byte {
+	file_internal_proto_expression_proto_rawDescOnce.Do(func() {
+		file_internal_proto_expression_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_internal_proto_expression_proto_rawDesc), len(file_internal_proto_expression_proto_rawDesc)))
+	})
+	return file_internal_proto_expression_proto_rawDescData
+}
+
+var file_internal_proto_expression_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_internal_proto_expression_proto_goTypes = []any{
+	(*Expression)(nil),               // 0: calc_service.Expression
+	(*SubmitRequest)(nil),            // 1: calc_service.SubmitRequest
+	(*SubmitResponse)(nil),           // 2: calc_service.SubmitResponse
+	(*GetExpressionRequest)(nil),     // 3: calc_service.GetExpressionRequest
+	(*ListExpressionsRequest)(nil),   // 4: calc_service.ListExpressionsRequest
+	(*ListExpressionsResponse)(nil),  // 5: calc_service.ListExpressionsResponse
+	(*CancelExpressionRequest)(nil),  // 6: calc_service.CancelExpressionRequest
+	(*CancelExpressionResponse)(nil), // 7: calc_service.CancelExpressionResponse
+	(*WatchExpressionRequest)(nil),   // 8: calc_service.WatchExpressionRequest
+	(*ExpressionEvent)(nil),          // 9: calc_service.ExpressionEvent
+	(*timestamppb.Timestamp)(nil),    // 10: google.protobuf.Timestamp
+}
+var file_internal_proto_expression_proto_depIdxs = []int32{
+	10, // 0: calc_service.Expression.deadline:type_name -> google.protobuf.Timestamp
+	10, // 1: calc_service.Expression.created_at:type_name -> google.protobuf.Timestamp
+	10, // 2: calc_service.SubmitRequest.deadline:type_name -> google.protobuf.Timestamp
+	10, // 3: calc_service.ListExpressionsRequest.created_after:type_name -> google.protobuf.Timestamp
+	10, // 4: calc_service.ListExpressionsRequest.created_before:type_name -> google.protobuf.Timestamp
+	0,  // 5: calc_service.ListExpressionsResponse.expressions:type_name -> calc_service.Expression
+	10, // 6: calc_service.ExpressionEvent.time:type_name -> google.protobuf.Timestamp
+	1,  // 7: calc_service.ExpressionService.Submit:input_type -> calc_service.SubmitRequest
+	3,  // 8: calc_service.ExpressionService.Get:input_type -> calc_service.GetExpressionRequest
+	4,  // 9: calc_service.ExpressionService.List:input_type -> calc_service.ListExpressionsRequest
+	6,  // 10: calc_service.ExpressionService.Cancel:input_type -> calc_service.CancelExpressionRequest
+	8,  // 11: calc_service.ExpressionService.Watch:input_type -> calc_service.WatchExpressionRequest
+	2,  // 12: calc_service.ExpressionService.Submit:output_type -> calc_service.SubmitResponse
+	0,  // 13: calc_service.ExpressionService.Get:output_type -> calc_service.Expression
+	5,  // 14: calc_service.ExpressionService.List:output_type -> calc_service.ListExpressionsResponse
+	7,  // 15: calc_service.ExpressionService.Cancel:output_type -> calc_service.CancelExpressionResponse
+	9,  // 16: calc_service.ExpressionService.Watch:output_type -> calc_service.ExpressionEvent
+	12, // [12:17] is the sub-list for method output_type
+	7,  // [7:12] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_internal_proto_expression_proto_init() }
+func file_internal_proto_expression_proto_init() {
+	if File_internal_proto_expression_proto != nil {
+		return
+	}
+	file_internal_proto_expression_proto_msgTypes[0].OneofWrappers = []any{}
+	file_internal_proto_expression_proto_msgTypes[9].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_proto_expression_proto_rawDesc), len(file_internal_proto_expression_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_internal_proto_expression_proto_goTypes,
+		DependencyIndexes: file_internal_proto_expression_proto_depIdxs,
+		MessageInfos:      file_internal_proto_expression_proto_msgTypes,
+	}.Build()
+	File_internal_proto_expression_proto = out.File
+	file_internal_proto_expression_proto_goTypes = nil
+	file_internal_proto_expression_proto_depIdxs = nil
+}
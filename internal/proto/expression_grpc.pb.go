@@ -0,0 +1,277 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v3.19.4
+// source: internal/proto/expression.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ExpressionService_Submit_FullMethodName = "/calc_service.ExpressionService/Submit"
+	ExpressionService_Get_FullMethodName    = "/calc_service.ExpressionService/Get"
+	ExpressionService_List_FullMethodName   = "/calc_service.ExpressionService/List"
+	ExpressionService_Cancel_FullMethodName = "/calc_service.ExpressionService/Cancel"
+	ExpressionService_Watch_FullMethodName  = "/calc_service.ExpressionService/Watch"
+)
+
+// ExpressionServiceClient is the client API for ExpressionService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ExpressionServiceClient interface {
+	Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitResponse, error)
+	Get(ctx context.Context, in *GetExpressionRequest, opts ...grpc.CallOption) (*Expression, error)
+	List(ctx context.Context, in *ListExpressionsRequest, opts ...grpc.CallOption) (*ListExpressionsResponse, error)
+	Cancel(ctx context.Context, in *CancelExpressionRequest, opts ...grpc.CallOption) (*CancelExpressionResponse, error)
+	Watch(ctx context.Context, in *WatchExpressionRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExpressionEvent], error)
+}
+
+type expressionServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExpressionServiceClient(cc grpc.ClientConnInterface) ExpressionServiceClient {
+	return &expressionServiceClient{cc}
+}
+
+func (c *expressionServiceClient) Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitResponse)
+	err := c.cc.Invoke(ctx, ExpressionService_Submit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *expressionServiceClient) Get(ctx context.Context, in *GetExpressionRequest, opts ...grpc.CallOption) (*Expression, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Expression)
+	err := c.cc.Invoke(ctx, ExpressionService_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *expressionServiceClient) List(ctx context.Context, in *ListExpressionsRequest, opts ...grpc.CallOption) (*ListExpressionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListExpressionsResponse)
+	err := c.cc.Invoke(ctx, ExpressionService_List_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *expressionServiceClient) Cancel(ctx context.Context, in *CancelExpressionRequest, opts ...grpc.CallOption) (*CancelExpressionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CancelExpressionResponse)
+	err := c.cc.Invoke(ctx, ExpressionService_Cancel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *expressionServiceClient) Watch(ctx context.Context, in *WatchExpressionRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExpressionEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ExpressionService_ServiceDesc.Streams[0], ExpressionService_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchExpressionRequest, ExpressionEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ExpressionService_WatchClient = grpc.ServerStreamingClient[ExpressionEvent]
+
+// ExpressionServiceServer is the server API for ExpressionService service.
+// All implementations must embed UnimplementedExpressionServiceServer
+// for forward compatibility.
+type ExpressionServiceServer interface {
+	Submit(context.Context, *SubmitRequest) (*SubmitResponse, error)
+	Get(context.Context, *GetExpressionRequest) (*Expression, error)
+	List(context.Context, *ListExpressionsRequest) (*ListExpressionsResponse, error)
+	Cancel(context.Context, *CancelExpressionRequest) (*CancelExpressionResponse, error)
+	Watch(*WatchExpressionRequest, grpc.ServerStreamingServer[ExpressionEvent]) error
+	mustEmbedUnimplementedExpressionServiceServer()
+}
+
+// UnimplementedExpressionServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedExpressionServiceServer struct{}
+
+func (UnimplementedExpressionServiceServer) Submit(context.Context, *SubmitRequest) (*SubmitResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Submit not implemented")
+}
+func (UnimplementedExpressionServiceServer) Get(context.Context, *GetExpressionRequest) (*Expression, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedExpressionServiceServer) List(context.Context, *ListExpressionsRequest) (*ListExpressionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedExpressionServiceServer) Cancel(context.Context, *CancelExpressionRequest) (*CancelExpressionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedExpressionServiceServer) Watch(*WatchExpressionRequest, grpc.ServerStreamingServer[ExpressionEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedExpressionServiceServer) mustEmbedUnimplementedExpressionServiceServer() {}
+func (UnimplementedExpressionServiceServer) testEmbeddedByValue()                           {}
+
+// UnsafeExpressionServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ExpressionServiceServer will
+// result in compilation errors.
+type UnsafeExpressionServiceServer interface {
+	mustEmbedUnimplementedExpressionServiceServer()
+}
+
+func RegisterExpressionServiceServer(s grpc.ServiceRegistrar, srv ExpressionServiceServer) {
+	// If the following call pancis, it indicates UnimplementedExpressionServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ExpressionService_ServiceDesc, srv)
+}
+
+func _ExpressionService_Submit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExpressionServiceServer).Submit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExpressionService_Submit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExpressionServiceServer).Submit(ctx, req.(*SubmitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExpressionService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetExpressionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExpressionServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExpressionService_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExpressionServiceServer).Get(ctx, req.(*GetExpressionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExpressionService_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListExpressionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExpressionServiceServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExpressionService_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExpressionServiceServer).List(ctx, req.(*ListExpressionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExpressionService_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelExpressionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExpressionServiceServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExpressionService_Cancel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExpressionServiceServer).Cancel(ctx, req.(*CancelExpressionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExpressionService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchExpressionRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ExpressionServiceServer).Watch(m, &grpc.GenericServerStream[WatchExpressionRequest, ExpressionEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ExpressionService_WatchServer = grpc.ServerStreamingServer[ExpressionEvent]
+
+// ExpressionService_ServiceDesc is the grpc.ServiceDesc for ExpressionService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ExpressionService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "calc_service.ExpressionService",
+	HandlerType: (*ExpressionServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Submit",
+			Handler:    _ExpressionService_Submit_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _ExpressionService_Get_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _ExpressionService_List_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _ExpressionService_Cancel_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _ExpressionService_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "internal/proto/expression.proto",
+}
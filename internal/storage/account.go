@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// ChangePassword stores a new password hash and revokes every session and
+// API key of the user, so credentials issued with the old password stop
+// working.
+func (s *Storage) ChangePassword(id int, hash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE users SET password = ? WHERE id = ?", hash, id)
+	if err != nil {
+		return fmt.Errorf("change password: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	_, err = tx.Exec(
+		"UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM api_keys WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("revoke api keys: %w", err)
+	}
+	return tx.Commit()
+}
+
+// UpgradePasswordHash replaces the stored hash with an equivalent one made
+// with current parameters. It does nothing if the password was changed
+// since old was read, so an upgrade cannot undo a concurrent change.
+func (s *Storage) UpgradePasswordHash(id int, old, hash string) error {
+	_, err := s.db.Exec("UPDATE users SET password = ? WHERE id = ? AND password = ?", hash, id, old)
+	if err != nil {
+		return fmt.Errorf("upgrade password hash: %w", err)
+	}
+	return nil
+}
+
+// userData lists the statements that remove a user's rows, children first
+// so foreign keys hold at every step.
+var userData = []string{
+	"DELETE FROM webhook_deliveries WHERE user_id = ?",
+	"DELETE FROM webhooks WHERE user_id = ?",
+	"DELETE FROM idempotency_keys WHERE user_id = ?",
+	"DELETE FROM expression_shares WHERE ? IN (owner_id, grantee_id)",
+	"DELETE FROM tasks WHERE expression_id IN (SELECT id FROM expressions WHERE user_id = ?)",
+	"DELETE FROM expressions WHERE user_id = ?",
+	"DELETE FROM batches WHERE user_id = ?",
+	"DELETE FROM refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE user_id = ?)",
+	"DELETE FROM sessions WHERE user_id = ?",
+	"DELETE FROM api_keys WHERE user_id = ?",
+	"DELETE FROM user_identities WHERE user_id = ?",
+}
+
+// DeleteUser removes the user together with their expressions, tasks and
+// every other row that belongs to them, in one transaction.
+func (s *Storage) DeleteUser(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range userData {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return fmt.Errorf("delete user data: %w", err)
+		}
+	}
+
+	res, err := tx.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// RecordLoginFailure counts a failed login. Once the count reaches
+// threshold the account is locked until now+lockFor and the count starts
+// over; the lock time is returned then and nil otherwise.
+func (s *Storage) RecordLoginFailure(id, threshold int, lockFor time.Duration, now time.Time) (*time.Time, error) {
+	var failures int
+	err := s.db.QueryRow(
+		"UPDATE users SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins",
+		id,
+	).Scan(&failures)
+	if err != nil {
+		return nil, fmt.Errorf("record login failure: %w", err)
+	}
+	if threshold <= 0 || failures < threshold {
+		return nil, nil
+	}
+
+	until := now.Add(lockFor).UTC()
+	_, err = s.db.Exec(
+		"UPDATE users SET failed_logins = 0, locked_until = ? WHERE id = ?",
+		until, id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("lock account: %w", err)
+	}
+	return &until, nil
+}
+
+// ResetLoginFailures clears the failure count and any lock after a
+// successful login.
+func (s *Storage) ResetLoginFailures(id int) error {
+	_, err := s.db.Exec(
+		"UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?",
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("reset login failures: %w", err)
+	}
+	return nil
+}
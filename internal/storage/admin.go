@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *Storage) ListUsers() ([]*User, error) {
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM users ORDER BY id ASC")
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetUserRole changes the role of a user. Access tokens carry the role they
+// were issued with, so a change also revokes every session of the user and
+// they have to sign in again to act with the new one.
+func (s *Storage) SetUserRole(id int, role string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current string
+	if err := tx.QueryRow("SELECT role FROM users WHERE id = ?", id).Scan(&current); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("set user role: %w", err)
+	}
+	if current == role {
+		return nil
+	}
+
+	if _, err := tx.Exec("UPDATE users SET role = ? WHERE id = ?", role, id); err != nil {
+		return fmt.Errorf("set user role: %w", err)
+	}
+	_, err = tx.Exec(
+		"UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) SetUserRoleByLogin(login, role string) error {
+	res, err := s.db.Exec("UPDATE users SET role = ? WHERE login = ?", role, login)
+	if err != nil {
+		return fmt.Errorf("set user role: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetUserDisabled disables or re-enables an account. Disabling also revokes
+// every session of the user, so their tokens stop working at once.
+func (s *Storage) SetUserDisabled(id int, disabled bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var disabledAt interface{}
+	if disabled {
+		disabledAt = time.Now().UTC()
+	}
+	res, err := tx.Exec("UPDATE users SET disabled_at = ? WHERE id = ?", disabledAt, id)
+	if err != nil {
+		return fmt.Errorf("disable user: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	if disabled {
+		_, err = tx.Exec(
+			"UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+			time.Now().UTC(), id,
+		)
+		if err != nil {
+			return fmt.Errorf("revoke sessions: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListOpenTasks returns tasks that are neither completed nor cancelled.
+func (s *Storage) ListOpenTasks(limit int) ([]*Task, error) {
+	rows, err := s.db.Query(
+		`SELECT id, expression_id, arg1, arg2, operation, operation_time, started_at,
+		agent_id, lease_expires_at
+		FROM tasks
+		WHERE completed = FALSE AND cancelled = FALSE
+		ORDER BY id ASC
+		LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		t := &Task{}
+		err := rows.Scan(&t.ID, &t.ExprID, &t.Arg1, &t.Arg2, &t.Operation, &t.OperationTime, &t.StartedAt, &t.AgentID, &t.LeaseExpires)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+func (s *Storage) taskOwner(id string) (exprID, userID int, err error) {
+	var completed, cancelled bool
+	var status string
+	err = s.db.QueryRow(
+		`SELECT t.expression_id, e.user_id, t.completed, t.cancelled, e.status
+		FROM tasks t
+		JOIN expressions e ON e.id = t.expression_id
+		WHERE t.id = ?`,
+		id,
+	).Scan(&exprID, &userID, &completed, &cancelled, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, ErrNotFound
+		}
+		return 0, 0, fmt.Errorf("get task: %w", err)
+	}
+	if completed || cancelled || status != "pending" {
+		return 0, 0, ErrNotPending
+	}
+	return exprID, userID, nil
+}
+
+// RequeueTask drops the lease on an open task so any agent can take it.
+func (s *Storage) RequeueTask(id string) error {
+	if _, _, err := s.taskOwner(id); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(
+		`UPDATE tasks SET started_at = NULL, agent_id = NULL, lease_expires_at = NULL
+		WHERE id = ? AND completed = FALSE AND cancelled = FALSE`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("requeue task: %w", err)
+	}
+	return nil
+}
+
+// CancelTask cancels the expression the task belongs to, whoever owns it;
+// an expression cannot finish once one of its tasks is gone.
+func (s *Storage) CancelTask(id string) error {
+	exprID, userID, err := s.taskOwner(id)
+	if err != nil {
+		return err
+	}
+	return s.CancelExpression(exprID, userID)
+}
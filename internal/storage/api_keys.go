@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// APIKey is a long-lived credential for non-interactive clients. Only the
+// hash of the key is stored; Prefix is kept so users can tell keys apart.
+type APIKey struct {
+	ID         int
+	UserID     int
+	Name       string
+	Prefix     string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+func (s *Storage) CreateAPIKey(userID int, name, prefix, keyHash string, scopes []string, expiresAt *time.Time) (*APIKey, error) {
+	k := &APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	var expires interface{}
+	if expiresAt != nil {
+		expires = expiresAt.UTC()
+	}
+	err := s.db.QueryRow(
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		userID, name, prefix, keyHash, strings.Join(scopes, " "), expires, k.CreatedAt,
+	).Scan(&k.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create api key: %w", err)
+	}
+	return k, nil
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at"
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	k := &APIKey{}
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &scopes, &expiresAt, &lastUsedAt, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	return k, nil
+}
+
+func (s *Storage) GetAPIKeys(userID int) ([]*APIKey, error) {
+	rows, err := s.db.Query(
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? ORDER BY id ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *Storage) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	k, err := scanAPIKey(s.db.QueryRow(
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?",
+		keyHash,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("get api key: %w", err)
+	}
+	return k, nil
+}
+
+// TouchAPIKey records a use of the key. The timestamp is only written when
+// the stored one is older than granularity, so busy keys do not turn every
+// request into a write.
+func (s *Storage) TouchAPIKey(id int, now time.Time, granularity time.Duration) error {
+	_, err := s.db.Exec(
+		`UPDATE api_keys SET last_used_at = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`,
+		now.UTC(), id, now.Add(-granularity).UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("touch api key: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) DeleteAPIKey(id, userID int) error {
+	res, err := s.db.Exec(
+		"DELETE FROM api_keys WHERE id = ? AND user_id = ?",
+		id, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete api key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
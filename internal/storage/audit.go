@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AuditEvent records a security-relevant action. UserID is 0 when the
+// event does not belong to a known user, e.g. a login with an unknown name.
+type AuditEvent struct {
+	ID        int
+	Type      string
+	UserID    int
+	Login     string
+	IP        string
+	Detail    string
+	CreatedAt time.Time
+}
+
+func (s *Storage) RecordAuditEvent(e *AuditEvent) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	var userID interface{}
+	if e.UserID != 0 {
+		userID = e.UserID
+	}
+
+	res, err := s.db.Exec(
+		`INSERT INTO audit_events (type, user_id, login, ip, detail, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		e.Type, userID, e.Login, e.IP, e.Detail, e.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("record audit event: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	e.ID = int(id)
+	return nil
+}
+
+// HasAuditEvent reports whether an event of the type was recorded for the
+// user from the address.
+func (s *Storage) HasAuditEvent(eventType string, userID int, ip string) (bool, error) {
+	var found bool
+	err := s.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM audit_events WHERE user_id = ? AND type = ? AND ip = ?)`,
+		userID, eventType, ip,
+	).Scan(&found)
+	if err != nil {
+		return false, fmt.Errorf("find audit event: %w", err)
+	}
+	return found, nil
+}
+
+// ListAuditEvents returns the newest events first, optionally only those of
+// the given type.
+func (s *Storage) ListAuditEvents(eventType string, limit int) ([]*AuditEvent, error) {
+	query := "SELECT id, type, user_id, login, ip, detail, created_at FROM audit_events"
+	var args []interface{}
+	if eventType != "" {
+		query += " WHERE type = ?"
+		args = append(args, eventType)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var list []*AuditEvent
+	for rows.Next() {
+		e := &AuditEvent{}
+		var userID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.Type, &userID, &e.Login, &e.IP, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.UserID = int(userID.Int64)
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
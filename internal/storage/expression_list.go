@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SortCreatedDesc  = "-created_at"
+	SortCreatedAsc   = "created_at"
+	SortPriorityDesc = "-priority"
+	SortPriorityAsc  = "priority"
+
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+type ExpressionFilter struct {
+	Statuses      []string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Search        string
+	Sort          string
+	Cursor        string
+	Limit         int
+}
+
+// listCursor is the position after the last expression of a page. It
+// records the sort order and the filters of the listing it came from, since
+// the position means nothing in a listing ordered or filtered differently.
+type listCursor struct {
+	sort     string
+	filter   string
+	priority int
+	id       int
+}
+
+func (c listCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s:%d:%d", c.sort, c.filter, c.priority, c.id)))
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 4 {
+		return c, ErrInvalidCursor
+	}
+	c.sort, c.filter = parts[0], parts[1]
+	if c.priority, err = strconv.Atoi(parts[2]); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.id, err = strconv.Atoi(parts[3]); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// filterHash identifies the filters of a listing, leaving out the sort
+// order, the cursor and the page size.
+func (f ExpressionFilter) filterHash() string {
+	statuses := slices.Clone(f.Statuses)
+	slices.Sort(statuses)
+	bound := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%q|%s|%s|%q", statuses, bound(f.CreatedAfter), bound(f.CreatedBefore), f.Search)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ListExpressions returns one page of a user's expressions and the cursor of
+// the next page, which is empty on the last page. Expressions are ordered by
+// id within equal sort keys, so ids stand in for creation time.
+func (s *Storage) ListExpressions(userID int, f ExpressionFilter) ([]*Expression, string, error) {
+	return s.listExpressions([]string{"user_id = ?"}, []interface{}{userID}, f)
+}
+
+// ListAllExpressions is ListExpressions across all users.
+func (s *Storage) ListAllExpressions(f ExpressionFilter) ([]*Expression, string, error) {
+	return s.listExpressions([]string{"1 = 1"}, nil, f)
+}
+
+func (s *Storage) listExpressions(where []string, args []interface{}, f ExpressionFilter) ([]*Expression, string, error) {
+	if f.Sort == "" {
+		f.Sort = SortCreatedDesc
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultPageSize
+	}
+	if f.Limit > MaxPageSize {
+		f.Limit = MaxPageSize
+	}
+
+	if len(f.Statuses) > 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(f.Statuses)-1)+")")
+		for _, status := range f.Statuses {
+			args = append(args, status)
+		}
+	}
+	if f.CreatedAfter != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.CreatedAfter.UTC())
+	}
+	if f.CreatedBefore != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, f.CreatedBefore.UTC())
+	}
+	if f.Search != "" {
+		where = append(where, `expression LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(f.Search)+"%")
+	}
+
+	var order string
+	switch f.Sort {
+	case SortCreatedDesc:
+		order = "id DESC"
+	case SortCreatedAsc:
+		order = "id ASC"
+	case SortPriorityDesc:
+		order = "priority DESC, id DESC"
+	case SortPriorityAsc:
+		order = "priority ASC, id ASC"
+	default:
+		return nil, "", ErrInvalidSort
+	}
+
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if c.sort != f.Sort || c.filter != f.filterHash() {
+			return nil, "", ErrInvalidCursor
+		}
+		switch f.Sort {
+		case SortCreatedDesc:
+			where = append(where, "id < ?")
+			args = append(args, c.id)
+		case SortCreatedAsc:
+			where = append(where, "id > ?")
+			args = append(args, c.id)
+		case SortPriorityDesc:
+			where = append(where, "(priority < ? OR (priority = ? AND id < ?))")
+			args = append(args, c.priority, c.priority, c.id)
+		case SortPriorityAsc:
+			where = append(where, "(priority > ? OR (priority = ? AND id > ?))")
+			args = append(args, c.priority, c.priority, c.id)
+		}
+	}
+
+	query := fmt.Sprintf(
+		`SELECT id, user_id, expression, status, result, priority, deadline, created_at
+		FROM expressions
+		WHERE %s
+		ORDER BY %s
+		LIMIT ?`,
+		strings.Join(where, " AND "), order,
+	)
+	rows, err := s.db.Query(query, append(args, f.Limit+1)...)
+	if err != nil {
+		return nil, "", fmt.Errorf("list expressions: %w", err)
+	}
+	defer rows.Close()
+
+	var exprs []*Expression
+	for rows.Next() {
+		e := &Expression{}
+		var result sql.NullFloat64
+		var deadline sql.NullTime
+		err := rows.Scan(&e.ID, &e.UserID, &e.Expression, &e.Status, &result, &e.Priority, &deadline, &e.CreatedAt)
+		if err != nil {
+			return nil, "", err
+		}
+		if result.Valid {
+			e.Result = &result.Float64
+		}
+		if deadline.Valid {
+			e.Deadline = &deadline.Time
+		}
+		exprs = append(exprs, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(exprs) > f.Limit {
+		exprs = exprs[:f.Limit]
+		last := exprs[len(exprs)-1]
+		next = listCursor{sort: f.Sort, filter: f.filterHash(), priority: last.Priority, id: last.ID}.encode()
+	}
+	return exprs, next, nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type IdempotencyKey struct {
+	UserID       int
+	Key          string
+	RequestHash  string
+	ExpressionID *int
+	CreatedAt    time.Time
+}
+
+// ReserveIdempotencyKey claims key for the user, replacing a claim created
+// before expiredBefore, or one never completed with an expression and made
+// before staleBefore, which its request is assumed to have abandoned. If the
+// key is already held, the existing record is returned and nothing is
+// reserved.
+func (s *Storage) ReserveIdempotencyKey(userID int, key, requestHash string, expiredBefore, staleBefore time.Time) (*IdempotencyKey, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`DELETE FROM idempotency_keys
+		WHERE user_id = ? AND key = ?
+		AND (created_at < ? OR (expression_id IS NULL AND created_at < ?))`,
+		userID, key, expiredBefore.UTC(), staleBefore.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("expire idempotency key: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO idempotency_keys (user_id, key, request_hash, created_at)
+		VALUES (?, ?, ?, ?)`,
+		userID, key, requestHash, time.Now().UTC(),
+	)
+	if err == nil {
+		return nil, tx.Commit()
+	}
+	if !isDuplicate(err) {
+		return nil, fmt.Errorf("reserve idempotency key: %w", err)
+	}
+
+	k := &IdempotencyKey{UserID: userID, Key: key}
+	var exprID sql.NullInt64
+	err = tx.QueryRow(
+		`SELECT request_hash, expression_id, created_at
+		FROM idempotency_keys
+		WHERE user_id = ? AND key = ?`,
+		userID, key,
+	).Scan(&k.RequestHash, &exprID, &k.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get idempotency key: %w", err)
+	}
+	if exprID.Valid {
+		id := int(exprID.Int64)
+		k.ExpressionID = &id
+	}
+	return k, nil
+}
+
+// completeIdempotencyKey points the reservation at the expression stored
+// for it. It fails if the reservation is gone, as after it went stale and
+// another request took the key over.
+func completeIdempotencyKey(tx *sql.Tx, userID int, key string, expressionID int) error {
+	res, err := tx.Exec(
+		"UPDATE idempotency_keys SET expression_id = ? WHERE user_id = ? AND key = ? AND expression_id IS NULL",
+		expressionID, userID, key,
+	)
+	if err != nil {
+		return fmt.Errorf("complete idempotency key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Storage) ReleaseIdempotencyKey(userID int, key string) error {
+	_, err := s.db.Exec(
+		"DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND expression_id IS NULL",
+		userID, key,
+	)
+	if err != nil {
+		return fmt.Errorf("release idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) PurgeIdempotencyKeys(before time.Time) (int64, error) {
+	res, err := s.db.Exec(
+		"DELETE FROM idempotency_keys WHERE created_at < ?",
+		before.UTC(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("purge idempotency keys: %w", err)
+	}
+	return res.RowsAffected()
+}
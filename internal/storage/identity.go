@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GetUserByIdentity returns the local user linked to an external identity.
+func (s *Storage) GetUserByIdentity(issuer, subject string) (*User, error) {
+	u, err := scanUser(s.db.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE id = (SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?)",
+		issuer, subject,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("get user by identity: %w", err)
+	}
+	return u, nil
+}
+
+// CreateUserWithIdentity creates a user linked to an external identity in
+// one transaction. ErrAlreadyExists means the login, or the identity, is
+// taken.
+func (s *Storage) CreateUserWithIdentity(login, password, issuer, subject, email string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(
+		"INSERT INTO users (login, password) VALUES (?, ?) RETURNING id",
+		login, password,
+	).Scan(&id)
+	if err != nil {
+		if isDuplicate(err) {
+			return 0, ErrAlreadyExists
+		}
+		return 0, fmt.Errorf("create user: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO user_identities (user_id, issuer, subject, email, created_at) VALUES (?, ?, ?, ?, ?)",
+		id, issuer, subject, email, time.Now().UTC(),
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return 0, ErrAlreadyExists
+		}
+		return 0, fmt.Errorf("link identity: %w", err)
+	}
+	return id, tx.Commit()
+}
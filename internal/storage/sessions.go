@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Session groups the refresh tokens issued from one login. Each refresh
+// rotates the token; presenting a rotated token again revokes the session.
+type Session struct {
+	ID        int
+	UserID    int
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
+
+func (s *Storage) CreateSession(userID int, tokenHash string, expiresAt time.Time) (*Session, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	session := &Session{UserID: userID, CreatedAt: time.Now().UTC()}
+	res, err := tx.Exec(
+		"INSERT INTO sessions (user_id, created_at) VALUES (?, ?)",
+		userID, session.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	session.ID = int(id)
+
+	if err := insertRefreshToken(tx, session.ID, tokenHash, expiresAt); err != nil {
+		return nil, err
+	}
+	return session, tx.Commit()
+}
+
+func insertRefreshToken(tx *sql.Tx, sessionID int, tokenHash string, expiresAt time.Time) error {
+	_, err := tx.Exec(
+		`INSERT INTO refresh_tokens (token_hash, session_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?)`,
+		tokenHash, sessionID, expiresAt.UTC(), time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("create refresh token: %w", err)
+	}
+	return nil
+}
+
+// RotateRefreshToken exchanges a refresh token for newHash. It returns
+// ErrNotFound for unknown, expired or revoked tokens and ErrTokenReused,
+// after revoking the session, for a token that was already rotated.
+func (s *Storage) RotateRefreshToken(tokenHash, newHash string, expiresAt time.Time) (*Session, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	session := &Session{}
+	var tokenExpires time.Time
+	var revokedAt sql.NullTime
+	err = tx.QueryRow(
+		`SELECT s.id, s.user_id, s.created_at, s.revoked_at, t.expires_at
+		FROM refresh_tokens t
+		JOIN sessions s ON s.id = t.session_id
+		WHERE t.token_hash = ?`,
+		tokenHash,
+	).Scan(&session.ID, &session.UserID, &session.CreatedAt, &revokedAt, &tokenExpires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("get refresh token: %w", err)
+	}
+	if revokedAt.Valid || !tokenExpires.After(time.Now()) {
+		return nil, ErrNotFound
+	}
+
+	res, err := tx.Exec(
+		"UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ? AND used_at IS NULL",
+		time.Now().UTC(), tokenHash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("use refresh token: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if err := revokeSession(tx, session.ID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrTokenReused
+	}
+
+	if err := insertRefreshToken(tx, session.ID, newHash, expiresAt); err != nil {
+		return nil, err
+	}
+	return session, tx.Commit()
+}
+
+func revokeSession(tx *sql.Tx, sessionID int) error {
+	_, err := tx.Exec(
+		"UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeSessionByRefreshToken revokes the session the token belongs to,
+// whether or not the token has been rotated since.
+func (s *Storage) RevokeSessionByRefreshToken(tokenHash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var sessionID int
+	err = tx.QueryRow(
+		"SELECT session_id FROM refresh_tokens WHERE token_hash = ?",
+		tokenHash,
+	).Scan(&sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("get refresh token: %w", err)
+	}
+	if err := revokeSession(tx, sessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SessionRevoked reports whether access tokens of the session must be
+// rejected. Unknown sessions count as revoked.
+func (s *Storage) SessionRevoked(sessionID int) (bool, error) {
+	var revokedAt sql.NullTime
+	err := s.db.QueryRow(
+		"SELECT revoked_at FROM sessions WHERE id = ?",
+		sessionID,
+	).Scan(&revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return true, nil
+		}
+		return false, fmt.Errorf("get session: %w", err)
+	}
+	return revokedAt.Valid, nil
+}
+
+func (s *Storage) PurgeRefreshTokens(before time.Time) (int64, error) {
+	res, err := s.db.Exec(
+		"DELETE FROM refresh_tokens WHERE expires_at < ?",
+		before.UTC(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("purge refresh tokens: %w", err)
+	}
+	return res.RowsAffected()
+}
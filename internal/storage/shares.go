@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Share grants read access to an expression, either to another user or,
+// when GranteeID is 0, to anyone holding the link token. Only the token's
+// hash is stored.
+type Share struct {
+	ID           int
+	ExpressionID int
+	OwnerID      int
+	GranteeID    int
+	CreatedAt    time.Time
+}
+
+func (sh *Share) Public() bool {
+	return sh.GranteeID == 0
+}
+
+// CreateShare shares an expression the owner has with granteeID, or as a
+// public link when granteeID is 0 and tokenHash is set. It returns
+// ErrNotFound if ownerID does not own the expression and ErrAlreadyExists
+// if it is already shared with the grantee.
+func (s *Storage) CreateShare(exprID, ownerID, granteeID int, tokenHash string) (*Share, error) {
+	sh := &Share{ExpressionID: exprID, OwnerID: ownerID, GranteeID: granteeID, CreatedAt: time.Now().UTC()}
+
+	var grantee, hash interface{}
+	if granteeID != 0 {
+		grantee = granteeID
+	} else {
+		hash = tokenHash
+	}
+	err := s.db.QueryRow(
+		`INSERT INTO expression_shares (expression_id, owner_id, grantee_id, token_hash, created_at)
+		SELECT id, user_id, ?, ?, ? FROM expressions WHERE id = ? AND user_id = ?
+		RETURNING id`,
+		grantee, hash, sh.CreatedAt, exprID, ownerID,
+	).Scan(&sh.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		if isDuplicate(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, fmt.Errorf("create share: %w", err)
+	}
+	return sh, nil
+}
+
+// ListShares returns the shares of an expression the owner has.
+func (s *Storage) ListShares(exprID, ownerID int) ([]*Share, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM expressions WHERE id = ? AND user_id = ?", exprID, ownerID).Scan(&n)
+	if err != nil {
+		return nil, fmt.Errorf("list shares: %w", err)
+	}
+	if n == 0 {
+		return nil, ErrNotFound
+	}
+
+	rows, err := s.db.Query(
+		`SELECT id, expression_id, owner_id, grantee_id, created_at
+		FROM expression_shares
+		WHERE expression_id = ? AND owner_id = ?
+		ORDER BY id ASC`,
+		exprID, ownerID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list shares: %w", err)
+	}
+	defer rows.Close()
+
+	var shares []*Share
+	for rows.Next() {
+		sh := &Share{}
+		var grantee sql.NullInt64
+		if err := rows.Scan(&sh.ID, &sh.ExpressionID, &sh.OwnerID, &grantee, &sh.CreatedAt); err != nil {
+			return nil, err
+		}
+		sh.GranteeID = int(grantee.Int64)
+		shares = append(shares, sh)
+	}
+	return shares, rows.Err()
+}
+
+// ListSharedExpressions returns the expressions other users shared with
+// granteeID, newest first.
+func (s *Storage) ListSharedExpressions(granteeID int) ([]*Expression, error) {
+	rows, err := s.db.Query(
+		`SELECT e.id, e.user_id, e.expression, e.status, e.result, e.priority, e.deadline, e.created_at
+		FROM expressions e JOIN expression_shares sh ON sh.expression_id = e.id
+		WHERE sh.grantee_id = ?
+		ORDER BY e.id DESC`,
+		granteeID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list shared expressions: %w", err)
+	}
+	defer rows.Close()
+
+	var exprs []*Expression
+	for rows.Next() {
+		e := &Expression{}
+		var result sql.NullFloat64
+		var deadline sql.NullTime
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Expression, &e.Status, &result, &e.Priority, &deadline, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if result.Valid {
+			e.Result = &result.Float64
+		}
+		if deadline.Valid {
+			e.Deadline = &deadline.Time
+		}
+		exprs = append(exprs, e)
+	}
+	return exprs, rows.Err()
+}
+
+// DeleteShare revokes a share; the grantee or link loses access at once.
+func (s *Storage) DeleteShare(id, exprID, ownerID int) error {
+	res, err := s.db.Exec(
+		"DELETE FROM expression_shares WHERE id = ? AND expression_id = ? AND owner_id = ?",
+		id, exprID, ownerID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete share: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetSharedExpression returns the expression behind a public link.
+func (s *Storage) GetSharedExpression(tokenHash string) (*Expression, error) {
+	return s.getExpression(
+		"WHERE id = (SELECT expression_id FROM expression_shares WHERE token_hash = ?)",
+		tokenHash,
+	)
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"embed"
 	"errors"
@@ -8,29 +9,61 @@ import (
 	"math"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+
+	"calc_service/internal/events"
 )
 
 var (
-	ErrNotFound      = errors.New("not found")
-	ErrAlreadyExists = errors.New("already exists")
+	ErrNotFound       = errors.New("not found")
+	ErrAlreadyExists  = errors.New("already exists")
+	ErrNotPending     = errors.New("expression is not pending")
+	ErrInvalidCursor  = errors.New("invalid cursor")
+	ErrInvalidSort    = errors.New("invalid sort")
+	ErrTokenReused    = errors.New("refresh token reused")
+	ErrLeaseNotHeld   = errors.New("task lease not held")
+	ErrTooManyPending = errors.New("too many pending expressions")
 )
 
 var embedMigrations embed.FS
 
 type User struct {
-	ID       int
-	Login    string
-	Password string
+	ID           int
+	Login        string
+	Password     string
+	Role         string
+	DisabledAt   *time.Time
+	FailedLogins int
+	LockedUntil  *time.Time
 }
 
 type Expression struct {
-	ID         int
-	UserID     int
-	Expression string
-	Status     string
-	Result     *float64
-	CreatedAt  time.Time
+	ID          int
+	UserID      int
+	Expression  string
+	Status      string
+	Result      *float64
+	Priority    int
+	Deadline    *time.Time
+	BatchID     *int
+	CallbackURL string
+	CreatedAt   time.Time
+}
+
+type Batch struct {
+	ID          int
+	UserID      int
+	CreatedAt   time.Time
+	Expressions []*Expression
+}
+
+type BatchItem struct {
+	Expression  string
+	Priority    int
+	Deadline    *time.Time
+	CallbackURL string
+	Tasks       []*Task
+	ID          int
 }
 
 type Task struct {
@@ -42,17 +75,25 @@ type Task struct {
 	OperationTime int
 	StartedAt     sql.NullTime
 	Completed     bool
+	Cancelled     bool
 	Result        sql.NullFloat64
+	AgentID       sql.NullString
+	LeaseExpires  sql.NullTime
 }
 
 type Storage struct {
-	db *sql.DB
+	db     *sql.DB
+	events *events.Broker
 }
 
 func (s *Storage) GetDB() *sql.DB {
 	return s.db
 }
 
+func (s *Storage) Events() *events.Broker {
+	return s.events
+}
+
 func (s *Storage) CreateUser(login, password string) (int, error) {
 	var id int
 	err := s.db.QueryRow(
@@ -69,13 +110,28 @@ func (s *Storage) CreateUser(login, password string) (int, error) {
 	return id, nil
 }
 
-func (s *Storage) GetUserByLogin(login string) (*User, error) {
+const userColumns = "id, login, password, role, disabled_at, failed_logins, locked_until"
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
 	u := &User{}
-	err := s.db.QueryRow(
-		"SELECT id, login, password FROM users WHERE login = ?",
-		login,
-	).Scan(&u.ID, &u.Login, &u.Password)
+	var disabledAt, lockedUntil sql.NullTime
+	if err := row.Scan(&u.ID, &u.Login, &u.Password, &u.Role, &disabledAt, &u.FailedLogins, &lockedUntil); err != nil {
+		return nil, err
+	}
+	if disabledAt.Valid {
+		u.DisabledAt = &disabledAt.Time
+	}
+	if lockedUntil.Valid {
+		u.LockedUntil = &lockedUntil.Time
+	}
+	return u, nil
+}
 
+func (s *Storage) GetUserByLogin(login string) (*User, error) {
+	u, err := scanUser(s.db.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE login = ?",
+		login,
+	))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
@@ -86,12 +142,10 @@ func (s *Storage) GetUserByLogin(login string) (*User, error) {
 }
 
 func (s *Storage) GetUserByID(id int) (*User, error) {
-	u := &User{}
-	err := s.db.QueryRow(
-		"SELECT id, login, password FROM users WHERE id = ?",
+	u, err := scanUser(s.db.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE id = ?",
 		id,
-	).Scan(&u.ID, &u.Login, &u.Password)
-
+	))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
@@ -101,28 +155,33 @@ func (s *Storage) GetUserByID(id int) (*User, error) {
 	return u, nil
 }
 
-func (s *Storage) DeleteUser(id int) error {
-	_, err := s.db.Exec("DELETE FROM users WHERE id = ?", id)
-	if err != nil {
-		return fmt.Errorf("delete user: %w", err)
-	}
-	return nil
+func (s *Storage) CreateExpression(userID int, expr string) (*Expression, error) {
+	return s.CreateScheduledExpression(userID, expr, 0, nil, "")
 }
 
-func (s *Storage) CreateExpression(userID int, expr string) (*Expression, error) {
+func (s *Storage) CreateScheduledExpression(userID int, expr string, priority int, deadline *time.Time, callbackURL string) (*Expression, error) {
 	e := &Expression{
-		UserID:     userID,
-		Expression: expr,
-		Status:     "pending",
-		CreatedAt:  time.Now(),
+		UserID:      userID,
+		Expression:  expr,
+		Status:      "pending",
+		Priority:    priority,
+		CallbackURL: callbackURL,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	var dl interface{}
+	if deadline != nil {
+		utc := deadline.UTC()
+		e.Deadline = &utc
+		dl = utc
 	}
 
 	err := s.db.QueryRow(
 		`INSERT INTO expressions 
-		(user_id, expression, status, created_at) 
-		VALUES (?, ?, ?, ?) 
+		(user_id, expression, status, priority, deadline, callback_url, created_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?) 
 		RETURNING id`,
-		e.UserID, e.Expression, e.Status, e.CreatedAt,
+		e.UserID, e.Expression, e.Status, e.Priority, dl, e.CallbackURL, e.CreatedAt,
 	).Scan(&e.ID)
 
 	if err != nil {
@@ -131,15 +190,203 @@ func (s *Storage) CreateExpression(userID int, expr string) (*Expression, error)
 	return e, nil
 }
 
+// underPendingLimit guards the first write of a submission, so that the
+// check and the insert are one statement and concurrent submissions cannot
+// both pass it. Its arguments are maxPending, userID, the number of new
+// expressions and maxPending again; a maxPending of 0 means no limit.
+const underPendingLimit = `(? <= 0 OR (SELECT COUNT(*) FROM expressions WHERE user_id = ? AND status = 'pending') + ? <= ?)`
+
+// CreatePendingExpression stores the expression and its tasks in one
+// transaction, or returns ErrTooManyPending if the user would have more than
+// maxPending pending expressions. A non-empty idempotencyKey, reserved with
+// ReserveIdempotencyKey, is completed in the same transaction, so a key
+// never stays reserved for an expression that was stored.
+func (s *Storage) CreatePendingExpression(userID int, item *BatchItem, maxPending int, idempotencyKey string) (*Expression, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	e := &Expression{
+		UserID:      userID,
+		Expression:  item.Expression,
+		Status:      "pending",
+		Priority:    item.Priority,
+		CallbackURL: item.CallbackURL,
+		CreatedAt:   time.Now().UTC(),
+	}
+	var dl interface{}
+	if item.Deadline != nil {
+		utc := item.Deadline.UTC()
+		e.Deadline = &utc
+		dl = utc
+	}
+
+	err = tx.QueryRow(
+		`INSERT INTO expressions
+		(user_id, expression, status, priority, deadline, callback_url, created_at)
+		SELECT ?, ?, 'pending', ?, ?, ?, ?
+		WHERE `+underPendingLimit+`
+		RETURNING id`,
+		userID, e.Expression, e.Priority, dl, e.CallbackURL, e.CreatedAt,
+		maxPending, userID, 1, maxPending,
+	).Scan(&e.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTooManyPending
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create expression: %w", err)
+	}
+
+	item.ID = e.ID
+	if err := insertTasks(tx, e.ID, item.Tasks); err != nil {
+		return nil, err
+	}
+	if idempotencyKey != "" {
+		if err := completeIdempotencyKey(tx, userID, idempotencyKey, e.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// CreateBatch stores the items and their tasks in one transaction, or
+// returns ErrTooManyPending if the user would have more than maxPending
+// pending expressions.
+func (s *Storage) CreateBatch(userID int, items []*BatchItem, maxPending int) (*Batch, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	b := &Batch{UserID: userID, CreatedAt: time.Now().UTC()}
+	err = tx.QueryRow(
+		`INSERT INTO batches (user_id, created_at)
+		SELECT ?, ?
+		WHERE `+underPendingLimit+`
+		RETURNING id`,
+		userID, b.CreatedAt,
+		maxPending, userID, len(items), maxPending,
+	).Scan(&b.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTooManyPending
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create batch: %w", err)
+	}
+
+	for _, item := range items {
+		var dl interface{}
+		if item.Deadline != nil {
+			dl = item.Deadline.UTC()
+		}
+
+		err = tx.QueryRow(
+			`INSERT INTO expressions 
+			(user_id, expression, status, priority, deadline, callback_url, batch_id, created_at) 
+			VALUES (?, ?, 'pending', ?, ?, ?, ?, ?) 
+			RETURNING id`,
+			userID, item.Expression, item.Priority, dl, item.CallbackURL, b.ID, b.CreatedAt,
+		).Scan(&item.ID)
+		if err != nil {
+			return nil, fmt.Errorf("create batch expression: %w", err)
+		}
+
+		if err := insertTasks(tx, item.ID, item.Tasks); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func insertTasks(tx *sql.Tx, exprID int, tasks []*Task) error {
+	for _, t := range tasks {
+		t.ExprID = exprID
+		_, err := tx.Exec(
+			`INSERT INTO tasks 
+			(id, expression_id, arg1, arg2, operation, operation_time) 
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			t.ID, t.ExprID, t.Arg1, t.Arg2, t.Operation, t.OperationTime,
+		)
+		if err != nil {
+			return fmt.Errorf("create task: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) GetBatch(id, userID int) (*Batch, error) {
+	b := &Batch{ID: id, UserID: userID}
+	err := s.db.QueryRow(
+		"SELECT created_at FROM batches WHERE id = ? AND user_id = ?",
+		id, userID,
+	).Scan(&b.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("get batch: %w", err)
+	}
+
+	rows, err := s.db.Query(
+		`SELECT id, expression, status, result, priority, deadline 
+         FROM expressions 
+         WHERE batch_id = ? 
+         ORDER BY id ASC`,
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get batch expressions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := &Expression{UserID: userID, BatchID: &b.ID}
+		var result sql.NullFloat64
+		var deadline sql.NullTime
+		if err := rows.Scan(&e.ID, &e.Expression, &e.Status, &result, &e.Priority, &deadline); err != nil {
+			return nil, err
+		}
+		if result.Valid {
+			e.Result = &result.Float64
+		}
+		if deadline.Valid {
+			e.Deadline = &deadline.Time
+		}
+		b.Expressions = append(b.Expressions, e)
+	}
+	return b, rows.Err()
+}
+
+// GetExpressionByID returns the expression if userID owns it or it was
+// shared with them.
 func (s *Storage) GetExpressionByID(id, userID int) (*Expression, error) {
-	e := &Expression{ID: id, UserID: userID}
+	return s.getExpression(
+		`WHERE id = ? AND (user_id = ? OR EXISTS (
+			SELECT 1 FROM expression_shares WHERE expression_id = expressions.id AND grantee_id = ?))`,
+		id, userID, userID,
+	)
+}
+
+func (s *Storage) getExpression(where string, args ...interface{}) (*Expression, error) {
+	e := &Expression{}
 	var result sql.NullFloat64
+	var deadline sql.NullTime
+	var callbackURL sql.NullString
 	err := s.db.QueryRow(
-		`SELECT expression, status, result, created_at 
-		FROM expressions 
-		WHERE id = ? AND user_id = ?`,
-		id, userID,
-	).Scan(&e.Expression, &e.Status, &result, &e.CreatedAt)
+		`SELECT id, user_id, expression, status, result, priority, deadline, callback_url, created_at
+		FROM expressions `+where,
+		args...,
+	).Scan(&e.ID, &e.UserID, &e.Expression, &e.Status, &result, &e.Priority, &deadline, &callbackURL, &e.CreatedAt)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -151,12 +398,37 @@ func (s *Storage) GetExpressionByID(id, userID int) (*Expression, error) {
 	if result.Valid {
 		e.Result = &result.Float64
 	}
+	if deadline.Valid {
+		e.Deadline = &deadline.Time
+	}
+	e.CallbackURL = callbackURL.String
 	return e, nil
 }
 
+func (s *Storage) WaitExpression(ctx context.Context, id, userID int) (*Expression, error) {
+	ch, cancel := s.events.Subscribe(id)
+	defer cancel()
+
+	e, err := s.GetExpressionByID(id, userID)
+	if err != nil || e.Status != "pending" {
+		return e, err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return e, nil
+		case ev := <-ch:
+			if ev.Type == events.ExpressionFinished {
+				return s.GetExpressionByID(id, userID)
+			}
+		}
+	}
+}
+
 func (s *Storage) GetExpressions(userID int) ([]*Expression, error) {
 	rows, err := s.db.Query(
-		`SELECT id, expression, status, result 
+		`SELECT id, expression, status, result, priority, deadline 
          FROM expressions 
          WHERE user_id = ? 
          ORDER BY created_at DESC`,
@@ -169,20 +441,36 @@ func (s *Storage) GetExpressions(userID int) ([]*Expression, error) {
 
 	var exprs []*Expression
 	for rows.Next() {
-		e := &Expression{}
+		e := &Expression{UserID: userID}
 		var result sql.NullFloat64
-		err := rows.Scan(&e.ID, &e.Expression, &e.Status, &result)
+		var deadline sql.NullTime
+		err := rows.Scan(&e.ID, &e.Expression, &e.Status, &result, &e.Priority, &deadline)
 		if err != nil {
 			return nil, err
 		}
 		if result.Valid {
 			e.Result = &result.Float64
 		}
+		if deadline.Valid {
+			e.Deadline = &deadline.Time
+		}
 		exprs = append(exprs, e)
 	}
 	return exprs, nil
 }
 
+func (s *Storage) CountPendingExpressions(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM expressions WHERE user_id = ? AND status = 'pending'",
+		userID,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count pending expressions: %w", err)
+	}
+	return count, nil
+}
+
 func (s *Storage) UpdateExpression(e *Expression) error {
 	var result interface{}
 	if e.Result != nil {
@@ -199,14 +487,24 @@ func (s *Storage) UpdateExpression(e *Expression) error {
 }
 
 func (s *Storage) DeleteExpression(id, userID int) error {
-	_, err := s.db.Exec(
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM expression_shares WHERE expression_id = ? AND owner_id = ?", id, userID)
+	if err != nil {
+		return fmt.Errorf("delete expression shares: %w", err)
+	}
+	_, err = tx.Exec(
 		"DELETE FROM expressions WHERE id = ? AND user_id = ?",
 		id, userID,
 	)
 	if err != nil {
 		return fmt.Errorf("delete expression: %w", err)
 	}
-	return nil
+	return tx.Commit()
 }
 
 func (s *Storage) CreateTask(t *Task) error {
@@ -219,21 +517,30 @@ func (s *Storage) CreateTask(t *Task) error {
 	return err
 }
 
-func (s *Storage) GetPendingTask() (*Task, error) {
+// GetPendingTask leases the next runnable task to agentID until lease
+// elapses. Tasks under a live lease are skipped; an expired lease lets
+// another agent pick the task up.
+func (s *Storage) GetPendingTask(agentID string, lease time.Duration) (*Task, error) {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
+	now := time.Now().UTC()
 	t := &Task{}
+	var userID int
 	err = tx.QueryRow(
-		`SELECT id, expression_id, arg1, arg2, operation, operation_time 
-         FROM tasks 
-         WHERE completed = FALSE 
-         ORDER BY id ASC 
-         LIMIT 1`).Scan(
-		&t.ID, &t.ExprID, &t.Arg1, &t.Arg2, &t.Operation, &t.OperationTime,
+		`SELECT t.id, t.expression_id, t.arg1, t.arg2, t.operation, t.operation_time, e.user_id 
+         FROM tasks t 
+         JOIN expressions e ON e.id = t.expression_id 
+         WHERE t.completed = FALSE AND t.cancelled = FALSE 
+           AND (t.lease_expires_at IS NULL OR t.lease_expires_at <= ?) 
+           AND e.status = 'pending' 
+           AND (e.deadline IS NULL OR e.deadline > ?) 
+         ORDER BY e.priority DESC, e.deadline IS NULL, e.deadline ASC, t.id ASC 
+         LIMIT 1`, now, now).Scan(
+		&t.ID, &t.ExprID, &t.Arg1, &t.Arg2, &t.Operation, &t.OperationTime, &userID,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -242,28 +549,43 @@ func (s *Storage) GetPendingTask() (*Task, error) {
 		return nil, err
 	}
 
-	_, err = tx.Exec(
-		`UPDATE tasks SET started_at = datetime('now') WHERE id = ?`,
-		t.ID,
+	res, err := tx.Exec(
+		`UPDATE tasks SET started_at = datetime('now'), agent_id = ?, lease_expires_at = ? 
+         WHERE id = ? AND (lease_expires_at IS NULL OR lease_expires_at <= ?)`,
+		agentID, now.Add(lease), t.ID, now,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 
-	err = tx.Commit()
-	return t, err
+	t.AgentID = sql.NullString{String: agentID, Valid: true}
+	t.LeaseExpires = sql.NullTime{Time: now.Add(lease), Valid: true}
+	s.events.Publish(events.Event{
+		Type:         events.TaskDispatched,
+		ExpressionID: t.ExprID,
+		UserID:       userID,
+		TaskID:       t.ID,
+	})
+	return t, nil
 }
 
 func (s *Storage) GetTaskByID(id string) (*Task, error) {
 	t := &Task{}
 	err := s.db.QueryRow(
 		`SELECT id, expression_id, arg1, arg2, operation, operation_time, 
-		started_at, completed, result 
+		started_at, completed, cancelled, result, agent_id, lease_expires_at 
 		FROM tasks WHERE id = ?`,
 		id,
 	).Scan(
 		&t.ID, &t.ExprID, &t.Arg1, &t.Arg2, &t.Operation, &t.OperationTime,
-		&t.StartedAt, &t.Completed, &t.Result,
+		&t.StartedAt, &t.Completed, &t.Cancelled, &t.Result, &t.AgentID, &t.LeaseExpires,
 	)
 
 	if err != nil {
@@ -278,7 +600,7 @@ func (s *Storage) GetTaskByID(id string) (*Task, error) {
 func (s *Storage) GetTasksByExpressionID(exprID int) ([]*Task, error) {
 	rows, err := s.db.Query(
 		`SELECT id, arg1, arg2, operation, operation_time, 
-		started_at, completed, result 
+		started_at, completed, cancelled, result 
 		FROM tasks WHERE expression_id = ?`,
 		exprID,
 	)
@@ -292,7 +614,7 @@ func (s *Storage) GetTasksByExpressionID(exprID int) ([]*Task, error) {
 		t := &Task{ExprID: exprID}
 		err := rows.Scan(
 			&t.ID, &t.Arg1, &t.Arg2, &t.Operation, &t.OperationTime,
-			&t.StartedAt, &t.Completed, &t.Result,
+			&t.StartedAt, &t.Completed, &t.Cancelled, &t.Result,
 		)
 		if err != nil {
 			return nil, err
@@ -302,35 +624,49 @@ func (s *Storage) GetTasksByExpressionID(exprID int) ([]*Task, error) {
 	return tasks, nil
 }
 
-func (s *Storage) CompleteTask(taskID string, result float64) error {
+// CompleteTask records the result of an open task. Only the agent that
+// last leased the task may complete it; anyone else gets ErrLeaseNotHeld.
+func (s *Storage) CompleteTask(taskID, agentID string, result float64) error {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	var exprID int
+	var exprID, userID int
 	err = tx.QueryRow(
 		`UPDATE tasks 
-         SET completed = TRUE, result = ?
-         WHERE id = ? 
+         SET completed = TRUE, result = ?, lease_expires_at = NULL
+         WHERE id = ? AND agent_id = ? AND completed = FALSE AND cancelled = FALSE 
          RETURNING expression_id`,
-		result, taskID,
+		result, taskID, agentID,
 	).Scan(&exprID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrLeaseNotHeld
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update task: %v", err)
 	}
 
+	err = tx.QueryRow(
+		"SELECT user_id FROM expressions WHERE id = ?",
+		exprID,
+	).Scan(&userID)
+	if err != nil {
+		return fmt.Errorf("failed to get expression owner: %v", err)
+	}
+
 	var pendingCount int
 	err = tx.QueryRow(
 		`SELECT COUNT(*) FROM tasks 
-         WHERE expression_id = ? AND completed = FALSE`,
+         WHERE expression_id = ? AND completed = FALSE AND cancelled = FALSE`,
 		exprID,
 	).Scan(&pendingCount)
 	if err != nil {
 		return fmt.Errorf("failed to check pending tasks: %v", err)
 	}
 
+	var finished *events.Event
 	if pendingCount == 0 {
 		rows, err := tx.Query(
 			`SELECT operation, result FROM tasks 
@@ -359,11 +695,12 @@ func (s *Storage) CompleteTask(taskID string, result float64) error {
 			}
 		}
 
+		var res sql.Result
 		if hasError {
-			_, err = tx.Exec(
+			res, err = tx.Exec(
 				`UPDATE expressions 
-                 SET status = 'error'
-                 WHERE id = ?`,
+                 SET status = 'error', webhooks_pending = TRUE
+                 WHERE id = ? AND status = 'pending'`,
 				exprID,
 			)
 		} else {
@@ -376,10 +713,10 @@ func (s *Storage) CompleteTask(taskID string, result float64) error {
 				return fmt.Errorf("failed to calculate final result: %v", err)
 			}
 
-			_, err = tx.Exec(
+			res, err = tx.Exec(
 				`UPDATE expressions 
-                 SET status = 'completed', result = ?
-                 WHERE id = ?`,
+                 SET status = 'completed', result = ?, webhooks_pending = TRUE
+                 WHERE id = ? AND status = 'pending'`,
 				finalResult, exprID,
 			)
 		}
@@ -387,14 +724,147 @@ func (s *Storage) CompleteTask(taskID string, result float64) error {
 		if err != nil {
 			return fmt.Errorf("failed to update expression: %v", err)
 		}
+
+		if n, _ := res.RowsAffected(); n > 0 {
+			finished = &events.Event{
+				Type:         events.ExpressionFinished,
+				ExpressionID: exprID,
+				UserID:       userID,
+				Status:       "error",
+			}
+			if !hasError {
+				finished.Status = "completed"
+				finished.Result = &finalResult
+			}
+		}
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.events.Publish(events.Event{
+		Type:         events.TaskCompleted,
+		ExpressionID: exprID,
+		UserID:       userID,
+		TaskID:       taskID,
+		Result:       &result,
+	})
+	if finished != nil {
+		s.events.Publish(*finished)
+	}
+	return nil
 }
+
+func (s *Storage) ExpireExpressions(now time.Time) ([]int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`UPDATE expressions 
+         SET status = 'expired', webhooks_pending = TRUE
+         WHERE status = 'pending' AND deadline IS NOT NULL AND deadline <= ? 
+         RETURNING id, user_id`,
+		now.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("expire expressions: %w", err)
+	}
+
+	var ids []int
+	owners := make(map[int]int)
+	for rows.Next() {
+		var id, userID int
+		if err := rows.Scan(&id, &userID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("expire expressions: %w", err)
+		}
+		ids = append(ids, id)
+		owners[id] = userID
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if _, err := tx.Exec(
+			`UPDATE tasks SET cancelled = TRUE 
+             WHERE expression_id = ? AND completed = FALSE`,
+			id,
+		); err != nil {
+			return nil, fmt.Errorf("cancel tasks: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		s.events.Publish(events.Event{
+			Type:         events.ExpressionFinished,
+			ExpressionID: id,
+			UserID:       owners[id],
+			Status:       "expired",
+		})
+	}
+	return ids, nil
+}
+
+func (s *Storage) CancelExpression(id, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow(
+		"SELECT status FROM expressions WHERE id = ? AND user_id = ?",
+		id, userID,
+	).Scan(&status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("cancel expression: %w", err)
+	}
+	if status != "pending" {
+		return ErrNotPending
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE expressions SET status = 'cancelled', webhooks_pending = TRUE WHERE id = ?",
+		id,
+	); err != nil {
+		return fmt.Errorf("cancel expression: %w", err)
+	}
+	if _, err := tx.Exec(
+		`UPDATE tasks SET cancelled = TRUE 
+         WHERE expression_id = ? AND completed = FALSE`,
+		id,
+	); err != nil {
+		return fmt.Errorf("cancel tasks: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.events.Publish(events.Event{
+		Type:         events.ExpressionFinished,
+		ExpressionID: id,
+		UserID:       userID,
+		Status:       "cancelled",
+	})
+	return nil
+}
+
 func (s *Storage) GetPendingTasksCount() (int, error) {
 	var count int
 	err := s.db.QueryRow(
-		"SELECT COUNT(*) FROM tasks WHERE completed = FALSE",
+		"SELECT COUNT(*) FROM tasks WHERE completed = FALSE AND cancelled = FALSE",
 	).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("get pending tasks count: %w", err)
@@ -414,7 +884,11 @@ func (s *Storage) GetCompletedTasksCount() (int, error) {
 }
 
 func isDuplicate(err error) bool {
-	return err != nil && err.Error() == "UNIQUE constraint failed: users.login"
+	var serr sqlite3.Error
+	if !errors.As(err, &serr) {
+		return false
+	}
+	return serr.ExtendedCode == sqlite3.ErrConstraintUnique || serr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
 }
 
 func NewStorage(dbPath string) (*Storage, error) {
@@ -423,7 +897,7 @@ func NewStorage(dbPath string) (*Storage, error) {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
 
-	storage := &Storage{db: db}
+	storage := &Storage{db: db, events: events.NewBroker()}
 	if err := storage.Init(); err != nil {
 		return nil, fmt.Errorf("init: %w", err)
 	}
@@ -435,7 +909,19 @@ func (s *Storage) Init() error {
         CREATE TABLE IF NOT EXISTS users (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             login TEXT NOT NULL UNIQUE,
-            password TEXT NOT NULL
+            password TEXT NOT NULL,
+            role TEXT NOT NULL DEFAULT 'user',
+            disabled_at DATETIME,
+            failed_logins INTEGER NOT NULL DEFAULT 0,
+            locked_until DATETIME,
+            callback_secret TEXT
+        );
+
+        CREATE TABLE IF NOT EXISTS batches (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY(user_id) REFERENCES users(id)
         );
 
         CREATE TABLE IF NOT EXISTS expressions (
@@ -444,8 +930,14 @@ func (s *Storage) Init() error {
             expression TEXT NOT NULL,
             status TEXT NOT NULL,
             result REAL,
+            priority INTEGER NOT NULL DEFAULT 0,
+            deadline DATETIME,
+            batch_id INTEGER,
+            callback_url TEXT,
+            webhooks_pending BOOLEAN NOT NULL DEFAULT FALSE,
             created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-            FOREIGN KEY(user_id) REFERENCES users(id)
+            FOREIGN KEY(user_id) REFERENCES users(id),
+            FOREIGN KEY(batch_id) REFERENCES batches(id)
         );
 
         CREATE TABLE IF NOT EXISTS tasks (
@@ -457,9 +949,227 @@ func (s *Storage) Init() error {
             operation_time INTEGER NOT NULL,
             started_at DATETIME,
             completed BOOLEAN DEFAULT FALSE,
+            cancelled BOOLEAN DEFAULT FALSE,
             result REAL,
+            agent_id TEXT,
+            lease_expires_at DATETIME,
             FOREIGN KEY(expression_id) REFERENCES expressions(id)
         );
+
+        CREATE TABLE IF NOT EXISTS idempotency_keys (
+            user_id INTEGER NOT NULL,
+            key TEXT NOT NULL,
+            request_hash TEXT NOT NULL,
+            expression_id INTEGER,
+            created_at DATETIME NOT NULL,
+            PRIMARY KEY(user_id, key),
+            FOREIGN KEY(user_id) REFERENCES users(id),
+            FOREIGN KEY(expression_id) REFERENCES expressions(id)
+        );
+
+        CREATE TABLE IF NOT EXISTS sessions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            created_at DATETIME NOT NULL,
+            revoked_at DATETIME,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );
+
+        CREATE TABLE IF NOT EXISTS refresh_tokens (
+            token_hash TEXT PRIMARY KEY,
+            session_id INTEGER NOT NULL,
+            expires_at DATETIME NOT NULL,
+            used_at DATETIME,
+            created_at DATETIME NOT NULL,
+            FOREIGN KEY(session_id) REFERENCES sessions(id)
+        );
+
+        CREATE TABLE IF NOT EXISTS api_keys (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            name TEXT NOT NULL,
+            prefix TEXT NOT NULL,
+            key_hash TEXT NOT NULL UNIQUE,
+            scopes TEXT NOT NULL DEFAULT '',
+            expires_at DATETIME,
+            last_used_at DATETIME,
+            created_at DATETIME NOT NULL,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );
+
+        CREATE TABLE IF NOT EXISTS user_identities (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            issuer TEXT NOT NULL,
+            subject TEXT NOT NULL,
+            email TEXT NOT NULL DEFAULT '',
+            created_at DATETIME NOT NULL,
+            UNIQUE(issuer, subject),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );
+
+        CREATE TABLE IF NOT EXISTS expression_shares (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            expression_id INTEGER NOT NULL,
+            owner_id INTEGER NOT NULL,
+            grantee_id INTEGER,
+            token_hash TEXT UNIQUE,
+            created_at DATETIME NOT NULL,
+            UNIQUE(expression_id, grantee_id),
+            FOREIGN KEY(expression_id) REFERENCES expressions(id),
+            FOREIGN KEY(owner_id) REFERENCES users(id),
+            FOREIGN KEY(grantee_id) REFERENCES users(id)
+        );
+
+        CREATE TABLE IF NOT EXISTS audit_events (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            type TEXT NOT NULL,
+            user_id INTEGER,
+            login TEXT NOT NULL DEFAULT '',
+            ip TEXT NOT NULL DEFAULT '',
+            detail TEXT NOT NULL DEFAULT '',
+            created_at DATETIME NOT NULL
+        );
+
+        CREATE TABLE IF NOT EXISTS webhooks (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            url TEXT NOT NULL,
+            secret TEXT NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );
+
+        CREATE TABLE IF NOT EXISTS webhook_deliveries (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            webhook_id INTEGER,
+            expression_id INTEGER NOT NULL,
+            url TEXT NOT NULL,
+            payload TEXT NOT NULL,
+            status TEXT NOT NULL DEFAULT 'pending',
+            attempts INTEGER NOT NULL DEFAULT 0,
+            next_attempt_at DATETIME NOT NULL,
+            last_status_code INTEGER,
+            last_error TEXT,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            delivered_at DATETIME,
+            FOREIGN KEY(user_id) REFERENCES users(id),
+            FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE SET NULL,
+            FOREIGN KEY(expression_id) REFERENCES expressions(id)
+        );
+    `)
+	if err != nil {
+		return err
+	}
+
+	if err := s.addMissingColumns(); err != nil {
+		return err
+	}
+	if err := s.normalizeCreatedAt(); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
+        CREATE INDEX IF NOT EXISTS idx_expressions_schedule ON expressions(status, priority, deadline);
+        CREATE INDEX IF NOT EXISTS idx_expressions_batch ON expressions(batch_id);
+        CREATE INDEX IF NOT EXISTS idx_webhooks_user ON webhooks(user_id);
+        CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+        CREATE INDEX IF NOT EXISTS idx_expressions_webhooks_pending ON expressions(webhooks_pending);
+        CREATE INDEX IF NOT EXISTS idx_expressions_user ON expressions(user_id, id);
+        CREATE INDEX IF NOT EXISTS idx_expressions_user_status ON expressions(user_id, status, id);
+        CREATE INDEX IF NOT EXISTS idx_expressions_user_priority ON expressions(user_id, priority, id);
+        CREATE INDEX IF NOT EXISTS idx_expressions_user_created ON expressions(user_id, created_at);
+        CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys(created_at);
+        CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session ON refresh_tokens(session_id);
+        CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires ON refresh_tokens(expires_at);
+        CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+        CREATE INDEX IF NOT EXISTS idx_audit_events_type ON audit_events(type, id);
+        CREATE INDEX IF NOT EXISTS idx_audit_events_user ON audit_events(user_id, type, ip);
+        CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id);
+        CREATE INDEX IF NOT EXISTS idx_expression_shares_grantee ON expression_shares(grantee_id);
     `)
 	return err
 }
+
+// addedColumns lists the columns added to tables that already existed in
+// the first release. CREATE TABLE IF NOT EXISTS leaves such tables alone, so
+// databases created before a column was introduced get it from ALTER TABLE.
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+	{"users", "disabled_at", "DATETIME"},
+	{"users", "failed_logins", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "locked_until", "DATETIME"},
+	{"users", "callback_secret", "TEXT"},
+	{"expressions", "priority", "INTEGER NOT NULL DEFAULT 0"},
+	{"expressions", "deadline", "DATETIME"},
+	{"expressions", "batch_id", "INTEGER REFERENCES batches(id)"},
+	{"expressions", "callback_url", "TEXT"},
+	{"expressions", "webhooks_pending", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"tasks", "cancelled", "BOOLEAN DEFAULT FALSE"},
+	{"tasks", "agent_id", "TEXT"},
+	{"tasks", "lease_expires_at", "DATETIME"},
+}
+
+func (s *Storage) addMissingColumns() error {
+	existing := make(map[string]map[string]bool)
+	for _, c := range addedColumns {
+		columns, ok := existing[c.table]
+		if !ok {
+			var err error
+			if columns, err = s.tableColumns(c.table); err != nil {
+				return err
+			}
+			existing[c.table] = columns
+		}
+		if columns[c.column] {
+			continue
+		}
+		if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", c.table, c.column, err)
+		}
+		columns[c.column] = true
+	}
+	return nil
+}
+
+// normalizeCreatedAt rewrites expression timestamps stored by releases
+// that wrote local time, or left created_at to CURRENT_TIMESTAMP, in the UTC
+// form used since. created_after and created_before compare them as text,
+// which only orders correctly when every value has the same offset.
+func (s *Storage) normalizeCreatedAt() error {
+	_, err := s.db.Exec(
+		`UPDATE expressions
+		SET created_at = strftime('%Y-%m-%d %H:%M:%f+00:00', created_at)
+		WHERE created_at NOT LIKE '%+00:00'
+		AND strftime('%Y-%m-%d %H:%M:%f+00:00', created_at) IS NOT NULL`,
+	)
+	if err != nil {
+		return fmt.Errorf("normalize created_at: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) tableColumns(table string) (map[string]bool, error) {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("table info %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, fmt.Errorf("table info %s: %w", table, err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
@@ -1,8 +1,14 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
 	"os"
+	"strconv"
 	"testing"
+	"time"
+
+	"calc_service/internal/events"
 )
 
 func setupTestDB(t *testing.T) *Storage {
@@ -108,7 +114,7 @@ func TestTaskOperations(t *testing.T) {
 		t.Fatalf("CreateTask failed: %v", err)
 	}
 
-	gotTask, err := storage.GetPendingTask()
+	gotTask, err := storage.GetPendingTask("agent-1", time.Minute)
 	if err != nil {
 		t.Fatalf("GetPendingTask failed: %v", err)
 	}
@@ -117,7 +123,7 @@ func TestTaskOperations(t *testing.T) {
 		t.Errorf("Task data mismatch, got: %+v", gotTask)
 	}
 
-	err = storage.CompleteTask("task1", 4)
+	err = storage.CompleteTask("task1", "agent-1", 4)
 	if err != nil {
 		t.Fatalf("CompleteTask failed: %v", err)
 	}
@@ -131,3 +137,579 @@ func TestTaskOperations(t *testing.T) {
 		t.Errorf("Task not completed properly, got: %+v", completedTask)
 	}
 }
+
+func TestTaskScheduling(t *testing.T) {
+	storage := setupTestDB(t)
+
+	userID, _ := storage.CreateUser("testuser", "hash")
+
+	low, _ := storage.CreateScheduledExpression(userID, "1+1", 0, nil, "")
+	soon := time.Now().Add(time.Minute)
+	later := time.Now().Add(time.Hour)
+	highLate, _ := storage.CreateScheduledExpression(userID, "2+2", 5, &later, "")
+	highSoon, _ := storage.CreateScheduledExpression(userID, "3+3", 5, &soon, "")
+
+	for i, exprID := range []int{low.ID, highLate.ID, highSoon.ID} {
+		err := storage.CreateTask(&Task{
+			ID:            strconv.Itoa(i + 1),
+			ExprID:        exprID,
+			Arg1:          1,
+			Arg2:          1,
+			Operation:     "+",
+			OperationTime: 100,
+		})
+		if err != nil {
+			t.Fatalf("CreateTask failed: %v", err)
+		}
+	}
+
+	for _, want := range []int{highSoon.ID, highLate.ID, low.ID} {
+		task, err := storage.GetPendingTask("agent-1", time.Minute)
+		if err != nil {
+			t.Fatalf("GetPendingTask failed: %v", err)
+		}
+		if task.ExprID != want {
+			t.Errorf("Expected task of expression %d, got %d", want, task.ExprID)
+		}
+		if err := storage.CompleteTask(task.ID, "agent-1", 2); err != nil {
+			t.Fatalf("CompleteTask failed: %v", err)
+		}
+	}
+}
+
+func TestTaskLeases(t *testing.T) {
+	storage := setupTestDB(t)
+
+	userID, _ := storage.CreateUser("testuser", "hash")
+	expr, _ := storage.CreateExpression(userID, "2+2")
+	storage.CreateTask(&Task{ID: "1", ExprID: expr.ID, Arg1: 2, Arg2: 2, Operation: "+", OperationTime: 100})
+
+	if err := storage.CompleteTask("1", "agent-1", 4); err != ErrLeaseNotHeld {
+		t.Fatalf("Expected ErrLeaseNotHeld for an unleased task, got %v", err)
+	}
+
+	task, err := storage.GetPendingTask("agent-1", -time.Second)
+	if err != nil {
+		t.Fatalf("GetPendingTask failed: %v", err)
+	}
+	if task.AgentID.String != "agent-1" {
+		t.Errorf("Expected lease for agent-1, got %+v", task)
+	}
+
+	// The first lease has already expired, so the task goes to agent-2.
+	if _, err := storage.GetPendingTask("agent-2", time.Minute); err != nil {
+		t.Fatalf("GetPendingTask failed: %v", err)
+	}
+	if _, err := storage.GetPendingTask("agent-3", time.Minute); err != ErrNotFound {
+		t.Errorf("Expected a leased task to be skipped, got %v", err)
+	}
+
+	if err := storage.CompleteTask("1", "agent-1", 5); err != ErrLeaseNotHeld {
+		t.Errorf("Expected ErrLeaseNotHeld for the previous holder, got %v", err)
+	}
+	if err := storage.CompleteTask("1", "agent-2", 4); err != nil {
+		t.Fatalf("CompleteTask failed: %v", err)
+	}
+	if err := storage.CompleteTask("1", "agent-2", 4); err != ErrLeaseNotHeld {
+		t.Errorf("Expected a completed task to reject further results, got %v", err)
+	}
+
+	got, _ := storage.GetExpressionByID(expr.ID, userID)
+	if got.Status != "completed" || got.Result == nil || *got.Result != 4 {
+		t.Errorf("Expected result from the lease holder, got %+v", got)
+	}
+}
+
+func TestExpireExpressions(t *testing.T) {
+	storage := setupTestDB(t)
+
+	userID, _ := storage.CreateUser("testuser", "hash")
+	deadline := time.Now().Add(time.Minute)
+	expr, _ := storage.CreateScheduledExpression(userID, "2+2", 0, &deadline, "")
+	storage.CreateTask(&Task{
+		ID:            "1",
+		ExprID:        expr.ID,
+		Arg1:          2,
+		Arg2:          2,
+		Operation:     "+",
+		OperationTime: 100,
+	})
+
+	ids, err := storage.ExpireExpressions(time.Now())
+	if err != nil {
+		t.Fatalf("ExpireExpressions failed: %v", err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("Expected no expired expressions before deadline, got %v", ids)
+	}
+
+	ids, err = storage.ExpireExpressions(deadline.Add(time.Second))
+	if err != nil {
+		t.Fatalf("ExpireExpressions failed: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != expr.ID {
+		t.Errorf("Expected expression %d to expire, got %v", expr.ID, ids)
+	}
+
+	gotExpr, _ := storage.GetExpressionByID(expr.ID, userID)
+	if gotExpr.Status != "expired" {
+		t.Errorf("Expected status expired, got %s", gotExpr.Status)
+	}
+
+	task, _ := storage.GetTaskByID("1")
+	if !task.Cancelled {
+		t.Errorf("Expected task to be cancelled, got: %+v", task)
+	}
+
+	if _, err := storage.GetPendingTask("agent-1", time.Minute); err != ErrNotFound {
+		t.Errorf("Expected no pending tasks, got err: %v", err)
+	}
+}
+
+func TestBatchOperations(t *testing.T) {
+	storage := setupTestDB(t)
+
+	userID, _ := storage.CreateUser("testuser", "hash")
+	otherID, _ := storage.CreateUser("other", "hash")
+
+	items := []*BatchItem{
+		{Expression: "1+1", Tasks: []*Task{{ID: "1", Arg1: 1, Arg2: 1, Operation: "+", OperationTime: 100}}},
+		{Expression: "2*3", Priority: 3, Tasks: []*Task{{ID: "2", Arg1: 2, Arg2: 3, Operation: "*", OperationTime: 100}}},
+	}
+	batch, err := storage.CreateBatch(userID, items, 0)
+	if err != nil {
+		t.Fatalf("CreateBatch failed: %v", err)
+	}
+	if items[0].ID == 0 || items[1].ID == 0 {
+		t.Fatalf("Batch items were not assigned IDs: %+v", items)
+	}
+
+	task, err := storage.GetTaskByID("2")
+	if err != nil || task.ExprID != items[1].ID {
+		t.Errorf("Batch task not linked to its expression: %+v, err: %v", task, err)
+	}
+
+	got, err := storage.GetBatch(batch.ID, userID)
+	if err != nil {
+		t.Fatalf("GetBatch failed: %v", err)
+	}
+	if len(got.Expressions) != 2 || got.Expressions[1].Priority != 3 {
+		t.Errorf("Batch data mismatch, got: %+v", got.Expressions)
+	}
+
+	if _, err := storage.GetBatch(batch.ID, otherID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for another user's batch, got %v", err)
+	}
+
+	_, err = storage.CreateBatch(userID, []*BatchItem{
+		{Expression: "3+3", Tasks: []*Task{{ID: "1", Arg1: 3, Arg2: 3, Operation: "+", OperationTime: 100}}},
+	}, 0)
+	if err == nil {
+		t.Fatal("Expected error for duplicate task ID")
+	}
+	exprs, _ := storage.GetExpressions(userID)
+	if len(exprs) != 2 {
+		t.Errorf("Failed batch must not leave expressions behind, got %d", len(exprs))
+	}
+}
+
+func TestCompletionEvents(t *testing.T) {
+	storage := setupTestDB(t)
+
+	userID, _ := storage.CreateUser("testuser", "hash")
+	expr, _ := storage.CreateExpression(userID, "2+2")
+	storage.CreateTask(&Task{ID: "1", ExprID: expr.ID, Arg1: 2, Arg2: 2, Operation: "+", OperationTime: 100})
+
+	ch, cancel := storage.Events().Subscribe(expr.ID)
+	defer cancel()
+
+	if _, err := storage.GetPendingTask("agent-1", time.Minute); err != nil {
+		t.Fatalf("GetPendingTask failed: %v", err)
+	}
+	if err := storage.CompleteTask("1", "agent-1", 4); err != nil {
+		t.Fatalf("CompleteTask failed: %v", err)
+	}
+
+	var got []events.Event
+	for len(got) < 3 {
+		select {
+		case e := <-ch:
+			got = append(got, e)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for events, got: %+v", got)
+		}
+	}
+
+	wantTypes := []string{events.TaskDispatched, events.TaskCompleted, events.ExpressionFinished}
+	for i, want := range wantTypes {
+		if got[i].Type != want {
+			t.Errorf("Event %d: expected %s, got %s", i, want, got[i].Type)
+		}
+	}
+	if got[2].Status != "completed" || got[2].Result == nil || *got[2].Result != 4 {
+		t.Errorf("Unexpected finish event: %+v", got[2])
+	}
+}
+
+func TestWaitExpression(t *testing.T) {
+	storage := setupTestDB(t)
+
+	userID, _ := storage.CreateUser("testuser", "hash")
+	expr, _ := storage.CreateExpression(userID, "2+2")
+	storage.CreateTask(&Task{ID: "1", ExprID: expr.ID, Arg1: 2, Arg2: 2, Operation: "+", OperationTime: 100})
+	storage.GetPendingTask("agent-1", time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	got, err := storage.WaitExpression(ctx, expr.ID, userID)
+	if err != nil || got.Status != "pending" {
+		t.Fatalf("Expected pending expression after timeout, got %+v, err %v", got, err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		storage.CompleteTask("1", "agent-1", 4)
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	got, err = storage.WaitExpression(ctx, expr.ID, userID)
+	if err != nil {
+		t.Fatalf("WaitExpression failed: %v", err)
+	}
+	if got.Status != "completed" || *got.Result != 4 {
+		t.Errorf("Expected completed expression, got %+v", got)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("WaitExpression returned too late: %v", time.Since(start))
+	}
+}
+
+func TestListExpressions(t *testing.T) {
+	storage := setupTestDB(t)
+
+	userID, _ := storage.CreateUser("testuser", "hash")
+	otherID, _ := storage.CreateUser("other", "hash")
+
+	start := time.Now().Add(-time.Second)
+	for i, expr := range []string{"1+1", "2*2", "3-1", "4/2", "5+5"} {
+		if _, err := storage.CreateScheduledExpression(userID, expr, i%2, nil, ""); err != nil {
+			t.Fatalf("CreateScheduledExpression failed: %v", err)
+		}
+	}
+	storage.CreateExpression(otherID, "1+1")
+	storage.CancelExpression(2, userID)
+
+	var ids []int
+	cursor := ""
+	for {
+		page, next, err := storage.ListExpressions(userID, ExpressionFilter{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("ListExpressions failed: %v", err)
+		}
+		for _, e := range page {
+			ids = append(ids, e.ID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(ids) != 5 || ids[0] != 5 || ids[4] != 1 {
+		t.Errorf("Expected ids 5..1 across pages, got %v", ids)
+	}
+
+	page, _, _ := storage.ListExpressions(userID, ExpressionFilter{Sort: SortPriorityDesc, Limit: 3})
+	if len(page) != 3 || page[0].Priority != 1 || page[0].ID != 4 || page[2].Priority != 0 {
+		t.Errorf("Unexpected priority order: %+v", page)
+	}
+	rest, next, _ := storage.ListExpressions(userID, ExpressionFilter{Sort: SortPriorityDesc, Limit: 3, Cursor: listCursor{sort: SortPriorityDesc, filter: ExpressionFilter{}.filterHash(), priority: page[2].Priority, id: page[2].ID}.encode()})
+	if len(rest) != 2 || next != "" {
+		t.Errorf("Expected last 2 expressions on the second page, got %d (next %q)", len(rest), next)
+	}
+
+	page, _, _ = storage.ListExpressions(userID, ExpressionFilter{Statuses: []string{"cancelled"}})
+	if len(page) != 1 || page[0].ID != 2 {
+		t.Errorf("Status filter mismatch: %+v", page)
+	}
+
+	page, _, _ = storage.ListExpressions(userID, ExpressionFilter{Search: "+"})
+	if len(page) != 2 {
+		t.Errorf("Expected 2 expressions containing '+', got %d", len(page))
+	}
+
+	page, _, _ = storage.ListExpressions(userID, ExpressionFilter{Search: "%"})
+	if len(page) != 0 {
+		t.Errorf("Search must not treat %% as a wildcard, got %d", len(page))
+	}
+
+	future := time.Now().Add(time.Hour)
+	page, _, _ = storage.ListExpressions(userID, ExpressionFilter{CreatedAfter: &start, CreatedBefore: &future})
+	if len(page) != 5 {
+		t.Errorf("Expected 5 expressions in range, got %d", len(page))
+	}
+	page, _, _ = storage.ListExpressions(userID, ExpressionFilter{CreatedAfter: &future})
+	if len(page) != 0 {
+		t.Errorf("Expected no expressions after %v, got %d", future, len(page))
+	}
+
+	if _, _, err := storage.ListExpressions(userID, ExpressionFilter{Cursor: "bogus!"}); err != ErrInvalidCursor {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+	if _, _, err := storage.ListExpressions(userID, ExpressionFilter{Sort: "result"}); err != ErrInvalidSort {
+		t.Errorf("Expected ErrInvalidSort, got %v", err)
+	}
+
+	_, cursor, _ = storage.ListExpressions(userID, ExpressionFilter{Search: "+", Limit: 1})
+	if _, _, err := storage.ListExpressions(userID, ExpressionFilter{Search: "*", Limit: 1, Cursor: cursor}); err != ErrInvalidCursor {
+		t.Errorf("A cursor must not be reused with other filters, got %v", err)
+	}
+	if _, _, err := storage.ListExpressions(userID, ExpressionFilter{Search: "+", Sort: SortPriorityAsc, Limit: 1, Cursor: cursor}); err != ErrInvalidCursor {
+		t.Errorf("A cursor must not be reused with another sort order, got %v", err)
+	}
+	if _, _, err := storage.ListExpressions(userID, ExpressionFilter{Search: "+", Limit: 5, Cursor: cursor}); err != nil {
+		t.Errorf("A cursor must work with another page size, got %v", err)
+	}
+}
+
+func TestCreatedAtNormalizedToUTC(t *testing.T) {
+	storage := setupTestDB(t)
+	userID, _ := storage.CreateUser("testuser", "hash")
+
+	// Earlier releases stored local time with its offset.
+	local := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	_, err := storage.GetDB().Exec(
+		"INSERT INTO expressions (user_id, expression, status, created_at) VALUES (?, '1+1', 'completed', ?)",
+		userID, local,
+	)
+	if err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := storage.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	var stored string
+	storage.GetDB().QueryRow("SELECT CAST(created_at AS TEXT) FROM expressions").Scan(&stored)
+	if stored != "2024-05-01 09:00:00.000+00:00" {
+		t.Errorf("Expected created_at in UTC, got %q", stored)
+	}
+
+	after := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	page, _, _ := storage.ListExpressions(userID, ExpressionFilter{CreatedAfter: &after})
+	if len(page) != 0 {
+		t.Errorf("09:00 UTC is before %v, got %d expressions", after, len(page))
+	}
+	before := time.Date(2024, 5, 1, 13, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	page, _, _ = storage.ListExpressions(userID, ExpressionFilter{CreatedBefore: &before})
+	if len(page) != 1 || !page[0].CreatedAt.Equal(local) {
+		t.Errorf("Expected the expression before %v, got %+v", before, page)
+	}
+}
+
+func TestLoginFailuresAndAudit(t *testing.T) {
+	storage := setupTestDB(t)
+	userID, _ := storage.CreateUser("alice", "hash")
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if until, err := storage.RecordLoginFailure(userID, 3, time.Minute, now); err != nil || until != nil {
+			t.Fatalf("Expected no lock after %d failures, got %v, %v", i+1, until, err)
+		}
+	}
+	until, err := storage.RecordLoginFailure(userID, 3, time.Minute, now)
+	if err != nil || until == nil || !until.Equal(now.Add(time.Minute).UTC()) {
+		t.Fatalf("Expected a lock on the third failure, got %v, %v", until, err)
+	}
+	user, _ := storage.GetUserByID(userID)
+	if user.FailedLogins != 0 || user.LockedUntil == nil || !user.LockedUntil.Equal(*until) {
+		t.Errorf("Expected the lock to be stored and the count reset, got %+v", user)
+	}
+
+	if err := storage.ResetLoginFailures(userID); err != nil {
+		t.Fatalf("ResetLoginFailures failed: %v", err)
+	}
+	user, _ = storage.GetUserByLogin("alice")
+	if user.FailedLogins != 0 || user.LockedUntil != nil {
+		t.Errorf("Expected a clean record, got %+v", user)
+	}
+
+	storage.RecordAuditEvent(&AuditEvent{Type: "login_failed", Login: "mallory", IP: "10.0.0.1"})
+	storage.RecordAuditEvent(&AuditEvent{Type: "login_succeeded", UserID: userID, Login: "alice", IP: "10.0.0.2"})
+	storage.RecordAuditEvent(&AuditEvent{Type: "login_failed", UserID: userID, Login: "alice", IP: "10.0.0.2", Detail: "wrong password"})
+
+	events, err := storage.ListAuditEvents("login_failed", 10)
+	if err != nil {
+		t.Fatalf("ListAuditEvents failed: %v", err)
+	}
+	if len(events) != 2 || events[0].Login != "alice" || events[0].UserID != userID || events[1].UserID != 0 {
+		t.Errorf("Expected newest login_failed events first, got %+v", events)
+	}
+	if events, _ := storage.ListAuditEvents("", 1); len(events) != 1 || events[0].Detail != "wrong password" {
+		t.Errorf("Expected the limit to apply, got %+v", events)
+	}
+}
+
+func TestExpressionShares(t *testing.T) {
+	storage := setupTestDB(t)
+	ownerID, _ := storage.CreateUser("owner", "hash")
+	mateID, _ := storage.CreateUser("mate", "hash")
+	expr, _ := storage.CreateExpression(ownerID, "1+1")
+
+	if _, err := storage.CreateShare(expr.ID, mateID, ownerID, ""); err != ErrNotFound {
+		t.Errorf("Expected only the owner to share, got %v", err)
+	}
+	share, err := storage.CreateShare(expr.ID, ownerID, mateID, "")
+	if err != nil {
+		t.Fatalf("CreateShare failed: %v", err)
+	}
+	if _, err := storage.CreateShare(expr.ID, ownerID, mateID, ""); err != ErrAlreadyExists {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+	if _, err := storage.CreateShare(expr.ID, ownerID, 0, "hash"); err != nil {
+		t.Fatalf("CreateShare for a link failed: %v", err)
+	}
+
+	got, err := storage.GetExpressionByID(expr.ID, mateID)
+	if err != nil || got.UserID != ownerID {
+		t.Fatalf("Expected the grantee to read the owner's expression, got %+v, %v", got, err)
+	}
+	if got, err := storage.GetSharedExpression("hash"); err != nil || got.ID != expr.ID {
+		t.Errorf("Expected the link to resolve, got %+v, %v", got, err)
+	}
+
+	shares, _ := storage.ListShares(expr.ID, ownerID)
+	if len(shares) != 2 || shares[0].GranteeID != mateID || !shares[1].Public() {
+		t.Errorf("Unexpected shares %+v", shares)
+	}
+	if shared, err := storage.ListSharedExpressions(mateID); err != nil || len(shared) != 1 || shared[0].UserID != ownerID {
+		t.Errorf("Expected the expression in the grantee's list, got %+v, %v", shared, err)
+	}
+	if shared, _ := storage.ListSharedExpressions(ownerID); len(shared) != 0 {
+		t.Errorf("Expected nothing shared with the owner, got %+v", shared)
+	}
+
+	if err := storage.DeleteShare(share.ID, expr.ID, ownerID); err != nil {
+		t.Fatalf("DeleteShare failed: %v", err)
+	}
+	if _, err := storage.GetExpressionByID(expr.ID, mateID); err != ErrNotFound {
+		t.Errorf("Expected access to end with the share, got %v", err)
+	}
+	if err := storage.DeleteExpression(expr.ID, ownerID); err != nil {
+		t.Errorf("Expected an expression with a link to be deletable, got %v", err)
+	}
+}
+
+// firstReleaseSchema is the schema created by the first release, before any
+// column was added to these tables.
+const firstReleaseSchema = `
+    CREATE TABLE users (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        login TEXT NOT NULL UNIQUE,
+        password TEXT NOT NULL
+    );
+    CREATE TABLE expressions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        expression TEXT NOT NULL,
+        status TEXT NOT NULL,
+        result REAL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY(user_id) REFERENCES users(id)
+    );
+    CREATE TABLE tasks (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        expression_id INTEGER NOT NULL,
+        arg1 REAL NOT NULL,
+        arg2 REAL NOT NULL,
+        operation TEXT NOT NULL,
+        operation_time INTEGER NOT NULL,
+        started_at DATETIME,
+        completed BOOLEAN DEFAULT FALSE,
+        result REAL,
+        FOREIGN KEY(expression_id) REFERENCES expressions(id)
+    );
+    INSERT INTO users (login, password) VALUES ('olduser', 'hash');
+    INSERT INTO expressions (user_id, expression, status) VALUES (1, '2+2', 'pending');
+`
+
+func TestUpgradeFirstReleaseSchema(t *testing.T) {
+	dbPath := "test_upgrade.sqlite"
+	os.Remove(dbPath)
+	t.Cleanup(func() { os.Remove(dbPath) })
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open db: %v", err)
+	}
+	if _, err := db.Exec(firstReleaseSchema); err != nil {
+		t.Fatalf("Failed to create first release schema: %v", err)
+	}
+	db.Close()
+
+	storage, err := NewStorage(dbPath)
+	if err != nil {
+		t.Fatalf("NewStorage on first release schema failed: %v", err)
+	}
+	defer storage.GetDB().Close()
+	if err := storage.Init(); err != nil {
+		t.Fatalf("Init on upgraded schema failed: %v", err)
+	}
+
+	user, err := storage.GetUserByLogin("olduser")
+	if err != nil {
+		t.Fatalf("GetUserByLogin failed: %v", err)
+	}
+	if user.Role != "user" || user.FailedLogins != 0 || user.LockedUntil != nil {
+		t.Errorf("Expected defaults for added user columns, got %+v", user)
+	}
+
+	expr, err := storage.GetExpressionByID(1, user.ID)
+	if err != nil {
+		t.Fatalf("GetExpressionByID failed: %v", err)
+	}
+	if expr.Priority != 0 || expr.Deadline != nil || expr.Expression != "2+2" {
+		t.Errorf("Unexpected upgraded expression: %+v", expr)
+	}
+
+	if _, err := storage.CreateScheduledExpression(user.ID, "3*3", 5, nil, ""); err != nil {
+		t.Fatalf("CreateScheduledExpression failed: %v", err)
+	}
+	page, _, err := storage.ListExpressions(user.ID, ExpressionFilter{Sort: SortPriorityDesc})
+	if err != nil {
+		t.Fatalf("ListExpressions failed: %v", err)
+	}
+	if len(page) != 2 {
+		t.Errorf("Expected 2 expressions after upgrade, got %d", len(page))
+	}
+}
+
+func TestPendingLimit(t *testing.T) {
+	storage := setupTestDB(t)
+	userID, _ := storage.CreateUser("testuser", "hash")
+
+	for i := 1; i <= 2; i++ {
+		item := &BatchItem{Expression: "1+1", Tasks: []*Task{{ID: strconv.Itoa(i), Arg1: 1, Arg2: 1, Operation: "+", OperationTime: 100}}}
+		e, err := storage.CreatePendingExpression(userID, item, 2, "")
+		if err != nil {
+			t.Fatalf("CreatePendingExpression failed: %v", err)
+		}
+		if tasks, _ := storage.GetTasksByExpressionID(e.ID); len(tasks) != 1 {
+			t.Errorf("Expected the task to be stored with the expression, got %d", len(tasks))
+		}
+	}
+
+	if _, err := storage.CreatePendingExpression(userID, &BatchItem{Expression: "2+2"}, 2, ""); err != ErrTooManyPending {
+		t.Errorf("Expected ErrTooManyPending, got %v", err)
+	}
+	if _, err := storage.CreateBatch(userID, []*BatchItem{{Expression: "2+2"}}, 2); err != ErrTooManyPending {
+		t.Errorf("Expected ErrTooManyPending for a batch, got %v", err)
+	}
+	if n, _ := storage.CountPendingExpressions(userID); n != 2 {
+		t.Errorf("Expected refused submissions to store nothing, got %d pending", n)
+	}
+	if _, err := storage.CreatePendingExpression(userID, &BatchItem{Expression: "2+2"}, 0, ""); err != nil {
+		t.Errorf("Expected no limit with maxPending 0, got %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Webhook struct {
+	ID        int
+	UserID    int
+	URL       string
+	Secret    string
+	CreatedAt time.Time
+}
+
+type WebhookDelivery struct {
+	ID             int
+	UserID         int
+	WebhookID      *int
+	ExpressionID   int
+	URL            string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode *int
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+}
+
+func (s *Storage) CreateWebhook(userID int, url, secret string) (*Webhook, error) {
+	w := &Webhook{
+		UserID:    userID,
+		URL:       url,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+
+	err := s.db.QueryRow(
+		`INSERT INTO webhooks (user_id, url, secret, created_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING id`,
+		w.UserID, w.URL, w.Secret, w.CreatedAt,
+	).Scan(&w.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create webhook: %w", err)
+	}
+	return w, nil
+}
+
+func (s *Storage) GetWebhooks(userID int) ([]*Webhook, error) {
+	rows, err := s.db.Query(
+		`SELECT id, url, secret, created_at
+		FROM webhooks
+		WHERE user_id = ?
+		ORDER BY id ASC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var hooks []*Webhook
+	for rows.Next() {
+		w := &Webhook{UserID: userID}
+		if err := rows.Scan(&w.ID, &w.URL, &w.Secret, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+func (s *Storage) GetWebhookByID(id int) (*Webhook, error) {
+	w := &Webhook{ID: id}
+	err := s.db.QueryRow(
+		"SELECT user_id, url, secret, created_at FROM webhooks WHERE id = ?",
+		id,
+	).Scan(&w.UserID, &w.URL, &w.Secret, &w.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("get webhook: %w", err)
+	}
+	return w, nil
+}
+
+// SetCallbackSecret replaces the secret the user's callback_url deliveries
+// are signed with.
+func (s *Storage) SetCallbackSecret(userID int, secret string) error {
+	res, err := s.db.Exec("UPDATE users SET callback_secret = ? WHERE id = ?", secret, userID)
+	if err != nil {
+		return fmt.Errorf("set callback secret: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetCallbackSecret returns the user's callback secret, or an empty string
+// if they have not created one.
+func (s *Storage) GetCallbackSecret(userID int) (string, error) {
+	var secret sql.NullString
+	err := s.db.QueryRow("SELECT callback_secret FROM users WHERE id = ?", userID).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("get callback secret: %w", err)
+	}
+	return secret.String, nil
+}
+
+func (s *Storage) DeleteWebhook(id, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE webhook_deliveries SET status = 'cancelled'
+		WHERE webhook_id = ? AND user_id = ? AND status = 'pending'`,
+		id, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("cancel webhook deliveries: %w", err)
+	}
+
+	res, err := tx.Exec(
+		"DELETE FROM webhooks WHERE id = ? AND user_id = ?",
+		id, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete webhook: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// GetExpressionsAwaitingWebhooks returns finished expressions whose webhook
+// deliveries have not been queued yet. The flag is set in the transaction
+// that finishes the expression, so none are missed if the process stops
+// before the dispatcher gets to them.
+func (s *Storage) GetExpressionsAwaitingWebhooks(limit int) ([]*Expression, error) {
+	rows, err := s.db.Query(
+		"SELECT id FROM expressions WHERE webhooks_pending = TRUE ORDER BY id LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get expressions awaiting webhooks: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	exprs := make([]*Expression, 0, len(ids))
+	for _, id := range ids {
+		e, err := s.getExpression("WHERE id = ?", id)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+	return exprs, nil
+}
+
+// QueueWebhookDeliveries stores the deliveries of a finished expression and
+// clears its webhooks_pending flag in one transaction. Nothing is stored if
+// the deliveries were queued already.
+func (s *Storage) QueueWebhookDeliveries(exprID int, deliveries []*WebhookDelivery) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"UPDATE expressions SET webhooks_pending = FALSE WHERE id = ? AND webhooks_pending = TRUE",
+		exprID,
+	)
+	if err != nil {
+		return fmt.Errorf("queue webhook deliveries: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	for _, d := range deliveries {
+		d.Status = "pending"
+		d.CreatedAt = now
+		err := tx.QueryRow(
+			`INSERT INTO webhook_deliveries
+			(user_id, webhook_id, expression_id, url, payload, status, next_attempt_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id`,
+			d.UserID, d.WebhookID, d.ExpressionID, d.URL, d.Payload, d.Status,
+			d.NextAttemptAt.UTC(), d.CreatedAt,
+		).Scan(&d.ID)
+		if err != nil {
+			return fmt.Errorf("create webhook delivery: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) GetDueWebhookDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error) {
+	rows, err := s.db.Query(
+		`SELECT id, user_id, webhook_id, expression_id, url, payload, attempts
+		FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= ?
+		ORDER BY next_attempt_at ASC
+		LIMIT ?`,
+		now.UTC(), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get due webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d := &WebhookDelivery{Status: "pending"}
+		var webhookID sql.NullInt64
+		err := rows.Scan(&d.ID, &d.UserID, &webhookID, &d.ExpressionID, &d.URL, &d.Payload, &d.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		if webhookID.Valid {
+			id := int(webhookID.Int64)
+			d.WebhookID = &id
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *Storage) UpdateWebhookDelivery(d *WebhookDelivery) error {
+	var deliveredAt interface{}
+	if d.DeliveredAt != nil {
+		deliveredAt = d.DeliveredAt.UTC()
+	}
+
+	_, err := s.db.Exec(
+		`UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, next_attempt_at = ?, last_status_code = ?,
+		last_error = ?, delivered_at = ?
+		WHERE id = ?`,
+		d.Status, d.Attempts, d.NextAttemptAt.UTC(), d.LastStatusCode,
+		d.LastError, deliveredAt, d.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("update webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) GetWebhookDeliveries(userID, limit int) ([]*WebhookDelivery, error) {
+	rows, err := s.db.Query(
+		`SELECT id, webhook_id, expression_id, url, status, attempts, next_attempt_at,
+		last_status_code, last_error, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE user_id = ?
+		ORDER BY id DESC
+		LIMIT ?`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d := &WebhookDelivery{UserID: userID}
+		var webhookID, statusCode sql.NullInt64
+		var lastError sql.NullString
+		var deliveredAt sql.NullTime
+		err := rows.Scan(
+			&d.ID, &webhookID, &d.ExpressionID, &d.URL, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &statusCode, &lastError, &d.CreatedAt, &deliveredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if webhookID.Valid {
+			id := int(webhookID.Int64)
+			d.WebhookID = &id
+		}
+		if statusCode.Valid {
+			code := int(statusCode.Int64)
+			d.LastStatusCode = &code
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		d.LastError = lastError.String
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,377 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"calc_service/internal/events"
+	"calc_service/internal/storage"
+)
+
+const (
+	EventExpressionFinished = "expression.finished"
+
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	pollInterval = 500 * time.Millisecond
+	batchSize    = 50
+)
+
+// ErrAddressNotAllowed is returned for deliveries to loopback, link-local,
+// private or unspecified addresses that are not in Config.AllowedNetworks.
+var ErrAddressNotAllowed = errors.New("webhook address is not allowed")
+
+// ErrNoSecret is returned for callback_url deliveries of a user without a
+// callback secret; they are not sent unsigned.
+var ErrNoSecret = errors.New("callback_url deliveries require a callback secret")
+
+type Config struct {
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	Timeout     time.Duration
+	// Workers is how many receivers are delivered to at once.
+	Workers int
+	// AllowedNetworks are internal networks deliveries may still reach.
+	AllowedNetworks []netip.Prefix
+}
+
+type Payload struct {
+	Event      string                 `json:"event"`
+	Expression map[string]interface{} `json:"expression"`
+	Timestamp  time.Time              `json:"timestamp"`
+}
+
+type Dispatcher struct {
+	storage *storage.Storage
+	client  *http.Client
+	cfg     Config
+	workers chan struct{}
+}
+
+func NewDispatcher(s *storage.Storage, cfg Config) *Dispatcher {
+	// The address is checked when the connection is dialled rather than when
+	// the URL is accepted, so a host that later resolves to an internal
+	// address, or a redirect to one, is refused too.
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return cfg.checkAddress(address)
+		},
+	}
+	return &Dispatcher{
+		storage: s,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+		cfg:     cfg,
+		workers: make(chan struct{}, max(cfg.Workers, 1)),
+	}
+}
+
+func (cfg Config) checkAddress(address string) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrAddressNotAllowed, address)
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range cfg.AllowedNetworks {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsMulticast() {
+		return fmt.Errorf("%w: %s", ErrAddressNotAllowed, addr)
+	}
+	return nil
+}
+
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Run queues and sends deliveries until ctx is cancelled. Finished
+// expressions only wake the worker; which expressions still need deliveries
+// is read from storage, so events the broker drops are picked up on the next
+// tick.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ch, cancel := d.storage.Events().SubscribeAll()
+	defer cancel()
+
+	wake := make(chan struct{}, 1)
+	go d.work(ctx, wake)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-ch:
+			if e.Type != events.ExpressionFinished {
+				continue
+			}
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// work runs apart from the event loop so that slow receivers cannot hold up
+// the subscription.
+func (d *Dispatcher) work(ctx context.Context, wake <-chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Enqueue(); err != nil {
+			log.Printf("Failed to enqueue webhooks: %v", err)
+		}
+		d.DeliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Enqueue queues deliveries for the finished expressions still waiting for
+// them.
+func (d *Dispatcher) Enqueue() error {
+	exprs, err := d.storage.GetExpressionsAwaitingWebhooks(batchSize)
+	if err != nil {
+		return err
+	}
+	for _, expr := range exprs {
+		if err := d.enqueue(expr); err != nil {
+			return fmt.Errorf("expression %d: %w", expr.ID, err)
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) enqueue(expr *storage.Expression) error {
+	hooks, err := d.storage.GetWebhooks(expr.UserID)
+	if err != nil {
+		return err
+	}
+
+	item := map[string]interface{}{
+		"id":         strconv.Itoa(expr.ID),
+		"expression": expr.Expression,
+		"status":     expr.Status,
+	}
+	if expr.Result != nil {
+		item["result"] = *expr.Result
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(Payload{
+		Event:      EventExpressionFinished,
+		Expression: item,
+		Timestamp:  now.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	var deliveries []*storage.WebhookDelivery
+	if expr.CallbackURL != "" {
+		deliveries = append(deliveries, &storage.WebhookDelivery{
+			UserID:        expr.UserID,
+			ExpressionID:  expr.ID,
+			URL:           expr.CallbackURL,
+			Payload:       string(payload),
+			NextAttemptAt: now,
+		})
+	}
+	for _, hook := range hooks {
+		deliveries = append(deliveries, &storage.WebhookDelivery{
+			UserID:        expr.UserID,
+			WebhookID:     &hook.ID,
+			ExpressionID:  expr.ID,
+			URL:           hook.URL,
+			Payload:       string(payload),
+			NextAttemptAt: now,
+		})
+	}
+	return d.storage.QueueWebhookDeliveries(expr.ID, deliveries)
+}
+
+// DeliverDue attempts the deliveries that are due. They are grouped by
+// receiver host and up to Config.Workers hosts are served at once; a host
+// gets its deliveries one at a time and the rest wait for the next round
+// after the first failure, so a slow or unreachable receiver holds up one
+// worker for at most one timeout and nobody else's deliveries.
+func (d *Dispatcher) DeliverDue(ctx context.Context) {
+	deliveries, err := d.storage.GetDueWebhookDeliveries(time.Now(), batchSize)
+	if err != nil {
+		log.Printf("Failed to load webhook deliveries: %v", err)
+		return
+	}
+
+	var hosts []string
+	byHost := make(map[string][]*storage.WebhookDelivery)
+	for _, delivery := range deliveries {
+		host := delivery.URL
+		if u, err := url.Parse(delivery.URL); err == nil {
+			host = u.Host
+		}
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], delivery)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, host := range hosts {
+		select {
+		case <-ctx.Done():
+			return
+		case d.workers <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(queue []*storage.WebhookDelivery) {
+			defer wg.Done()
+			defer func() { <-d.workers }()
+			for _, delivery := range queue {
+				if ctx.Err() != nil || !d.attempt(ctx, delivery) {
+					return
+				}
+			}
+		}(byHost[host])
+	}
+}
+
+// secretFor returns the key a delivery is signed with: the webhook's own
+// secret, or for callback_url the owner's callback secret, so that no
+// receiver holds a key valid for anyone else's deliveries.
+func (d *Dispatcher) secretFor(delivery *storage.WebhookDelivery) (string, error) {
+	if delivery.WebhookID == nil {
+		secret, err := d.storage.GetCallbackSecret(delivery.UserID)
+		if err != nil {
+			return "", err
+		}
+		if secret == "" {
+			return "", ErrNoSecret
+		}
+		return secret, nil
+	}
+	hook, err := d.storage.GetWebhookByID(*delivery.WebhookID)
+	if err != nil {
+		return "", err
+	}
+	return hook.Secret, nil
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.BaseBackoff << (attempts - 1)
+	if delay <= 0 || delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+	return delay
+}
+
+// attempt sends a delivery once and records the outcome. It reports whether
+// the delivery went through.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *storage.WebhookDelivery) bool {
+	delivery.Attempts++
+
+	code, err := d.post(ctx, delivery)
+	now := time.Now()
+	if code != 0 {
+		delivery.LastStatusCode = &code
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = "delivered"
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = now
+	case errors.Is(err, storage.ErrNotFound):
+		delivery.Status = "failed"
+		delivery.LastError = "webhook no longer exists"
+		delivery.NextAttemptAt = now
+	case errors.Is(err, ErrAddressNotAllowed), errors.Is(err, ErrNoSecret):
+		delivery.Status = "failed"
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now
+	case delivery.Attempts >= d.cfg.MaxAttempts:
+		delivery.Status = "failed"
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+	}
+
+	if err := d.storage.UpdateWebhookDelivery(delivery); err != nil {
+		log.Printf("Failed to update webhook delivery %d: %v", delivery.ID, err)
+	}
+	return delivery.Status == "delivered"
+}
+
+func (d *Dispatcher) post(ctx context.Context, delivery *storage.WebhookDelivery) (int, error) {
+	secret, err := d.secretFor(delivery)
+	if err != nil {
+		return 0, err
+	}
+
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.ID))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,307 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"calc_service/internal/storage"
+)
+
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	payloads []Payload
+	sigOK    []bool
+}
+
+func (rc *receiver) handler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		if rc.failures > 0 {
+			rc.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var p Payload
+		json.Unmarshal(body, &p)
+		rc.payloads = append(rc.payloads, p)
+		rc.sigOK = append(rc.sigOK, Verify(
+			secret, r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader),
+		))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (rc *receiver) received() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.payloads)
+}
+
+func setupStorage(t *testing.T) *storage.Storage {
+	stor, err := storage.NewStorage(filepath.Join(t.TempDir(), "webhooks.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { stor.GetDB().Close() })
+	return stor
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for webhook delivery")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCallbackDeliveryWithRetries(t *testing.T) {
+	stor := setupStorage(t)
+
+	rc := &receiver{failures: 2}
+	srv := httptest.NewServer(rc.handler("user-secret"))
+	defer srv.Close()
+
+	d := NewDispatcher(stor, Config{
+		MaxAttempts:     5,
+		BaseBackoff:     10 * time.Millisecond,
+		MaxBackoff:      50 * time.Millisecond,
+		Timeout:         time.Second,
+		AllowedNetworks: loopback,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	// Another user's callback secret must not verify this user's deliveries.
+	otherID, _ := stor.CreateUser("otheruser", "hash")
+	stor.SetCallbackSecret(otherID, "other-secret")
+	userID, _ := stor.CreateUser("hookuser", "hash")
+	stor.SetCallbackSecret(userID, "user-secret")
+	expr, _ := stor.CreateScheduledExpression(userID, "2+2", 0, nil, srv.URL)
+	stor.CreateTask(&storage.Task{ID: "1", ExprID: expr.ID, Arg1: 2, Arg2: 2, Operation: "+", OperationTime: 100})
+
+	stor.GetPendingTask("agent-1", time.Minute)
+	if err := stor.CompleteTask("1", "agent-1", 4); err != nil {
+		t.Fatalf("CompleteTask failed: %v", err)
+	}
+
+	waitFor(t, func() bool { return rc.received() == 1 })
+
+	rc.mu.Lock()
+	p, sigOK := rc.payloads[0], rc.sigOK[0]
+	rc.mu.Unlock()
+
+	if !sigOK {
+		t.Error("Webhook signature did not verify")
+	}
+	if p.Event != EventExpressionFinished || p.Expression["status"] != "completed" || p.Expression["result"] != 4.0 {
+		t.Errorf("Unexpected payload: %+v", p)
+	}
+
+	var deliveries []*storage.WebhookDelivery
+	waitFor(t, func() bool {
+		deliveries, _ = stor.GetWebhookDeliveries(userID, 10)
+		return len(deliveries) == 1 && deliveries[0].Status == "delivered"
+	})
+	if deliveries[0].Attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", deliveries[0].Attempts)
+	}
+}
+
+func TestDeliveriesSurviveMissedEvents(t *testing.T) {
+	stor := setupStorage(t)
+
+	rc := &receiver{}
+	srv := httptest.NewServer(rc.handler("hook-secret"))
+	defer srv.Close()
+
+	userID, _ := stor.CreateUser("hookuser", "hash")
+	stor.CreateWebhook(userID, srv.URL, "hook-secret")
+	expr, _ := stor.CreateExpression(userID, "1+1")
+
+	// The expression finishes while no dispatcher is listening, as after a
+	// crash or when the broker drops the event.
+	if err := stor.CancelExpression(expr.ID, userID); err != nil {
+		t.Fatalf("CancelExpression failed: %v", err)
+	}
+
+	d := NewDispatcher(stor, Config{MaxAttempts: 3, Timeout: time.Second, AllowedNetworks: loopback})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	waitFor(t, func() bool { return rc.received() == 1 })
+
+	if err := d.Enqueue(); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if deliveries, _ := stor.GetWebhookDeliveries(userID, 10); len(deliveries) != 1 {
+		t.Errorf("Expected exactly one delivery, got %d", len(deliveries))
+	}
+}
+
+func TestCallbackRequiresSecret(t *testing.T) {
+	stor := setupStorage(t)
+	d := NewDispatcher(stor, Config{MaxAttempts: 5, Timeout: time.Second})
+
+	userID, _ := stor.CreateUser("hookuser", "hash")
+	expr, _ := stor.CreateScheduledExpression(userID, "2+2", 0, nil, "https://example.com/hook")
+	stor.CancelExpression(expr.ID, userID)
+
+	if err := d.Enqueue(); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	d.DeliverDue(context.Background())
+
+	deliveries, _ := stor.GetWebhookDeliveries(userID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != "failed" || deliveries[0].LastError != ErrNoSecret.Error() {
+		t.Errorf("Expected the unsigned callback_url delivery to fail, got %+v", deliveries)
+	}
+}
+
+func TestSubscriptionDeliveryGivesUp(t *testing.T) {
+	stor := setupStorage(t)
+
+	rc := &receiver{failures: 100}
+	srv := httptest.NewServer(rc.handler(""))
+	defer srv.Close()
+
+	d := NewDispatcher(stor, Config{
+		MaxAttempts:     2,
+		BaseBackoff:     10 * time.Millisecond,
+		MaxBackoff:      10 * time.Millisecond,
+		Timeout:         time.Second,
+		AllowedNetworks: loopback,
+	})
+
+	userID, _ := stor.CreateUser("hookuser", "hash")
+	hook, _ := stor.CreateWebhook(userID, srv.URL, "hook-secret")
+	expr, _ := stor.CreateExpression(userID, "1+1")
+
+	if err := stor.CancelExpression(expr.ID, userID); err != nil {
+		t.Fatalf("CancelExpression failed: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := d.Enqueue(); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	d.DeliverDue(ctx)
+	time.Sleep(20 * time.Millisecond)
+	d.DeliverDue(ctx)
+
+	deliveries, _ := stor.GetWebhookDeliveries(userID, 10)
+	if len(deliveries) != 1 {
+		t.Fatalf("Expected one delivery, got %d", len(deliveries))
+	}
+	got := deliveries[0]
+	if got.Status != "failed" || got.Attempts != 2 || *got.WebhookID != hook.ID {
+		t.Errorf("Unexpected delivery state: %+v", got)
+	}
+	if got.LastStatusCode == nil || *got.LastStatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected last status 503, got %v", got.LastStatusCode)
+	}
+}
+
+func TestInternalAddressesRefused(t *testing.T) {
+	stor := setupStorage(t)
+
+	rc := &receiver{}
+	srv := httptest.NewServer(rc.handler(""))
+	defer srv.Close()
+
+	d := NewDispatcher(stor, Config{MaxAttempts: 5, Timeout: time.Second})
+
+	userID, _ := stor.CreateUser("hookuser", "hash")
+	stor.CreateWebhook(userID, srv.URL, "hook-secret")
+	expr, _ := stor.CreateExpression(userID, "1+1")
+	stor.CancelExpression(expr.ID, userID)
+
+	if err := d.Enqueue(); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	d.DeliverDue(context.Background())
+
+	deliveries, _ := stor.GetWebhookDeliveries(userID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != "failed" || deliveries[0].Attempts != 1 {
+		t.Fatalf("Expected the loopback delivery to fail without retries, got %+v", deliveries[0])
+	}
+	if rc.received() != 0 {
+		t.Error("Expected nothing to reach the loopback receiver")
+	}
+
+	for _, addr := range []string{"127.0.0.1:80", "10.1.2.3:443", "169.254.169.254:80", "[::1]:80", "0.0.0.0:80", "[::ffff:192.168.0.1]:80"} {
+		if err := (Config{}).checkAddress(addr); !errors.Is(err, ErrAddressNotAllowed) {
+			t.Errorf("Expected %s to be refused, got %v", addr, err)
+		}
+	}
+	if err := (Config{}).checkAddress("93.184.216.34:443"); err != nil {
+		t.Errorf("Expected a public address to be allowed, got %v", err)
+	}
+}
+
+func TestSlowReceiverDoesNotBlockOthers(t *testing.T) {
+	stor := setupStorage(t)
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer slow.Close()
+	rc := &receiver{}
+	fast := httptest.NewServer(rc.handler("hook-secret"))
+	defer fast.Close()
+
+	d := NewDispatcher(stor, Config{MaxAttempts: 3, Timeout: 5 * time.Second, Workers: 2, AllowedNetworks: loopback})
+
+	// The slow receiver's delivery is due first, and has a second one queued
+	// behind it.
+	var users []int
+	for _, u := range []struct{ login, url string }{{"slowuser", slow.URL}, {"slowuser2", slow.URL}, {"fastuser", fast.URL}} {
+		userID, _ := stor.CreateUser(u.login, "hash")
+		users = append(users, userID)
+		stor.CreateWebhook(userID, u.url, "hook-secret")
+		expr, _ := stor.CreateExpression(userID, "1+1")
+		stor.CancelExpression(expr.ID, userID)
+	}
+	if err := d.Enqueue(); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.DeliverDue(context.Background())
+		close(done)
+	}()
+
+	waitFor(t, func() bool { return rc.received() == 1 })
+	close(release)
+	<-done
+
+	for _, userID := range users {
+		if deliveries, _ := stor.GetWebhookDeliveries(userID, 10); len(deliveries) != 1 || deliveries[0].Status != "delivered" {
+			t.Errorf("Expected user %d's delivery to go through, got %+v", userID, deliveries)
+		}
+	}
+}